@@ -16,10 +16,14 @@ package gengapic
 
 import (
 	"fmt"
+	"go/token"
+	"net/url"
 	"strings"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/googleapis/gapic-generator-go/internal/pbinfo"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
 )
 
 func (g *generator) clientHook(servName string) {
@@ -73,8 +77,10 @@ func (g *generator) internalClientIntfInit(serv *descriptor.ServiceDescriptorPro
 	p("type internal%sClient interface {", servName)
 	p("Close() error")
 	p("setGoogleClientInfo(...string)")
-	p("Connection() *grpc.ClientConn")
-	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc"}] = true
+	if !g.opts.omitConnection {
+		p("Connection() *grpc.ClientConn")
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc"}] = true
+	}
 
 	// The mixin methods are for manipulating LROs, IAM, and Location.
 	methods := append(serv.GetMethod(), g.getMixinMethods()...)
@@ -90,6 +96,7 @@ func (g *generator) internalClientIntfInit(serv *descriptor.ServiceDescriptorPro
 		if err != nil {
 			return err
 		}
+		g.imports[inSpec] = true
 		if m.GetOutputType() == emptyType {
 			p("%s(context.Context, *%s.%s, ...gax.CallOption) error",
 				m.GetName(),
@@ -135,6 +142,13 @@ func (g *generator) internalClientIntfInit(serv *descriptor.ServiceDescriptorPro
 			if err != nil {
 				return err
 			}
+			if !g.isCustomOp(m, getHTTPInfo(m)) {
+				outSpec, err := g.descInfo.ImportSpec(g.descInfo.Type[m.GetOutputType()])
+				if err != nil {
+					return err
+				}
+				g.imports[outSpec] = true
+			}
 
 			p("%s(context.Context, *%s.%s, ...gax.CallOption) (%s, error)",
 				m.GetName(), inSpec.Name, inType.GetName(), retTyp)
@@ -174,7 +188,7 @@ func (g *generator) serviceDoc(serv *descriptor.ServiceDescriptorProto) {
 	g.comment(com)
 }
 
-func (g *generator) clientInit(serv *descriptor.ServiceDescriptorProto, servName string, imp pbinfo.ImportSpec, hasRPCForLRO bool) {
+func (g *generator) clientInit(serv *descriptor.ServiceDescriptorProto, servName string, imp pbinfo.ImportSpec, hasRPCForLRO bool) error {
 	p := g.printf
 
 	// client struct
@@ -190,6 +204,19 @@ func (g *generator) clientInit(serv *descriptor.ServiceDescriptorProto, servName
 	p("// The call options for this service.")
 	p("CallOptions *%sCallOptions", servName)
 	p("")
+	if containsTransport(g.opts.transports, rest) {
+		p("// RequestInterceptor, if set, is called by REST clients with each request")
+		p("// before it is sent, e.g. to add custom headers. An error aborts the attempt.")
+		p("// It is unused by gRPC clients.")
+		p("RequestInterceptor func(*http.Request) error")
+		p("")
+		p("// ResponseInterceptor, if set, is called by REST clients with each response")
+		p("// before its status is checked. An error aborts the attempt. It must not")
+		p("// consume the body. It is unused by gRPC clients.")
+		p("ResponseInterceptor func(*http.Response) error")
+		p("")
+		g.imports[pbinfo.ImportSpec{Path: "net/http"}] = true
+	}
 
 	// Need to keep for back compat
 	if hasRPCForLRO {
@@ -218,17 +245,62 @@ func (g *generator) clientInit(serv *descriptor.ServiceDescriptorProto, servName
 	p("  c.internalClient.setGoogleClientInfo(keyval...)")
 	p("}")
 	p("")
-	p("// Connection returns a connection to the API service.")
-	p("//")
-	p("// Deprecated.")
-	p("func (c *%sClient) Connection() *grpc.ClientConn {", servName)
-	p("  return c.internalClient.Connection()")
-	p("}")
-	p("")
+	if containsTransport(g.opts.transports, rest) {
+		// The endpoint is looked up, as for the WithResponse variants, so
+		// that the gRPC transport need not implement it.
+		p("// Endpoint returns the endpoint a REST client sends requests to, as resolved")
+		p("// from the client options and the environment. It is useful for debugging")
+		p("// endpoint selection, e.g. with mTLS. It returns \"\" for gRPC clients.")
+		p("func (c *%sClient) Endpoint() string {", servName)
+		p("  if ec, ok := c.internalClient.(interface{ Endpoint() string }); ok {")
+		p("    return ec.Endpoint()")
+		p("  }")
+		p(`  return ""`)
+		p("}")
+		p("")
+	}
+	if !g.opts.omitConnection {
+		p("// Connection returns a connection to the API service.")
+		p("//")
+		p("// Deprecated.")
+		p("func (c *%sClient) Connection() *grpc.ClientConn {", servName)
+		p("  return c.internalClient.Connection()")
+		p("}")
+		p("")
+	}
 	methods := append(serv.GetMethod(), g.getMixinMethods()...)
 	for _, m := range methods {
-		g.genClientWrapperMethod(m, serv, servName)
+		if err := g.genClientWrapperMethod(m, serv, servName); err != nil {
+			return err
+		}
+	}
+	if !g.opts.flattenedMethods {
+		return nil
+	}
+	// The flattened methods only call the wrapper, so every transport
+	// exposes them.
+	taken := clientMethodNames(methods)
+	for _, m := range methods {
+		if err := g.genFlattenedMethods(m, serv, servName, taken); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+// clientMethodNames returns the names of the methods of a client wrapping
+// methods, including those of the variants that some options add, whether or
+// not they are generated, so that the flattened methods do not depend on them.
+func clientMethodNames(methods []*descriptor.MethodDescriptorProto) map[string]bool {
+	taken := map[string]bool{"Close": true, "Connection": true, "setGoogleClientInfo": true}
+	for _, m := range methods {
+		taken[m.GetName()] = true
+		taken[lroTypeName(m.GetName())] = true
+		for _, suffix := range []string{"WithResponse", "Page", "Media", "Upload"} {
+			taken[m.GetName()+suffix] = true
+		}
+	}
+	return taken
 }
 
 func (g *generator) genClientWrapperMethod(m *descriptor.MethodDescriptorProto, serv *descriptor.ServiceDescriptorProto, servName string) error {
@@ -281,6 +353,14 @@ func (g *generator) genClientWrapperMethod(m *descriptor.MethodDescriptorProto,
 		p("    return c.internalClient.%s(ctx, req, opts...)", m.GetName())
 		p("}")
 		p("")
+		if g.hasPage(m) {
+			outType := g.descInfo.Type[m.GetOutputType()]
+			outSpec, err := g.descInfo.ImportSpec(outType)
+			if err != nil {
+				return err
+			}
+			g.genClientPageMethod(m, clientTypeName, inSpec.Name+"."+inType.GetName(), outSpec.Name+"."+outType.GetName())
+		}
 		return nil
 	}
 
@@ -319,11 +399,118 @@ func (g *generator) genClientWrapperMethod(m *descriptor.MethodDescriptorProto,
 		p("    return c.internalClient.%s(ctx, req, opts...)", m.GetName())
 		p("}")
 		p("")
+		if g.hasWithResponse(m) {
+			g.genClientWithResponseMethod(m, clientTypeName, inSpec.Name+"."+inType.GetName(), retTyp)
+		}
+		if g.hasMedia(m) {
+			g.genClientMediaMethod(m, clientTypeName, inSpec.Name+"."+inType.GetName())
+		}
+		if g.hasUpload(m) {
+			g.genClientUploadMethod(m, clientTypeName, inSpec.Name+"."+inType.GetName(), retTyp)
+		}
 		return nil
 	}
 
 }
 
+// genClientWithResponseMethod generates the wrapper of the WithResponse
+// variant of m. The variant is not part of the internal client interface, so
+// that the gRPC transport need not implement it, and is looked up instead.
+func (g *generator) genClientWithResponseMethod(m *descriptor.MethodDescriptorProto, clientTypeName, inTyp, retTyp string) {
+	p := g.printf
+	name := m.GetName() + "WithResponse"
+
+	p("// %s is like %s, but also returns the HTTP response.", name, m.GetName())
+	p("// Its body has already been consumed. Its StatusCode tells apart the")
+	p("// successful statuses, e.g. 201 Created from 202 Accepted.")
+	p("// It is only supported by REST clients.")
+	p("func (c *%s) %s(ctx context.Context, req *%s, opts ...gax.CallOption) (%s, *http.Response, error) {",
+		clientTypeName, name, inTyp, retTyp)
+	p("  rc, ok := c.internalClient.(interface {")
+	p("    %s(context.Context, *%s, ...gax.CallOption) (%s, *http.Response, error)", name, inTyp, retTyp)
+	p("  })")
+	p("  if !ok {")
+	p("    return nil, nil, errors.New(%q)", name+" is only supported by REST clients")
+	p("  }")
+	p("  return rc.%s(ctx, req, opts...)", name)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "net/http"}] = true
+}
+
+// genClientPageMethod generates the wrapper of the Page variant of m, which
+// like the WithResponse variant is looked up on the internal client.
+func (g *generator) genClientPageMethod(m *descriptor.MethodDescriptorProto, clientTypeName, inTyp, outTyp string) {
+	p := g.printf
+	name := m.GetName() + "Page"
+
+	p("// %s is like %s, but returns the single page of results requested by req", name, m.GetName())
+	p("// and the token of the next one, which is empty after the last page.")
+	p("// It is only supported by REST clients.")
+	p("func (c *%s) %s(ctx context.Context, req *%s, opts ...gax.CallOption) (*%s, string, error) {",
+		clientTypeName, name, inTyp, outTyp)
+	p("  rc, ok := c.internalClient.(interface {")
+	p("    %s(context.Context, *%s, ...gax.CallOption) (*%s, string, error)", name, inTyp, outTyp)
+	p("  })")
+	p("  if !ok {")
+	p(`    return nil, "", errors.New(%q)`, name+" is only supported by REST clients")
+	p("  }")
+	p("  return rc.%s(ctx, req, opts...)", name)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+}
+
+// genClientMediaMethod generates the wrapper of the Media variant of m, which
+// like the WithResponse variant is looked up on the internal client.
+func (g *generator) genClientMediaMethod(m *descriptor.MethodDescriptorProto, clientTypeName, inTyp string) {
+	p := g.printf
+	name := m.GetName() + "Media"
+
+	p("// %s is like %s, but streams the response body instead of buffering it.", name, m.GetName())
+	p("// The caller must close it, and ctx must not be done before it is read.")
+	p("// It is only supported by REST clients.")
+	p("func (c *%s) %s(ctx context.Context, req *%s, opts ...gax.CallOption) (io.ReadCloser, error) {",
+		clientTypeName, name, inTyp)
+	p("  rc, ok := c.internalClient.(interface {")
+	p("    %s(context.Context, *%s, ...gax.CallOption) (io.ReadCloser, error)", name, inTyp)
+	p("  })")
+	p("  if !ok {")
+	p("    return nil, errors.New(%q)", name+" is only supported by REST clients")
+	p("  }")
+	p("  return rc.%s(ctx, req, opts...)", name)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "io"}] = true
+}
+
+// genClientUploadMethod generates the wrapper of the Upload variant of m, which
+// like the WithResponse variant is looked up on the internal client.
+func (g *generator) genClientUploadMethod(m *descriptor.MethodDescriptorProto, clientTypeName, inTyp, retTyp string) {
+	p := g.printf
+	name := m.GetName() + "Upload"
+
+	p("// %s is like %s, but also uploads media as the content of the resource,", name, m.GetName())
+	p("// in a resumable session sent in chunks of the googleapi.ChunkSize in mediaOpts.")
+	p("// It is only supported by REST clients.")
+	p("func (c *%s) %s(ctx context.Context, req *%s, media io.Reader, mediaOpts []googleapi.MediaOption, opts ...gax.CallOption) (%s, error) {",
+		clientTypeName, name, inTyp, retTyp)
+	p("  rc, ok := c.internalClient.(interface {")
+	p("    %s(context.Context, *%s, io.Reader, []googleapi.MediaOption, ...gax.CallOption) (%s, error)", name, inTyp, retTyp)
+	p("  })")
+	p("  if !ok {")
+	p("    return nil, errors.New(%q)", name+" is only supported by REST clients")
+	p("  }")
+	p("  return rc.%s(ctx, req, media, mediaOpts, opts...)", name)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "io"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
+}
+
 func (g *generator) makeClients(serv *descriptor.ServiceDescriptorProto, servName string) error {
 	var hasLRO bool
 	for _, m := range serv.GetMethod() {
@@ -342,13 +529,18 @@ func (g *generator) makeClients(serv *descriptor.ServiceDescriptorProto, servNam
 	if err != nil {
 		return err
 	}
-	g.clientInit(serv, servName, imp, hasLRO)
+	if err := g.clientInit(serv, servName, imp, hasLRO); err != nil {
+		return err
+	}
 
 	for _, v := range g.opts.transports {
 		switch v {
 		case grpc:
 			g.grpcClientInit(serv, servName, imp, hasLRO)
 		case rest:
+			if g.splitREST() {
+				continue
+			}
 			g.restClientInit(serv, servName, imp, hasLRO)
 		default:
 			return fmt.Errorf("unexpected transport variant (supported variants are %q, %q): %d",
@@ -390,15 +582,186 @@ func generateDefaultAudience(host string) string {
 	if !strings.Contains(aud, "://") {
 		aud = "https://" + aud
 	}
-	// Remove port, and everything after, if present.
-	if strings.Count(aud, ":") > 1 {
-		firstIndex := strings.Index(aud, ":")
-		secondIndex := strings.Index(aud[firstIndex+1:], ":") + firstIndex + 1
-		aud = aud[:secondIndex]
+	// The audience identifies the service, so drop any port or path.
+	u, err := url.Parse(aud)
+	if err != nil || u.Hostname() == "" {
+		if !strings.HasSuffix(aud, "/") {
+			aud = aud + "/"
+		}
+		return aud
+	}
+	hostname := u.Hostname()
+	if strings.Contains(hostname, ":") {
+		// Restore the brackets around an IPv6 literal.
+		hostname = "[" + hostname + "]"
+	}
+	return fmt.Sprintf("%s://%s/", u.Scheme, hostname)
+}
+
+// genFlattenedMethods generates a convenience wrapper for each
+// google.api.method_signature declared on m. The wrapper accepts the
+// signature's fields as arguments, builds the request message from them,
+// and calls the full method.
+//
+// Signatures that reference nested fields, map fields, or oneof members
+// (including proto3 optional fields) are skipped, because they cannot be set
+// with a simple assignment. So are those whose wrapper name is already taken,
+// which is then added to taken.
+func (g *generator) genFlattenedMethods(m *descriptor.MethodDescriptorProto, serv *descriptor.ServiceDescriptorProto, servName string, taken map[string]bool) error {
+	if m.GetClientStreaming() {
+		return nil
+	}
+
+	eSigs := proto.GetExtension(m.GetOptions(), annotations.E_MethodSignature)
+	sigs, _ := eSigs.([]string)
+	if len(sigs) == 0 {
+		return nil
+	}
+
+	inType, ok := g.descInfo.Type[m.GetInputType()].(*descriptor.DescriptorProto)
+	if !ok {
+		return nil
+	}
+	inSpec, err := g.descInfo.ImportSpec(inType)
+	if err != nil {
+		return err
+	}
+
+	ret, err := g.wrapperReturnType(m, serv)
+	if err != nil {
+		return err
+	}
+
+	p := g.printf
+	clientTypeName := fmt.Sprintf("%sClient", servName)
+	for _, sig := range sigs {
+		if sig == "" {
+			continue
+		}
+
+		var fields []*descriptor.FieldDescriptorProto
+		for _, name := range strings.Split(sig, ",") {
+			name = strings.TrimSpace(name)
+			f := g.lookupField(m.GetInputType(), name)
+			if f == nil || strings.Contains(name, ".") || f.OneofIndex != nil {
+				fields = nil
+				break
+			}
+			if msg, ok := g.descInfo.Type[f.GetTypeName()].(*descriptor.DescriptorProto); ok && msg.GetOptions().GetMapEntry() {
+				fields = nil
+				break
+			}
+			fields = append(fields, f)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		var suffix strings.Builder
+		for _, f := range fields {
+			suffix.WriteString(snakeToCamel(f.GetName()))
+		}
+		name := fmt.Sprintf("%sWith%s", m.GetName(), suffix.String())
+		if taken[name] {
+			continue
+		}
+		taken[name] = true
+
+		var params, assigns []string
+		for _, f := range fields {
+			typ, err := g.flattenedParamType(f)
+			if err != nil {
+				return err
+			}
+			arg := flattenedParamName(f.GetName())
+			params = append(params, fmt.Sprintf("%s %s", arg, typ))
+			assigns = append(assigns, fmt.Sprintf("%s: %s,", snakeToCamel(f.GetName()), arg))
+		}
+
+		p("// %s is a convenience wrapper for %s that builds the", name, m.GetName())
+		p("// request from the fields of the method signature %q.", sig)
+		p("func (c *%s) %s(ctx context.Context, %s, opts ...gax.CallOption) %s {",
+			clientTypeName, name, strings.Join(params, ", "), ret)
+		p("  req := &%s.%s{", inSpec.Name, inType.GetName())
+		for _, a := range assigns {
+			p("    %s", a)
+		}
+		p("  }")
+		p("  return c.%s(ctx, req, opts...)", m.GetName())
+		p("}")
+		p("")
+		g.imports[inSpec] = true
+	}
+
+	return nil
+}
+
+// wrapperReturnType returns the return type signature, including the error,
+// of the client wrapper method generated for m.
+func (g *generator) wrapperReturnType(m *descriptor.MethodDescriptorProto, serv *descriptor.ServiceDescriptorProto) (string, error) {
+	if m.GetOutputType() == emptyType {
+		return "error", nil
+	}
+	if g.isLRO(m) {
+		return fmt.Sprintf("(*%s, error)", lroTypeName(m.GetName())), nil
+	}
+	if pf, _, err := g.getPagingFields(m); err != nil {
+		return "", err
+	} else if pf != nil {
+		iter, err := g.iterTypeOf(pf)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("*%s", iter.iterTypeName), nil
+	}
+	if m.GetServerStreaming() {
+		servSpec, err := g.descInfo.ImportSpec(serv)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s.%s_%sClient, error)", servSpec.Name, serv.GetName(), m.GetName()), nil
+	}
+	retTyp, err := g.returnType(m)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s, error)", retTyp), nil
+}
+
+// flattenedParamType returns the Go type of the given field when it is
+// accepted as an argument of a flattened method.
+func (g *generator) flattenedParamType(f *descriptor.FieldDescriptorProto) (string, error) {
+	var typ string
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_ENUM:
+		name, imp, err := g.descInfo.NameSpec(g.descInfo.Type[f.GetTypeName()])
+		if err != nil {
+			return "", err
+		}
+		g.imports[imp] = true
+		typ = fmt.Sprintf("%s.%s", imp.Name, name)
+		if f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+			typ = "*" + typ
+		}
+	default:
+		typ = pbinfo.GoTypeForPrim[f.GetType()]
+	}
+	if f.GetLabel() == fieldLabelRepeated {
+		typ = "[]" + typ
+	}
+	return typ, nil
+}
+
+// flattenedParamName returns the argument name used for the given field in
+// a flattened method, avoiding Go keywords and the other parameter names.
+func flattenedParamName(field string) string {
+	name := lowerFirst(snakeToCamel(field))
+	switch name {
+	case "c", "ctx", "opts", "req":
+		return name + "Arg"
 	}
-	// Add trailing slash if not present.
-	if !strings.HasSuffix(aud, "/") {
-		aud = aud + "/"
+	if token.IsKeyword(name) {
+		return name + "_"
 	}
-	return aud
+	return name
 }
@@ -17,6 +17,7 @@ package gengapic
 import (
 	"bytes"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -424,7 +425,11 @@ func TestClientInit(t *testing.T) {
 				{Path: "io/ioutil"}:                                   true,
 				{Path: "net/http"}:                                    true,
 				{Path: "net/url"}:                                     true,
-				{Name: "httptransport", Path: "google.golang.org/api/transport/http"}: true,
+				{Path: "strings"}:                                     true,
+				{Name: "httptransport", Path: "google.golang.org/api/transport/http"}:              true,
+				{Name: "iampb", Path: "google.golang.org/genproto/googleapis/iam/v1"}:              true,
+				{Name: "locationpb", Path: "google.golang.org/genproto/googleapis/cloud/location"}: true,
+				{Name: "mypackagepb", Path: "github.com/googleapis/mypackage"}:                     true,
 			},
 		},
 		{
@@ -439,6 +444,7 @@ func TestClientInit(t *testing.T) {
 				{Path: "io/ioutil"}:                                   true,
 				{Path: "net/http"}:                                    true,
 				{Path: "net/url"}:                                     true,
+				{Path: "strings"}:                                     true,
 				{Path: "context"}:                                     true,
 				{Path: "google.golang.org/grpc"}:                      true,
 				{Path: "google.golang.org/grpc/metadata"}:             true,
@@ -484,6 +490,7 @@ func TestClientInit(t *testing.T) {
 				{Path: "io/ioutil"}:                                   true,
 				{Path: "net/http"}:                                    true,
 				{Path: "net/url"}:                                     true,
+				{Path: "strings"}:                                     true,
 			},
 		},
 		{
@@ -502,7 +509,9 @@ func TestClientInit(t *testing.T) {
 				{Path: "io/ioutil"}:                                   true,
 				{Path: "net/http"}:                                    true,
 				{Path: "net/url"}:                                     true,
+				{Path: "strings"}:                                     true,
 				{Name: "httptransport", Path: "google.golang.org/api/transport/http"}: true,
+				{Name: "mypackagepb", Path: "github.com/googleapis/mypackage"}:        true,
 			},
 		},
 	} {
@@ -560,6 +569,244 @@ func TestClientInit(t *testing.T) {
 	}
 }
 
+func TestGenFlattenedMethods(t *testing.T) {
+	foo := &descriptor.DescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("CreateFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name: proto.String("parent"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+			{
+				Name:     proto.String("foo"),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(".mypackage.Foo"),
+			},
+			{
+				Name:           proto.String("request_id"),
+				Type:           descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Proto3Optional: proto.Bool(true),
+				OneofIndex:     proto.Int32(0),
+			},
+		},
+	}
+	mOpts := &descriptor.MethodOptions{}
+	proto.SetExtension(mOpts, annotations.E_MethodSignature, []string{"parent,foo", "parent,request_id", ""})
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{
+			{
+				Name:       proto.String("CreateFoo"),
+				InputType:  proto.String(".mypackage.CreateFooRequest"),
+				OutputType: proto.String(".mypackage.Foo"),
+				Options:    mOpts,
+			},
+		},
+	}
+	f := &descriptor.FileDescriptorProto{
+		Package: proto.String("mypackage"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("github.com/googleapis/mypackage/v1"),
+		},
+		MessageType: []*descriptor.DescriptorProto{foo, req},
+		Service:     []*descriptor.ServiceDescriptorProto{serv},
+	}
+
+	// The flattened methods only call the wrapper, so that every transport
+	// exposes the same methods.
+	for _, transports := range [][]transport{{grpc}, {grpc, rest}, {rest}} {
+		g := &generator{
+			opts:     &options{transports: transports},
+			imports:  map[pbinfo.ImportSpec]bool{},
+			descInfo: pbinfo.Of([]*descriptor.FileDescriptorProto{f}),
+			aux: &auxTypes{
+				iters: map[string]*iterType{},
+			},
+		}
+		if err := g.genFlattenedMethods(serv.GetMethod()[0], serv, "Foo", clientMethodNames(serv.GetMethod())); err != nil {
+			t.Fatal(err)
+		}
+		want := map[pbinfo.ImportSpec]bool{
+			{Name: "mypackagepb", Path: "github.com/googleapis/mypackage"}: true,
+		}
+		if diff := cmp.Diff(g.imports, want); diff != "" {
+			t.Errorf("TestGenFlattenedMethods(%v) imports got(-),want(+):\n%s", transports, diff)
+		}
+		txtdiff.Diff(t, "flattened_methods", g.pt.String(), filepath.Join("testdata", "flattened_methods.want"))
+	}
+}
+
+func TestGenFlattenedMethodsCollision(t *testing.T) {
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("CreateFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name: proto.String("parent"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+			{
+				Name: proto.String("response"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+			{
+				Name: proto.String("name"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+		},
+	}
+	mOpts := &descriptor.MethodOptions{}
+	proto.SetExtension(mOpts, annotations.E_MethodSignature, []string{"parent", "response", "name", "name"})
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{
+			{
+				Name:       proto.String("CreateFoo"),
+				InputType:  proto.String(".mypackage.CreateFooRequest"),
+				OutputType: proto.String(".mypackage.CreateFooRequest"),
+				Options:    mOpts,
+			},
+			{
+				Name:       proto.String("CreateFooWithParent"),
+				InputType:  proto.String(".mypackage.CreateFooRequest"),
+				OutputType: proto.String(".mypackage.CreateFooRequest"),
+			},
+		},
+	}
+	f := &descriptor.FileDescriptorProto{
+		Package: proto.String("mypackage"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("github.com/googleapis/mypackage/v1"),
+		},
+		MessageType: []*descriptor.DescriptorProto{req},
+		Service:     []*descriptor.ServiceDescriptorProto{serv},
+	}
+	g := &generator{
+		opts:     &options{transports: []transport{rest}},
+		imports:  map[pbinfo.ImportSpec]bool{},
+		descInfo: pbinfo.Of([]*descriptor.FileDescriptorProto{f}),
+		aux: &auxTypes{
+			iters: map[string]*iterType{},
+		},
+	}
+	if err := g.genFlattenedMethods(serv.GetMethod()[0], serv, "Foo", clientMethodNames(serv.GetMethod())); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// CreateFooWithParent is a method of the service, and
+	// CreateFooWithResponse the name of a variant of CreateFoo, so only the
+	// wrapper of "name" is generated, once.
+	if n := strings.Count(got, "func (c *FooClient) "); n != 1 || !strings.Contains(got, "func (c *FooClient) CreateFooWithName(") {
+		t.Errorf("TestGenFlattenedMethodsCollision: want only CreateFooWithName, got:\n%s", got)
+	}
+}
+
+func TestClientInitFlattenedError(t *testing.T) {
+	mOpts := &descriptor.MethodOptions{}
+	proto.SetExtension(mOpts, annotations.E_MethodSignature, []string{"kind"})
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+		Method: []*descriptor.MethodDescriptorProto{
+			{
+				Name:       proto.String("Zip"),
+				InputType:  proto.String(".mypackage.Bar"),
+				OutputType: proto.String(".mypackage.Bar"),
+				Options:    mOpts,
+			},
+		},
+	}
+	fds := []*descriptor.FileDescriptorProto{
+		{
+			Package: proto.String("mypackage"),
+			Options: &descriptor.FileOptions{GoPackage: proto.String("github.com/googleapis/mypackage/v1")},
+			Service: []*descriptor.ServiceDescriptorProto{serv},
+			MessageType: []*descriptor.DescriptorProto{
+				{
+					Name: proto.String("Bar"),
+					Field: []*descriptor.FieldDescriptorProto{
+						{
+							Name:     proto.String("kind"),
+							Type:     descriptor.FieldDescriptorProto_TYPE_ENUM.Enum(),
+							TypeName: proto.String(".mypackage.Missing"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	var g generator
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=grpc,flattened-methods"),
+		ProtoFile: fds,
+	})
+	// The type of the flattened parameter cannot be resolved.
+	if err := g.makeClients(serv, "Foo"); err == nil {
+		t.Errorf("TestClientInitFlattenedError: want an error for an unresolvable parameter type, got:\n%s", g.pt.String())
+	}
+}
+
+func TestClientTransportDispatch(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+		Method: []*descriptor.MethodDescriptorProto{
+			{
+				Name:       proto.String("Zip"),
+				InputType:  proto.String(".mypackage.Bar"),
+				OutputType: proto.String(".mypackage.Bar"),
+				Options:    &descriptor.MethodOptions{},
+			},
+		},
+	}
+	proto.SetExtension(serv.GetMethod()[0].GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/zip"},
+	})
+	fds := []*descriptor.FileDescriptorProto{
+		{
+			Package:     proto.String("mypackage"),
+			Options:     &descriptor.FileOptions{GoPackage: proto.String("github.com/googleapis/mypackage/v1")},
+			Service:     []*descriptor.ServiceDescriptorProto{serv},
+			MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Bar")}},
+		},
+	}
+
+	for _, tst := range []struct {
+		transports string
+		want       bool
+	}{
+		{transports: "grpc"},
+		{transports: "grpc+rest", want: true},
+		{transports: "grpc+rest,rest-build-tag=rest"},
+	} {
+		var g generator
+		g.init(&plugin.CodeGeneratorRequest{
+			Parameter: proto.String("go-gapic-package=path;mypackage,transport=" + tst.transports),
+			ProtoFile: fds,
+		})
+		g.makeClients(serv, "Foo")
+		got := g.pt.String()
+
+		// The REST client is returned before any gRPC connection is dialed.
+		dispatch := strings.Index(got, "if useRESTTransport() {\n\t\treturn NewFooRESTClient(ctx, opts...)\n\t}")
+		if (dispatch >= 0) != tst.want {
+			t.Errorf("TestClientTransportDispatch(%s): got dispatch %v, want %v:\n%s", tst.transports, dispatch >= 0, tst.want, got)
+		}
+		if dial := strings.Index(got, "gtransport.DialPool("); tst.want && dispatch > dial {
+			t.Errorf("TestClientTransportDispatch(%s): want dispatch before DialPool, got:\n%s", tst.transports, got)
+		}
+
+		g.reset()
+		g.genDocFile(42, nil, serv)
+		want := `return strings.EqualFold(os.Getenv("` + transportVar + `"), "rest")`
+		if got := g.pt.String(); strings.Contains(got, want) != tst.want {
+			t.Errorf("TestClientTransportDispatch(%s): doc file has %q = %v, want %v", tst.transports, want, !tst.want, tst.want)
+		}
+	}
+}
+
 func TestGenerateDefaultAudience(t *testing.T) {
 	tests := []struct {
 		name string
@@ -573,6 +820,9 @@ func TestGenerateDefaultAudience(t *testing.T) {
 		{name: "host is a proper audience", host: "https://foo.googleapis.com/", want: "https://foo.googleapis.com/"},
 		{name: "host with non-http scheme", host: "ftp://foo.googleapis.com", want: "ftp://foo.googleapis.com/"},
 		{name: "host with path", host: "foo.googleapis.com:443/extra/path", want: "https://foo.googleapis.com/"},
+		{name: "host with path and no port", host: "foo.googleapis.com/extra/path", want: "https://foo.googleapis.com/"},
+		{name: "host with non-standard port", host: "localhost:8080", want: "https://localhost/"},
+		{name: "ipv6 host with port", host: "[::1]:8080", want: "https://[::1]/"},
 	}
 
 	for _, tc := range tests {
@@ -584,6 +834,28 @@ func TestGenerateDefaultAudience(t *testing.T) {
 	}
 }
 
+func TestGenerateDefaultMTLSEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		endpoint string
+		want     string
+	}{
+		{name: "plain host", endpoint: "foo.googleapis.com", want: "foo.mtls.googleapis.com"},
+		{name: "host with port", endpoint: "foo.googleapis.com:443", want: "foo.mtls.googleapis.com:443"},
+		{name: "sandbox host with port", endpoint: "foo.sandbox.googleapis.com:8443", want: "foo.mtls.sandbox.googleapis.com:8443"},
+		{name: "host with scheme and port", endpoint: "https://foo.googleapis.com:8443", want: "https://foo.mtls.googleapis.com:8443"},
+		{name: "non-google host", endpoint: "localhost:8080", want: "localhost:8080"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := generateDefaultMTLSEndpoint(tc.endpoint); got != tc.want {
+				t.Errorf("generateDefaultMTLSEndpoint(%q) = %q, want %q", tc.endpoint, got, tc.want)
+			}
+		})
+	}
+}
+
 // mixinDescriptors is used for testing purposes only.
 func mixinDescriptors() []*descriptor.FileDescriptorProto {
 	files := []*descriptor.FileDescriptorProto{}
@@ -15,6 +15,7 @@
 package gengapic
 
 import (
+	"net/textproto"
 	"sort"
 	"strings"
 
@@ -33,6 +34,15 @@ import (
 func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.ServiceDescriptorProto) {
 	p := g.printf
 	hasREST := containsTransport(g.opts.transports, rest)
+	httpHeaders := g.restHTTPHeaders()
+	debugLogging := hasREST && g.opts.debugLogging
+	mediaUpload := hasREST && g.opts.mediaUpload
+	partialResponse := hasREST && g.opts.partialResponse
+	callEndpoint := hasREST && g.opts.callEndpoint
+	restMetrics := hasREST && g.opts.restMetrics
+	withResponse := hasREST && g.opts.withResponse
+	retryIdempotent := hasREST && g.opts.retryIdempotent
+	etagHeaders := hasREST && g.opts.etagHeaders
 
 	p(license.Apache, year)
 	p("")
@@ -109,19 +119,63 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 	p("")
 
 	p("import (")
+	if mediaUpload {
+		p("%s%q", "\t", "bytes")
+	}
 	p("%s%q", "\t", "context")
 	if hasREST {
+		p("%s%q", "\t", "errors")
 		p("%s%q", "\t", "fmt")
+		p("%s%q", "\t", "io")
+	}
+	if mediaUpload {
+		p("%s%q", "\t", "io/ioutil")
+	}
+	if debugLogging {
+		p("%s%q", "\t", "log")
+	}
+	if hasREST {
+		p("%s%q", "\t", "net")
 		p("%s%q", "\t", "net/http")
 	}
 	p("%s%q", "\t", "os")
 	p("%s%q", "\t", "runtime")
 	p("%s%q", "\t", "strconv")
 	p("%s%q", "\t", "strings")
+	if hasREST {
+		p("%s%q", "\t", "time")
+	}
 	p("%s%q", "\t", "unicode")
 	p("")
+	if hasREST {
+		p("%s%q", "\t", "github.com/googleapis/gax-go/v2")
+	}
+	if hasREST {
+		p("%s%q", "\t", "github.com/googleapis/gax-go/v2/apierror")
+	}
+	if restMetrics {
+		p("%s%q", "\t", "go.opentelemetry.io/otel")
+		p("%s%q", "\t", "go.opentelemetry.io/otel/attribute")
+		p("%s%q", "\t", "go.opentelemetry.io/otel/metric")
+		p("%s%q", "\t", "go.opentelemetry.io/otel/metric/noop")
+	}
+	if mediaUpload || retryIdempotent {
+		p("%s%q", "\t", "google.golang.org/api/googleapi")
+	}
 	p("%s%q", "\t", "google.golang.org/api/option")
-	p("%s%q", "\t", "google.golang.org/grpc/metadata")
+	// A client that uses http.Header throughout has no gRPC dependencies.
+	if hasREST && !httpHeaders {
+		p("%s%q", "\t", "google.golang.org/grpc/codes")
+	}
+	if !httpHeaders {
+		p("%s%q", "\t", "google.golang.org/grpc/metadata")
+	}
+	if hasREST && !httpHeaders {
+		p("%s%q", "\t", "google.golang.org/grpc/status")
+	}
+	if hasREST {
+		p("%s%q", "\t", "google.golang.org/protobuf/encoding/protojson")
+	}
 	p(")")
 	p("")
 
@@ -134,17 +188,19 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 	p("const versionClient = %q", "UNKNOWN")
 	p("")
 
-	p("func insertMetadata(ctx context.Context, mds ...metadata.MD) context.Context {")
-	p("  out, _ := metadata.FromOutgoingContext(ctx)")
-	p("  out = out.Copy()")
-	p("  for _, md := range mds {")
-	p("    for k, v := range md {")
-	p("      out[k] = append(out[k], v...)")
-	p("    }")
-	p("  }")
-	p("  return metadata.NewOutgoingContext(ctx, out)")
-	p("}")
-	p("")
+	if !httpHeaders {
+		p("func insertMetadata(ctx context.Context, mds ...metadata.MD) context.Context {")
+		p("  out, _ := metadata.FromOutgoingContext(ctx)")
+		p("  out = out.Copy()")
+		p("  for _, md := range mds {")
+		p("    for k, v := range md {")
+		p("      out[k] = append(out[k], v...)")
+		p("    }")
+		p("  }")
+		p("  return metadata.NewOutgoingContext(ctx, out)")
+		p("}")
+		p("")
+	}
 
 	p("func checkDisableDeadlines() (bool, error) {")
 	p("  raw, ok := os.LookupEnv(%q)", disableDeadlinesVar)
@@ -157,6 +213,15 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 	p("}")
 	p("")
 
+	if g.restDispatch() {
+		p("// useRESTTransport reports whether the New*Client constructors return a")
+		p("// client based on REST rather than gRPC, as selected by %s.", transportVar)
+		p("func useRESTTransport() bool {")
+		p("  return strings.EqualFold(os.Getenv(%q), %q)", transportVar, "rest")
+		p("}")
+		p("")
+	}
+
 	p("// DefaultAuthScopes reports the default set of authentication scopes to use with this package.")
 	p("func DefaultAuthScopes() []string {")
 	p("  return []string{")
@@ -209,6 +274,57 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 	}
 
 	if hasREST {
+		p("// httpDoer is the part of *http.Client used by the REST clients, so that")
+		p("// it can be replaced by a fake in tests.")
+		p("type httpDoer interface {")
+		p("  Do(*http.Request) (*http.Response, error)")
+		p("}")
+		p("")
+		p("// grpcOnlyOptions names the options that configure a gRPC connection, which a")
+		p("// REST client cannot honor, by the type of the option their constructor returns.")
+		p("var grpcOnlyOptions = map[string]string{")
+		p(`  fmt.Sprintf("%%T", option.WithGRPCConn(nil)):            "option.WithGRPCConn",`)
+		p(`  fmt.Sprintf("%%T", option.WithGRPCConnectionPool(nil)): "option.WithGRPCConnectionPool",`)
+		p("}")
+		p("")
+		p("// checkRESTClientOptions returns a descriptive error if opts configure a")
+		p("// gRPC connection or connection pool, rather than letting the transport")
+		p("// reject them opaquely. Options that only tune gRPC connections are ignored")
+		p("// by REST clients.")
+		p("func checkRESTClientOptions(opts []option.ClientOption) error {")
+		p("  for _, o := range opts {")
+		p(`    if name, ok := grpcOnlyOptions[fmt.Sprintf("%%T", o)]; ok {`)
+		p(`      return fmt.Errorf("%%s is not supported by REST clients: use option.WithHTTPClient, or a gRPC client", name)`)
+		p("    }")
+		p("  }")
+		p("  return nil")
+		p("}")
+		p("")
+		p("// detachedContext keeps the values of its parent, e.g. an oauth2.HTTPClient,")
+		p("// but not its deadline or cancellation. The REST clients create their HTTP")
+		p("// client with it, as its credentials refresh tokens with the context they")
+		p("// were created with, long after the constructor has returned.")
+		p("type detachedContext struct {")
+		p("  parent context.Context")
+		p("}")
+		p("")
+		p("func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }")
+		p("")
+		p("func (detachedContext) Done() <-chan struct{} { return nil }")
+		p("")
+		p("func (detachedContext) Err() error { return nil }")
+		p("")
+		p("func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }")
+		p("")
+		p("// marshalOpts and unmarshalOpts encode and decode the JSON bodies of REST")
+		p("// requests and responses for every method in the package.")
+		// protojson reads a JSON null as an absent field, so an optional
+		// response field that is null keeps no presence.
+		p("var (")
+		p("  marshalOpts = %s", g.restMarshalOptions("AllowPartial: true"))
+		p("  unmarshalOpts = protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}")
+		p(")")
+		p("")
 		p("// maybeUnknownEnum wraps the given proto-JSON parsing error if it is the result")
 		p("// of receiving an unknown enum value.")
 		p("func maybeUnknownEnum(err error) error {")
@@ -218,6 +334,82 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 		p("  return err")
 		p("}")
 		p("")
+		p("// transientError is a network failure that occurred before any response was")
+		if httpHeaders {
+			p("// received.")
+		} else {
+			p("// received. It reports the UNAVAILABLE code, so that retryers configured")
+			p("// with gax.OnCodes retry it just as they would on the gRPC transport.")
+		}
+		p("type transientError struct {")
+		p("  err error")
+		p("}")
+		p("")
+		p("func (e *transientError) Error() string { return e.err.Error() }")
+		p("")
+		p("func (e *transientError) Unwrap() error { return e.err }")
+		p("")
+		if !httpHeaders {
+			p("func (e *transientError) GRPCStatus() *status.Status {")
+			p("  return status.New(codes.Unavailable, e.err.Error())")
+			p("}")
+			p("")
+		}
+		p("// maybeTransient wraps an error returned by sending an HTTP request in a")
+		p("// transientError if it is a transient network failure, e.g. a connection")
+		p("// reset or a failed DNS lookup. Cancellation is never transient.")
+		p("func maybeTransient(err error) error {")
+		p("  if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {")
+		p("    return err")
+		p("  }")
+		p("  var opErr *net.OpError")
+		p("  var dnsErr *net.DNSError")
+		p("  if errors.As(err, &opErr) || (errors.As(err, &dnsErr) && !dnsErr.IsNotFound) || errors.Is(err, io.ErrUnexpectedEOF) {")
+		p("    return &transientError{err: err}")
+		p("  }")
+		p("  return err")
+		p("}")
+		p("")
+		p("// maybeAPIError wraps an error returned by googleapi.CheckResponse in an")
+		p("// *apierror.APIError, which exposes the typed details of the google.rpc.Status")
+		p("// in the response body, e.g. ErrorInfo. It returns other errors unchanged.")
+		p("func maybeAPIError(err error) error {")
+		p("  if apiErr, ok := apierror.FromError(err); ok {")
+		p("    return apiErr")
+		p("  }")
+		p("  return err")
+		p("}")
+		p("")
+		if debugLogging {
+			g.logRequestFunc()
+		}
+		if mediaUpload {
+			g.uploadMediaFunc()
+		}
+		if partialResponse {
+			g.fieldsOptionFuncs()
+		}
+		if callEndpoint {
+			g.endpointOptionFuncs()
+		}
+		g.attemptTimeoutFuncs()
+		if withResponse {
+			g.rateLimitFunc()
+		}
+		if restMetrics {
+			g.recordRESTCallFunc()
+		}
+		if retryIdempotent {
+			g.restRetryerFunc()
+		}
+		if etagHeaders {
+			g.etagFuncs()
+		}
+		g.serverTimeoutFunc()
+		if httpHeaders {
+			g.httpBuildHeaders()
+			return
+		}
 		p("// buildHeaders extracts metadata from the outgoing context, joins it with any other")
 		p("// given metadata, and converts them into a http.Header. ")
 		p("func buildHeaders(ctx context.Context, mds ...metadata.MD) http.Header {")
@@ -225,11 +417,501 @@ func (g *generator) genDocFile(year int, scopes []string, serv *descriptor.Servi
 		p("    mds = append(mds, cmd)")
 		p("  }")
 		p("  md := metadata.Join(mds...)")
-		p("  return http.Header(md)")
+		p("  headers := http.Header(md)")
+		p("")
+		p("  // metadata keys are lowercase, so move any user-agent to the canonical")
+		p("  // header key. Otherwise, identify the library by default. Either is")
+		p("  // replaced by the transport if option.WithUserAgent was provided.")
+		p(`  ua := "gl-go/" + versionGo() + " gapic/" + versionClient`)
+		p(`  if v := md.Get("user-agent"); len(v) > 0 {`)
+		p("    ua = strings.Join(v, \" \")")
+		p(`    delete(headers, "user-agent")`)
+		p("  }")
+		p(`  headers.Set("User-Agent", ua)`)
+		p("  return headers")
 		p("}")
 	}
 }
 
+// recordRESTCallFunc generates the metric instruments of the REST clients and
+// recordRESTCall, which the REST methods call after each request. The meter is
+// taken from the global MeterProvider of OpenTelemetry, which records nothing
+// until the application sets one with otel.SetMeterProvider. An instrument
+// the meter fails to create is left a noop one.
+func (g *generator) recordRESTCallFunc() {
+	p := g.printf
+
+	p("var (")
+	p("  restMeter = otel.Meter(%q)", g.opts.pkgPath)
+	p("")
+	p("  restRequestCount metric.Int64Counter = noop.Int64Counter{}")
+	p("  restRequestLatency metric.Float64Histogram = noop.Float64Histogram{}")
+	p("  restErrorCount metric.Int64Counter = noop.Int64Counter{}")
+	p(")")
+	p("")
+	p("func init() {")
+	p(`  if c, err := restMeter.Int64Counter("rest.client.requests", metric.WithDescription("The number of REST requests sent.")); err == nil {`)
+	p("    restRequestCount = c")
+	p("  }")
+	p(`  if h, err := restMeter.Float64Histogram("rest.client.duration", metric.WithDescription("The latency of REST requests."), metric.WithUnit("s")); err == nil {`)
+	p("    restRequestLatency = h")
+	p("  }")
+	p(`  if c, err := restMeter.Int64Counter("rest.client.errors", metric.WithDescription("The number of REST requests that failed.")); err == nil {`)
+	p("    restErrorCount = c")
+	p("  }")
+	p("}")
+	p("")
+	p("// recordRESTCall records a REST request to method sent at start, labeled by")
+	p("// the method and the HTTP status of its response, or 0 if there was none.")
+	p("func recordRESTCall(ctx context.Context, method string, start time.Time, httpRsp *http.Response, err error) {")
+	p("  code := 0")
+	p("  if httpRsp != nil {")
+	p("    code = httpRsp.StatusCode")
+	p("  }")
+	p(`  attrs := metric.WithAttributes(attribute.String("method", method), attribute.Int("status", code))`)
+	p("  restRequestCount.Add(ctx, 1, attrs)")
+	p("  restRequestLatency.Record(ctx, time.Since(start).Seconds(), attrs)")
+	p("  if err != nil || code >= 400 {")
+	p("    restErrorCount.Add(ctx, 1, attrs)")
+	p("  }")
+	p("}")
+	p("")
+}
+
+// fieldsOptionFuncs generates the WithFields call option, which requests a
+// partial response from REST methods, and callFields, which they use to find it.
+func (g *generator) fieldsOptionFuncs() {
+	p := g.printf
+
+	p("// WithFields returns a gax.CallOption that requests a partial response with only")
+	p("// the given fields, e.g. \"name,labels\" or \"items(name)\", by sending them as the")
+	p("// fields system parameter. It has no effect on gRPC clients.")
+	p("func WithFields(fields ...string) gax.CallOption {")
+	p(`  return fieldsOption(strings.Join(fields, ","))`)
+	p("}")
+	p("")
+	p("type fieldsOption string")
+	p("")
+	p("func (fieldsOption) Resolve(*gax.CallSettings) {}")
+	p("")
+	p("// callFields returns the fields requested with the last WithFields in opts.")
+	p("func callFields(opts []gax.CallOption) string {")
+	p("  var fields string")
+	p("  for _, o := range opts {")
+	p("    if f, ok := o.(fieldsOption); ok {")
+	p("      fields = string(f)")
+	p("    }")
+	p("  }")
+	p("  return fields")
+	p("}")
+	p("")
+}
+
+// endpointOptionFuncs generates the WithCallEndpoint call option, which sends
+// the request of a REST method to another endpoint than that of the client,
+// and callEndpoint, which they use to find it.
+func (g *generator) endpointOptionFuncs() {
+	p := g.printf
+
+	p("// WithCallEndpoint returns a gax.CallOption that sends the request of a REST")
+	p("// method to the given endpoint, e.g. \"https://shard-1.foo.googleapis.com\", instead")
+	p("// of that of the client. It has no effect on gRPC clients.")
+	p("func WithCallEndpoint(endpoint string) gax.CallOption {")
+	p(`  return endpointOption(strings.TrimRight(endpoint, "/"))`)
+	p("}")
+	p("")
+	p("type endpointOption string")
+	p("")
+	p("func (endpointOption) Resolve(*gax.CallSettings) {}")
+	p("")
+	p("// callEndpoint returns the endpoint set with the last WithCallEndpoint in opts,")
+	p("// or the given endpoint of the client if there is none.")
+	p("func callEndpoint(opts []gax.CallOption, endpoint string) string {")
+	p("  for _, o := range opts {")
+	p("    if e, ok := o.(endpointOption); ok {")
+	p("      endpoint = string(e)")
+	p("    }")
+	p("  }")
+	p("  return endpoint")
+	p("}")
+	p("")
+}
+
+// rateLimitFunc generates RateLimitOf, which parses the rate-limit headers of
+// the *http.Response returned by a WithResponse method, so that callers can
+// throttle themselves.
+func (g *generator) rateLimitFunc() {
+	p := g.printf
+
+	p("// RateLimit is the rate limit reported by the headers of a REST response.")
+	p("type RateLimit struct {")
+	p("  // Limit is the number of requests allowed in the current window, or 0 if")
+	p("  // it is not reported.")
+	p("  Limit int64")
+	p("  // Remaining is the number of requests left in the current window.")
+	p("  Remaining int64")
+	p("  // Reset is when the current window ends, or the zero time if it is not")
+	p("  // reported.")
+	p("  Reset time.Time")
+	p("}")
+	p("")
+	p("// RateLimitOf returns the rate limit reported by rsp, e.g. as returned by a")
+	p("// WithResponse method, and whether it reports one. The X-RateLimit-Limit,")
+	p("// X-RateLimit-Remaining and X-RateLimit-Reset headers, whose reset is in Unix")
+	p("// seconds, are understood, as are their RateLimit-* counterparts, whose reset")
+	p("// is in seconds from now.")
+	p("func RateLimitOf(rsp *http.Response) (RateLimit, bool) {")
+	p("  if rsp == nil {")
+	p("    return RateLimit{}, false")
+	p("  }")
+	p(`  prefix := "X-RateLimit-"`)
+	p(`  if rsp.Header.Get(prefix+"Remaining") == "" {`)
+	p(`    prefix = "RateLimit-"`)
+	p("  }")
+	p(`  remaining, err := strconv.ParseInt(rsp.Header.Get(prefix+"Remaining"), 10, 64)`)
+	p("  if err != nil {")
+	p("    return RateLimit{}, false")
+	p("  }")
+	p("  rl := RateLimit{Remaining: remaining}")
+	p(`  if limit, err := strconv.ParseInt(rsp.Header.Get(prefix+"Limit"), 10, 64); err == nil {`)
+	p("    rl.Limit = limit")
+	p("  }")
+	p(`  if reset, err := strconv.ParseInt(rsp.Header.Get(prefix+"Reset"), 10, 64); err == nil {`)
+	p(`    if prefix == "RateLimit-" {`)
+	p("      rl.Reset = time.Now().Add(time.Duration(reset) * time.Second)")
+	p("    } else {")
+	p("      rl.Reset = time.Unix(reset, 0)")
+	p("    }")
+	p("  }")
+	p("  return rl, true")
+	p("}")
+	p("")
+}
+
+// attemptTimeoutFuncs generates the WithAttemptTimeout call option, which
+// bounds each attempt of a REST method, and attemptTimeout, which they use to
+// find it.
+func (g *generator) attemptTimeoutFuncs() {
+	p := g.printf
+
+	p("// WithAttemptTimeout returns a gax.CallOption that bounds each attempt of a")
+	p("// REST method, including the read of its response, by d, while gax.WithTimeout")
+	p("// bounds the whole call, retries included. It has no effect on gRPC clients,")
+	p("// nor on the responses read after the call has returned, e.g. by a Media")
+	p("// variant or a server stream.")
+	p("func WithAttemptTimeout(d time.Duration) gax.CallOption {")
+	p("  return attemptTimeoutOption(d)")
+	p("}")
+	p("")
+	p("type attemptTimeoutOption time.Duration")
+	p("")
+	p("func (attemptTimeoutOption) Resolve(*gax.CallSettings) {}")
+	p("")
+	p("// attemptTimeout returns the duration set with the last WithAttemptTimeout in")
+	p("// opts, or 0 if there is none.")
+	p("func attemptTimeout(opts []gax.CallOption) time.Duration {")
+	p("  var d time.Duration")
+	p("  for _, o := range opts {")
+	p("    if t, ok := o.(attemptTimeoutOption); ok {")
+	p("      d = time.Duration(t)")
+	p("    }")
+	p("  }")
+	p("  return d")
+	p("}")
+	p("")
+}
+
+// uploadMediaFunc generates uploadMedia, which the Upload variants of REST
+// methods call to send the media to the resumable session they created.
+func (g *generator) uploadMediaFunc() {
+	p := g.printf
+
+	p("// uploadRetryDeadline bounds the retries of a chunk of media that the upload")
+	p("// session keeps none of, like the chunk retries of google.golang.org/api.")
+	p("const uploadRetryDeadline = 32 * time.Second")
+	p("")
+	p("// uploadMedia sends the content of media to the resumable upload session at")
+	p("// the given URI, with headers, in chunks of chunkSize bytes, or in a single")
+	p("// request if chunkSize is not positive. Only the bytes of a chunk the session")
+	p("// did not keep are sent again, and a chunk is retried with backoff on a 5xx")
+	p("// status or a transient network failure. It returns the body of the final")
+	p("// response.")
+	p("func uploadMedia(ctx context.Context, client httpDoer, session string, headers http.Header, media io.Reader, chunkSize int) ([]byte, error) {")
+	p("  var off int64")
+	p("  for {")
+	p("    var chunk []byte")
+	p("    var err error")
+	p("    final := chunkSize <= 0")
+	p("    if final {")
+	p("      chunk, err = ioutil.ReadAll(media)")
+	p("    } else {")
+	p("      chunk = make([]byte, chunkSize)")
+	p("      var n int")
+	p("      n, err = io.ReadFull(media, chunk)")
+	p("      chunk = chunk[:n]")
+	p("      if err == io.EOF || err == io.ErrUnexpectedEOF {")
+	p("        final, err = true, nil")
+	p("      }")
+	p("    }")
+	p("    if err != nil {")
+	p("      return nil, err")
+	p("    }")
+	p("")
+	p("    bo := gax.Backoff{")
+	p("      Initial:    100 * time.Millisecond,")
+	p("      Max:        60000 * time.Millisecond,")
+	p("      Multiplier: 1.30,")
+	p("    }")
+	p("    deadline := time.Now().Add(uploadRetryDeadline)")
+	p("  send:")
+	p("    for {")
+	p("      httpRsp, err := sendChunk(ctx, client, session, headers, chunk, off, final)")
+	p("      switch {")
+	p("      case err != nil:")
+	p("        var terr *transientError")
+	p("        if err = maybeTransient(err); !errors.As(err, &terr) {")
+	p("          return nil, err")
+	p("        }")
+	p("      case httpRsp.StatusCode == http.StatusPermanentRedirect:")
+	p("        // The session responds 308 Resume Incomplete until it has the whole")
+	p("        // media, with the range of the bytes it kept so far.")
+	p("        httpRsp.Body.Close()")
+	p(`        kept, kerr := keptBytes(httpRsp.Header.Get("Range"))`)
+	p("        if kerr != nil {")
+	p("          return nil, kerr")
+	p("        }")
+	p("        if kept < off || kept > off+int64(len(chunk)) {")
+	p(`          return nil, fmt.Errorf("upload session kept %%d bytes of media, but %%d were sent", kept, off+int64(len(chunk)))`)
+	p("        }")
+	p("        if kept > off {")
+	p("          chunk, off = chunk[kept-off:], kept")
+	p("          if len(chunk) == 0 && !final {")
+	p("            break send")
+	p("          }")
+	p("          deadline = time.Now().Add(uploadRetryDeadline)")
+	p("          continue")
+	p("        }")
+	p(`        err = errors.New("upload session kept none of the chunk of media")`)
+	p("      case httpRsp.StatusCode >= 500:")
+	p("        err = maybeAPIError(googleapi.CheckResponse(httpRsp))")
+	p("        httpRsp.Body.Close()")
+	p("      default:")
+	p("        defer httpRsp.Body.Close()")
+	p("        if err = googleapi.CheckResponse(httpRsp); err != nil {")
+	p("          return nil, maybeAPIError(err)")
+	p("        }")
+	p("        return ioutil.ReadAll(httpRsp.Body)")
+	p("      }")
+	p("      if time.Now().After(deadline) {")
+	p("        return nil, err")
+	p("      }")
+	p("      if serr := gax.Sleep(ctx, bo.Pause()); serr != nil {")
+	p("        return nil, err")
+	p("      }")
+	p("    }")
+	p("  }")
+	p("}")
+	p("")
+	p("// sendChunk sends chunk, the bytes of the media at offset off, to the upload")
+	p("// session with headers. If final, the media ends with chunk.")
+	p("func sendChunk(ctx context.Context, client httpDoer, session string, headers http.Header, chunk []byte, off int64, final bool) (*http.Response, error) {")
+	p("  httpReq, err := http.NewRequestWithContext(ctx, %q, session, bytes.NewReader(chunk))", "PUT")
+	p("  if err != nil {")
+	p("    return nil, err")
+	p("  }")
+	p("  httpReq.Header = headers.Clone()")
+	p(`  total := "*"`)
+	p("  if final {")
+	p("    total = strconv.FormatInt(off+int64(len(chunk)), 10)")
+	p("  }")
+	p("  if len(chunk) == 0 {")
+	p(`    httpReq.Header.Set("Content-Range", "bytes */"+total)`)
+	p("  } else {")
+	p(`    httpReq.Header.Set("Content-Range", fmt.Sprintf("bytes %%d-%%d/%%s", off, off+int64(len(chunk))-1, total))`)
+	p("  }")
+	p("  return client.Do(httpReq)")
+	p("}")
+	p("")
+	p("// keptBytes returns the number of bytes of the media an upload session kept,")
+	p(`// from the Range header of its 308 response, e.g. "bytes=0-41" for 42 bytes,`)
+	p("// which is absent if it kept none.")
+	p("func keptBytes(rng string) (int64, error) {")
+	p(`  if rng == "" {`)
+	p("    return 0, nil")
+	p("  }")
+	p(`  last := strings.TrimPrefix(rng, "bytes=0-")`)
+	p("  n, err := strconv.ParseInt(last, 10, 64)")
+	p("  if last == rng || err != nil {")
+	p(`    return 0, fmt.Errorf("invalid Range header %%q of upload session", rng)`)
+	p("  }")
+	p("  return n + 1, nil")
+	p("}")
+	p("")
+}
+
+// logRequestFunc generates logRequest, which the REST methods call to log each
+// request when debug logging is enabled at runtime. The values of the headers
+// in redactedHeaders, i.e. the well-known credential headers and any given via
+// the rest-redact-headers option, are never logged.
+func (g *generator) logRequestFunc() {
+	p := g.printf
+
+	redacted := map[string]bool{
+		"Authorization":       true,
+		"Cookie":              true,
+		"Proxy-Authorization": true,
+		"X-Goog-Api-Key":      true,
+	}
+	for _, h := range g.opts.redactHeaders {
+		redacted[textproto.CanonicalMIMEHeaderKey(h)] = true
+	}
+	keys := make([]string, 0, len(redacted))
+	for k := range redacted {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	p("// redactedHeaders are the canonical keys of the request headers whose values")
+	p("// are never logged.")
+	p("var redactedHeaders = map[string]bool{")
+	for _, k := range keys {
+		p("  %q: true,", k)
+	}
+	p("}")
+	p("")
+	p("// logRequest logs the method, URL and headers of httpReq if the %s", debugLoggingVar)
+	p("// environment variable is true. The values of redactedHeaders are replaced, so")
+	p("// that credentials are not leaked into logs.")
+	p("func logRequest(httpReq *http.Request) {")
+	p("  if ok, _ := strconv.ParseBool(os.Getenv(%q)); !ok {", debugLoggingVar)
+	p("    return")
+	p("  }")
+	p("  headers := make(http.Header, len(httpReq.Header))")
+	p("  for k, v := range httpReq.Header {")
+	p("    if redactedHeaders[http.CanonicalHeaderKey(k)] {")
+	p(`      v = []string{"REDACTED"}`)
+	p("    }")
+	p("    headers[k] = v")
+	p("  }")
+	p(`  log.Printf("%%s %%s %%v", httpReq.Method, httpReq.URL, headers)`)
+	p("}")
+	p("")
+}
+
+// httpBuildHeaders generates a buildHeaders that only uses net/http types, so
+// that REST-only packages need not import gRPC metadata. Metadata attached to
+// the outgoing context is not available without it.
+func (g *generator) httpBuildHeaders() {
+	p := g.printf
+
+	p("// buildHeaders joins the given headers into a single http.Header.")
+	p("func buildHeaders(ctx context.Context, hds ...http.Header) http.Header {")
+	p("  headers := http.Header{}")
+	p("  for _, hd := range hds {")
+	p("    for k, v := range hd {")
+	p("      for _, vv := range v {")
+	p("        headers.Add(k, vv)")
+	p("      }")
+	p("    }")
+	p("  }")
+	p("")
+	p("  // Identify the library by default. This is replaced by the transport")
+	p("  // if option.WithUserAgent was provided.")
+	p(`  if headers.Get("User-Agent") == "" {`)
+	p(`    headers.Set("User-Agent", "gl-go/" + versionGo() + " gapic/" + versionClient)`)
+	p("  }")
+	p("  return headers")
+	p("}")
+}
+
+// restRetryerFunc generates onHTTPCodesOrTransient, the default retryer of
+// idempotent REST methods under the rest-retry-idempotent option.
+func (g *generator) restRetryerFunc() {
+	p := g.printf
+
+	p("// restRetryer retries errors of REST calls with one of the given HTTP status")
+	p("// codes, like gax.OnHTTPCodes, as well as transient network failures, which")
+	p("// carry no status code.")
+	p("type restRetryer struct {")
+	p("  backoff gax.Backoff")
+	p("  codes   []int")
+	p("}")
+	p("")
+	p("// onHTTPCodesOrTransient returns a Retryer that retries, with backoff bo, errors")
+	p("// with one of the HTTP status codes cc and transient network failures.")
+	p("func onHTTPCodesOrTransient(bo gax.Backoff, cc ...int) gax.Retryer {")
+	p("  return &restRetryer{backoff: bo, codes: cc}")
+	p("}")
+	p("")
+	p("func (r *restRetryer) Retry(err error) (time.Duration, bool) {")
+	p("  var terr *transientError")
+	p("  if errors.As(err, &terr) {")
+	p("    return r.backoff.Pause(), true")
+	p("  }")
+	p("  var gerr *googleapi.Error")
+	p("  if errors.As(err, &gerr) {")
+	p("    for _, c := range r.codes {")
+	p("      if gerr.Code == c {")
+	p("        return r.backoff.Pause(), true")
+	p("      }")
+	p("    }")
+	p("  }")
+	p("  return 0, false")
+	p("}")
+	p("")
+}
+
+// etagFuncs generates the conversions between the etag fields of messages,
+// which hold the opaque tag, and the quoted entity-tags of the If-Match and
+// ETag headers, under the rest-etag option.
+func (g *generator) etagFuncs() {
+	p := g.printf
+
+	p("// quoteETag returns the etag field of a request as the quoted entity-tag of an")
+	p("// If-Match header. An etag that is already quoted, weak or strong, is kept.")
+	p("func quoteETag(etag string) string {")
+	p(`  if strings.HasPrefix(etag, "\"") || strings.HasPrefix(etag, "W/\"") {`)
+	p("    return etag")
+	p("  }")
+	p(`  return "\"" + etag + "\""`)
+	p("}")
+	p("")
+	p("// unquoteETag returns the entity-tag of an ETag header as the etag field of a")
+	p("// message holds it, without its quotes. A weak entity-tag is kept as is, so")
+	p("// that quoteETag sends it back unchanged.")
+	p("func unquoteETag(etag string) string {")
+	p(`  if len(etag) >= 2 && strings.HasPrefix(etag, "\"") && strings.HasSuffix(etag, "\"") {`)
+	p("    return etag[1 : len(etag)-1]")
+	p("  }")
+	p("  return etag")
+	p("}")
+	p("")
+}
+
+// serverTimeoutFunc generates setServerTimeout, which REST methods call on
+// each attempt of a call, so that the header reflects the time remaining for
+// that attempt rather than for the call when its headers were built.
+func (g *generator) serverTimeoutFunc() {
+	p := g.printf
+
+	p("// setServerTimeout sets the X-Server-Timeout header, in seconds, to the time")
+	p("// remaining before the deadline of ctx, the context of one attempt of a call.")
+	p("// Like the grpc-timeout header of the gRPC transport, it lets the server bound")
+	p("// its processing by the time the client is still waiting. The header is")
+	p("// omitted without a deadline, or once the deadline has passed.")
+	p("func setServerTimeout(ctx context.Context, headers http.Header) {")
+	p(`  headers.Del("X-Server-Timeout")`)
+	p("  d, ok := ctx.Deadline()")
+	p("  if !ok {")
+	p("    return")
+	p("  }")
+	p("  if remaining := time.Until(d); remaining > 0 {")
+	p(`    headers.Set("X-Server-Timeout", fmt.Sprintf("%%.3f", remaining.Seconds()))`)
+	p("  }")
+	p("}")
+	p("")
+}
+
 func collectScopes(servs []*descriptor.ServiceDescriptorProto) ([]string, error) {
 	scopeSet := map[string]bool{}
 	for _, s := range servs {
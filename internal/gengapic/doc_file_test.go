@@ -16,6 +16,7 @@ package gengapic
 
 import (
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -147,3 +148,577 @@ func TestDocFileEmptyService(t *testing.T) {
 		g.reset()
 	}
 }
+
+func TestDocFileBuildHeaders(t *testing.T) {
+	var g generator
+	g.opts = &options{pkgPath: "path/to/awesome", pkgName: "awesome", transports: []transport{rest}}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// Metadata added with metadata.AppendToOutgoingContext must be sent
+	// as HTTP headers by REST clients, just as it is sent by gRPC clients.
+	for _, want := range []string{
+		"func buildHeaders(ctx context.Context, mds ...metadata.MD) http.Header {",
+		"if cmd, ok := metadata.FromOutgoingContext(ctx); ok {",
+		"mds = append(mds, cmd)",
+		"md := metadata.Join(mds...)",
+		"headers := http.Header(md)",
+		"return headers",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileBuildHeaders: generated doc file missing %q", want)
+		}
+	}
+}
+
+func TestDocFileBuildHeadersUserAgent(t *testing.T) {
+	var g generator
+	g.opts = &options{pkgPath: "path/to/awesome", pkgName: "awesome", transports: []transport{rest}}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// A default User-Agent is always sent under the canonical key. The http
+	// transport replaces it with the value of option.WithUserAgent, if given.
+	for _, want := range []string{
+		`ua := "gl-go/" + versionGo() + " gapic/" + versionClient`,
+		`if v := md.Get("user-agent"); len(v) > 0 {`,
+		`delete(headers, "user-agent")`,
+		`headers.Set("User-Agent", ua)`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileBuildHeadersUserAgent: generated doc file missing %q", want)
+		}
+	}
+}
+
+func TestDocFileOmitMetadata(t *testing.T) {
+	var g generator
+	g.opts = &options{pkgPath: "path/to/awesome", pkgName: "awesome", transports: []transport{rest}, omitMetadata: true}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// Nothing of the gRPC transport is left, not even to report the codes
+	// of transient errors.
+	for _, unwanted := range []string{`"google.golang.org/grpc`, "insertMetadata", "metadata.MD", "GRPCStatus"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("TestDocFileOmitMetadata: generated doc file contains %q", unwanted)
+		}
+	}
+	if want := "func buildHeaders(ctx context.Context, hds ...http.Header) http.Header {"; !strings.Contains(got, want) {
+		t.Errorf("TestDocFileOmitMetadata: generated doc file missing %q", want)
+	}
+}
+
+func TestDocFileTransientErrors(t *testing.T) {
+	var g generator
+	g.opts = &options{pkgPath: "path/to/awesome", pkgName: "awesome", transports: []transport{rest}}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// Transient network errors report UNAVAILABLE, which the retryers of the
+	// shared call options understand, while cancellation is left as is.
+	for _, want := range []string{
+		"func maybeTransient(err error) error {",
+		"errors.Is(err, context.Canceled)",
+		"return &transientError{err: err}",
+		"return status.New(codes.Unavailable, e.err.Error())",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileTransientErrors: generated doc file missing %q", want)
+		}
+	}
+}
+
+func TestDocFileDebugLogging(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:       "path/to/awesome",
+		pkgName:       "awesome",
+		transports:    []transport{rest},
+		debugLogging:  true,
+		redactHeaders: []string{"x-foo-token"},
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// The Authorization header carries the credentials and must never be logged.
+	for _, want := range []string{
+		`"log"`,
+		`"Authorization": true,`,
+		`"X-Foo-Token": true,`,
+		"func logRequest(httpReq *http.Request) {",
+		"if redactedHeaders[http.CanonicalHeaderKey(k)] {",
+		`v = []string{"REDACTED"}`,
+		debugLoggingVar,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileDebugLogging: generated doc file missing %q, got:\n%s", want, got)
+		}
+	}
+
+	g.reset()
+	g.opts.debugLogging = false
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	if got := g.pt.String(); strings.Contains(got, "logRequest") || strings.Contains(got, `"log"`) {
+		t.Errorf("TestDocFileDebugLogging: unexpected logging without the option, got:\n%s", got)
+	}
+}
+
+func TestDocFileRESTRetryer(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:         "path/to/awesome",
+		pkgName:         "awesome",
+		transports:      []transport{rest},
+		retryIdempotent: true,
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		`"github.com/googleapis/gax-go/v2"`,
+		`"google.golang.org/api/googleapi"`,
+		"func onHTTPCodesOrTransient(bo gax.Backoff, cc ...int) gax.Retryer {",
+		"func (r *restRetryer) Retry(err error) (time.Duration, bool) {",
+		"var terr *transientError",
+		"if errors.As(err, &terr) {",
+		"var gerr *googleapi.Error",
+		"if gerr.Code == c {",
+		"return r.backoff.Pause(), true",
+		"return 0, false",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileRESTRetryer: missing %q, got:\n%s", want, got)
+		}
+	}
+
+	g.reset()
+	g.opts.retryIdempotent = false
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	if got := g.pt.String(); strings.Contains(got, "restRetryer") {
+		t.Errorf("TestDocFileRESTRetryer: want no retryer without rest-retry-idempotent, got:\n%s", got)
+	}
+}
+
+func TestDocFileServerTimeout(t *testing.T) {
+	for _, omitMetadata := range []bool{false, true} {
+		var g generator
+		g.opts = &options{
+			pkgPath:      "path/to/awesome",
+			pkgName:      "awesome",
+			transports:   []transport{rest},
+			omitMetadata: omitMetadata,
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		commonTypes(&g)
+
+		serv := &descriptor.ServiceDescriptorProto{
+			Name: proto.String("Foo"),
+		}
+		g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+		got := g.pt.String()
+
+		// The header is computed per attempt, not once when building the headers.
+		build := strings.Index(got, "func buildHeaders(ctx context.Context")
+		if build < 0 {
+			t.Fatalf("TestDocFileServerTimeout(omitMetadata=%v): missing buildHeaders, got:\n%s", omitMetadata, got)
+		}
+		if strings.Contains(got[build:], "X-Server-Timeout") {
+			t.Errorf("TestDocFileServerTimeout(omitMetadata=%v): want no X-Server-Timeout in buildHeaders, got:\n%s", omitMetadata, got[build:])
+		}
+		for _, want := range []string{
+			"func setServerTimeout(ctx context.Context, headers http.Header) {",
+			`headers.Del("X-Server-Timeout")`,
+			"if remaining := time.Until(d); remaining > 0 {",
+			`headers.Set("X-Server-Timeout", fmt.Sprintf("%.3f", remaining.Seconds()))`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestDocFileServerTimeout(omitMetadata=%v): missing %q, got:\n%s", omitMetadata, want, got)
+			}
+		}
+		if !strings.Contains(got, `"time"`) {
+			t.Errorf("TestDocFileServerTimeout(omitMetadata=%v): missing time import", omitMetadata)
+		}
+	}
+}
+
+func TestDocFileMarshalOptions(t *testing.T) {
+	for _, tst := range []struct {
+		emitUnpopulated bool
+		want            string
+	}{
+		{want: "marshalOpts = protojson.MarshalOptions{AllowPartial: true}"},
+		{emitUnpopulated: true, want: "marshalOpts = protojson.MarshalOptions{AllowPartial: true, EmitUnpopulated: true}"},
+	} {
+		var g generator
+		g.opts = &options{
+			pkgPath:         "path/to/awesome",
+			pkgName:         "awesome",
+			transports:      []transport{rest},
+			emitUnpopulated: tst.emitUnpopulated,
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		commonTypes(&g)
+
+		serv := &descriptor.ServiceDescriptorProto{
+			Name: proto.String("Foo"),
+		}
+		g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+		got := g.pt.String()
+		for _, want := range []string{
+			tst.want,
+			"unmarshalOpts = protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}",
+			`"google.golang.org/protobuf/encoding/protojson"`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestDocFileMarshalOptions(%v): generated doc file missing %q, got:\n%s", tst.emitUnpopulated, want, got)
+			}
+		}
+	}
+}
+
+func TestDocFilePartialResponse(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:         "path/to/awesome",
+		pkgName:         "awesome",
+		transports:      []transport{rest},
+		partialResponse: true,
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		`"github.com/googleapis/gax-go/v2"`,
+		"func WithFields(fields ...string) gax.CallOption {",
+		"func (fieldsOption) Resolve(*gax.CallSettings) {}",
+		"func callFields(opts []gax.CallOption) string {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFilePartialResponse: generated doc file missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDocFileCallEndpoint(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:      "path/to/awesome",
+		pkgName:      "awesome",
+		transports:   []transport{rest},
+		callEndpoint: true,
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		`"github.com/googleapis/gax-go/v2"`,
+		"func WithCallEndpoint(endpoint string) gax.CallOption {",
+		`return endpointOption(strings.TrimRight(endpoint, "/"))`,
+		"func (endpointOption) Resolve(*gax.CallSettings) {}",
+		"func callEndpoint(opts []gax.CallOption, endpoint string) string {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileCallEndpoint: generated doc file missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDocFileETag(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		var g generator
+		g.opts = &options{
+			pkgPath:     "path/to/awesome",
+			pkgName:     "awesome",
+			transports:  []transport{rest},
+			etagHeaders: on,
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		commonTypes(&g)
+
+		serv := &descriptor.ServiceDescriptorProto{
+			Name: proto.String("Foo"),
+		}
+		g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+		got := g.pt.String()
+		for _, want := range []string{
+			"func quoteETag(etag string) string {",
+			`return "\"" + etag + "\""`,
+			"func unquoteETag(etag string) string {",
+			"return etag[1 : len(etag)-1]",
+		} {
+			if strings.Contains(got, want) != on {
+				t.Errorf("TestDocFileETag(%v): generated doc file has %q = %v, got:\n%s", on, want, !on, got)
+			}
+		}
+	}
+}
+
+func TestDocFileRateLimit(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		var g generator
+		g.opts = &options{
+			pkgPath:      "path/to/awesome",
+			pkgName:      "awesome",
+			transports:   []transport{rest},
+			withResponse: on,
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		commonTypes(&g)
+
+		serv := &descriptor.ServiceDescriptorProto{
+			Name: proto.String("Foo"),
+		}
+		g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+		got := g.pt.String()
+		for _, want := range []string{
+			"type RateLimit struct {",
+			"func RateLimitOf(rsp *http.Response) (RateLimit, bool) {",
+			`prefix := "X-RateLimit-"`,
+			`prefix = "RateLimit-"`,
+			`remaining, err := strconv.ParseInt(rsp.Header.Get(prefix+"Remaining"), 10, 64)`,
+			"rl.Reset = time.Unix(reset, 0)",
+		} {
+			if strings.Contains(got, want) != on {
+				t.Errorf("TestDocFileRateLimit(%v): generated doc file has %q = %v, got:\n%s", on, want, !on, got)
+			}
+		}
+	}
+}
+
+func TestDocFileAttemptTimeout(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:    "path/to/awesome",
+		pkgName:    "awesome",
+		transports: []transport{rest},
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		`"github.com/googleapis/gax-go/v2"`,
+		"func WithAttemptTimeout(d time.Duration) gax.CallOption {",
+		"func (attemptTimeoutOption) Resolve(*gax.CallSettings) {}",
+		"func attemptTimeout(opts []gax.CallOption) time.Duration {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileAttemptTimeout: generated doc file missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDocFileMetrics(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		var g generator
+		g.opts = &options{
+			pkgPath:     "path/to/awesome",
+			pkgName:     "awesome",
+			transports:  []transport{rest},
+			restMetrics: on,
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		commonTypes(&g)
+
+		serv := &descriptor.ServiceDescriptorProto{
+			Name: proto.String("Foo"),
+		}
+		g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+		got := g.pt.String()
+		for _, want := range []string{
+			`"go.opentelemetry.io/otel/metric"`,
+			`restMeter = otel.Meter("path/to/awesome")`,
+			"func recordRESTCall(ctx context.Context, method string, start time.Time, httpRsp *http.Response, err error) {",
+			"restRequestLatency.Record(ctx, time.Since(start).Seconds(), attrs)",
+			"restErrorCount.Add(ctx, 1, attrs)",
+			// A failure to create an instrument leaves the noop one.
+			"restRequestCount metric.Int64Counter = noop.Int64Counter{}",
+			`if c, err := restMeter.Int64Counter("rest.client.requests", metric.WithDescription("The number of REST requests sent.")); err == nil {`,
+			// Shadowing would hide the grpc status package.
+			"code := 0",
+		} {
+			if strings.Contains(got, want) != on {
+				t.Errorf("TestDocFileMetrics(%v): generated doc file has %q = %v, got:\n%s", on, want, !on, got)
+			}
+		}
+	}
+}
+
+func TestDocFileMediaUpload(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:     "path/to/awesome",
+		pkgName:     "awesome",
+		transports:  []transport{rest},
+		mediaUpload: true,
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		`"bytes"`,
+		`"io/ioutil"`,
+		`"google.golang.org/api/googleapi"`,
+		`"github.com/googleapis/gax-go/v2"`,
+		"func uploadMedia(ctx context.Context, client httpDoer, session string, headers http.Header, media io.Reader, chunkSize int) ([]byte, error) {",
+		"httpReq.Header = headers.Clone()",
+		`httpReq.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", off, off+int64(len(chunk))-1, total))`,
+		// A 308 resumes from the bytes the session kept.
+		`kept, kerr := keptBytes(httpRsp.Header.Get("Range"))`,
+		"chunk, off = chunk[kept-off:], kept",
+		`last := strings.TrimPrefix(rng, "bytes=0-")`,
+		// 5xx statuses and transient failures are retried.
+		"case httpRsp.StatusCode >= 500:",
+		"if err = maybeTransient(err); !errors.As(err, &terr) {",
+		"if serr := gax.Sleep(ctx, bo.Pause()); serr != nil {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileMediaUpload: generated doc file missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDocFileDetachedContext(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:    "path/to/awesome",
+		pkgName:    "awesome",
+		transports: []transport{rest},
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+	for _, want := range []string{
+		"type detachedContext struct {",
+		"func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }",
+		"func (detachedContext) Done() <-chan struct{} { return nil }",
+		"func (detachedContext) Err() error { return nil }",
+		"func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileDetachedContext: missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestDocFileRESTClientOptionsCheck(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:    "path/to/awesome",
+		pkgName:    "awesome",
+		transports: []transport{rest},
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	got := g.pt.String()
+
+	// gRPC-only options are recognized by the type their exported
+	// constructors return, rather than by the internal settings they apply.
+	for _, want := range []string{
+		`fmt.Sprintf("%T", option.WithGRPCConn(nil)):            "option.WithGRPCConn",`,
+		`fmt.Sprintf("%T", option.WithGRPCConnectionPool(nil)): "option.WithGRPCConnectionPool",`,
+		"func checkRESTClientOptions(opts []option.ClientOption) error {",
+		`if name, ok := grpcOnlyOptions[fmt.Sprintf("%T", o)]; ok {`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestDocFileRESTClientOptionsCheck: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `"reflect"`) {
+		t.Errorf("TestDocFileRESTClientOptionsCheck: want no reflection, got:\n%s", got)
+	}
+
+	g.reset()
+	g.opts.transports = []transport{grpc}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	if got := g.pt.String(); strings.Contains(got, "checkRESTClientOptions") {
+		t.Errorf("TestDocFileRESTClientOptionsCheck: want no check without REST, got:\n%s", got)
+	}
+}
+
+func TestDocFileUnmarshalPresence(t *testing.T) {
+	var g generator
+	g.opts = &options{
+		pkgPath:    "path/to/awesome",
+		pkgName:    "awesome",
+		transports: []transport{rest},
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	commonTypes(&g)
+
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	g.genDocFile(42, []string{"https://foo.bar.com/auth"}, serv)
+	// protojson reads a JSON null as an absent field, so optional response
+	// fields keep their presence as long as no option overrides the default.
+	want := "unmarshalOpts = protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}"
+	if got := g.pt.String(); !strings.Contains(got, want) {
+		t.Errorf("TestDocFileUnmarshalPresence: missing %q, got:\n%s", want, got)
+	}
+}
@@ -42,7 +42,7 @@ func (g *generator) genExampleFile(serv *descriptor.ServiceDescriptorProto) erro
 
 func (g *generator) exampleClientFactory(pkgName, servName string) {
 	p := g.printf
-	for _, t := range g.opts.transports {
+	for _, t := range g.exampleTransports() {
 		s := servName
 		if t == rest {
 			s += "REST"
@@ -60,6 +60,16 @@ func (g *generator) exampleClientFactory(pkgName, servName string) {
 	g.imports[pbinfo.ImportSpec{Path: "context"}] = true
 }
 
+// exampleTransports returns the transports to generate examples for. When the
+// REST client is build constrained, it is left out in favor of the
+// unconstrained transports, if there are any.
+func (g *generator) exampleTransports() []transport {
+	if !g.splitREST() || !containsTransport(g.opts.transports, grpc) {
+		return g.opts.transports
+	}
+	return []transport{grpc}
+}
+
 func (g *generator) exampleInitClient(pkgName, servName string) {
 	p := g.printf
 
@@ -110,7 +120,7 @@ func (g *generator) exampleMethodBody(pkgName, servName string, m *descriptor.Me
 	g.imports[inSpec] = true
 	// Pick the first transport for simplicity. We don't need examples
 	// of each method for both transports when they have the same surface.
-	t := g.opts.transports[0]
+	t := g.exampleTransports()[0]
 	s := servName
 	if t == rest {
 		s += "REST"
@@ -88,8 +88,9 @@ func (g *generator) init(req *plugin.CodeGeneratorRequest) error {
 	g.imports = map[pbinfo.ImportSpec]bool{}
 	g.customOpServices = map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{}
 	g.aux = &auxTypes{
-		iters: map[string]*iterType{},
-		lros:  map[*descriptor.MethodDescriptorProto]bool{},
+		iters:           map[string]*iterType{},
+		lros:            map[*descriptor.MethodDescriptorProto]bool{},
+		resourceRegexps: map[string]*resourceRegexp{},
 	}
 
 	opts, err := parseOptions(req.Parameter)
@@ -177,7 +178,18 @@ func (g *generator) printf(s string, a ...interface{}) {
 }
 
 func (g *generator) commit(fileName, pkgName string) {
+	g.commitWithBuildTag(fileName, pkgName, "")
+}
+
+// commitWithBuildTag is like commit, but if buildTag is non-empty the file
+// is prefixed with a build constraint so that it is only compiled when the
+// tag is set.
+func (g *generator) commitWithBuildTag(fileName, pkgName, buildTag string) {
 	var header strings.Builder
+	if buildTag != "" {
+		fmt.Fprintf(&header, "//go:build %s\n", buildTag)
+		fmt.Fprintf(&header, "// +build %s\n\n", buildTag)
+	}
 	fmt.Fprintf(&header, license.Apache, time.Now().Year())
 	fmt.Fprintf(&header, "package %s\n\n", pkgName)
 
@@ -36,9 +36,16 @@ const (
 	// protoc puts a dot in front of name, signaling that the name is fully qualified.
 	emptyType               = "." + emptyValue
 	lroType                 = ".google.longrunning.Operation"
+	structType              = ".google.protobuf.Struct"
+	valueType               = ".google.protobuf.Value"
+	listValueType           = ".google.protobuf.ListValue"
+	anyType                 = ".google.protobuf.Any"
+	fieldMaskType           = ".google.protobuf.FieldMask"
 	alpha                   = "alpha"
 	beta                    = "beta"
 	disableDeadlinesVar     = "GOOGLE_API_GO_EXPERIMENTAL_DISABLE_DEFAULT_DEADLINE"
+	debugLoggingVar         = "GOOGLE_API_GO_EXPERIMENTAL_REST_DEBUG_LOGGING"
+	transportVar            = "GOOGLE_API_GO_EXPERIMENTAL_TRANSPORT"
 	fieldTypeBool           = descriptor.FieldDescriptorProto_TYPE_BOOL
 	fieldTypeString         = descriptor.FieldDescriptorProto_TYPE_STRING
 	fieldTypeBytes          = descriptor.FieldDescriptorProto_TYPE_BYTES
@@ -46,6 +53,7 @@ const (
 	fieldLabelRepeated      = descriptor.FieldDescriptorProto_LABEL_REPEATED
 	defaultPollInitialDelay = "time.Second" // 1 second
 	defaultPollMaxDelay     = "time.Minute" // 1 minute
+	defaultPollMultiplier   = "1.30"
 )
 
 var headerParamRegexp = regexp.MustCompile(`{([_.a-z0-9]+)`)
@@ -69,6 +77,7 @@ func Gen(genReq *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse, er
 	}
 
 	g.checkIAMPolicyOverrides(genServs)
+	g.applyHTTPRuleOverrides(genServs)
 
 	if g.serviceConfig != nil {
 		g.apiName = g.serviceConfig.GetTitle()
@@ -105,12 +114,25 @@ func Gen(genReq *plugin.CodeGeneratorRequest) (*plugin.CodeGeneratorResponse, er
 		}
 		g.commit(outFile+"_client.go", g.opts.pkgName)
 
+		if g.splitREST() {
+			g.reset()
+			if err := g.genRESTFile(s); err != nil {
+				return &g.resp, err
+			}
+			g.commitWithBuildTag(outFile+"_rest_client.go", g.opts.pkgName, g.opts.restBuildTag)
+		}
+
 		g.reset()
 		if err := g.genExampleFile(s); err != nil {
 			return &g.resp, errors.E(err, "example: %s", s.GetName())
 		}
 		g.imports[pbinfo.ImportSpec{Name: g.opts.pkgName, Path: g.opts.pkgPath}] = true
-		g.commit(outFile+"_client_example_test.go", g.opts.pkgName+"_test")
+		var exampleTag string
+		if g.splitREST() && !containsTransport(g.opts.transports, grpc) {
+			// The examples can only use the REST client, which is build constrained.
+			exampleTag = g.opts.restBuildTag
+		}
+		g.commitWithBuildTag(outFile+"_client_example_test.go", g.opts.pkgName+"_test", exampleTag)
 	}
 
 	g.reset()
@@ -166,6 +188,16 @@ func (g *generator) gen(serv *descriptor.ServiceDescriptorProto) error {
 				return err
 			}
 		case rest:
+			if g.splitREST() {
+				// The REST methods are generated in a separate file, but the
+				// LRO wrapper types they return are shared with the client.
+				for _, m := range serv.GetMethod() {
+					if g.isLRO(m) {
+						g.aux.lros[m] = true
+					}
+				}
+				continue
+			}
 			if err := g.genRESTMethods(serv, servName); err != nil {
 				return err
 			}
@@ -225,6 +257,11 @@ type auxTypes struct {
 	// which is in turn determined by the element type name.
 	iters map[string]*iterType
 
+	// Regexps that match the names of referenced resources, keyed by the name of
+	// the package-level variable holding each. Like iterators, they are shared by
+	// every REST client in the package and are generated at most once.
+	resourceRegexps map[string]*resourceRegexp
+
 	customOp *customOp
 }
 
@@ -377,20 +414,25 @@ func (g *generator) lookupField(msgName, field string) *descriptor.FieldDescript
 	for _, seg := range strings.Split(field, ".") {
 		// Look up the desired field by name, stopping if the leaf field is
 		// found, continuing if the field is a nested message.
+		desc = nil
 		for _, f := range msgFields {
 			if f.GetName() == seg {
 				desc = f
-
-				// Search the nested message for the next segment of the
-				// nested field chain.
-				if f.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
-					msg = g.descInfo.Type[f.GetTypeName()]
-					msgProto = msg.(*descriptor.DescriptorProto)
-					msgFields = msgProto.GetField()
-				}
 				break
 			}
 		}
+		// Every segment of the chain must name a field, otherwise an
+		// ancestor of the missing field would be returned.
+		if desc == nil {
+			return nil
+		}
+
+		// Search the nested message for the next segment of the
+		// nested field chain. Scalars have no further segments.
+		msgFields = nil
+		if sub, ok := g.descInfo.Type[desc.GetTypeName()].(*descriptor.DescriptorProto); ok {
+			msgFields = sub.GetField()
+		}
 	}
 	return desc
 }
@@ -524,6 +566,8 @@ func parseRequestHeaders(m *descriptor.MethodDescriptorProto) ([][]string, error
 			pattern = rule.GetPut()
 		case *annotations.HttpRule_Delete:
 			pattern = rule.GetDelete()
+		case *annotations.HttpRule_Custom:
+			pattern = rule.GetCustom().GetPath()
 		}
 
 		matches = append(matches, headerParamRegexp.FindAllStringSubmatch(pattern, -1)...)
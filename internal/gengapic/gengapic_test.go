@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
@@ -1046,3 +1047,156 @@ func setHTTPOption(o *descriptor.MethodOptions, pattern string) {
 		},
 	})
 }
+
+func TestGenRESTBuildTag(t *testing.T) {
+	inType := &descriptor.DescriptorProto{
+		Name: proto.String("GetFooRequest"),
+	}
+	outType := &descriptor.DescriptorProto{
+		Name: proto.String("Foo"),
+	}
+	mOpts := &descriptor.MethodOptions{}
+	setHTTPOption(mOpts, "/v1/foo")
+	sOpts := &descriptor.ServiceOptions{}
+	proto.SetExtension(sOpts, annotations.E_DefaultHost, "foo.googleapis.com")
+	proto.SetExtension(sOpts, annotations.E_OauthScopes, "https://foo.googleapis.com/auth")
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{
+			{
+				Name:       proto.String("GetFoo"),
+				InputType:  proto.String(".google.cloud.foo.v1.GetFooRequest"),
+				OutputType: proto.String(".google.cloud.foo.v1.Foo"),
+				Options:    mOpts,
+			},
+		},
+		Options: sOpts,
+	}
+	f := &descriptor.FileDescriptorProto{
+		Name:    proto.String("google/cloud/foo/v1/foo.proto"),
+		Package: proto.String("google.cloud.foo.v1"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("google.golang.org/genproto/googleapis/cloud/foo/v1;foo"),
+		},
+		MessageType: []*descriptor.DescriptorProto{inType, outType},
+		Service:     []*descriptor.ServiceDescriptorProto{serv},
+	}
+
+	for _, tst := range []struct {
+		name, param string
+		tagged      []string
+	}{
+		{
+			name:  "no_tag",
+			param: "go-gapic-package=cloud.google.com/go/foo/apiv1;foo,transport=grpc+rest",
+		},
+		{
+			name:   "grpc_and_rest",
+			param:  "go-gapic-package=cloud.google.com/go/foo/apiv1;foo,transport=grpc+rest,rest-build-tag=rest",
+			tagged: []string{"cloud.google.com/go/foo/apiv1/foo_rest_client.go"},
+		},
+		{
+			name:  "rest_only",
+			param: "go-gapic-package=cloud.google.com/go/foo/apiv1;foo,transport=rest,rest-build-tag=rest",
+			tagged: []string{
+				"cloud.google.com/go/foo/apiv1/foo_rest_client.go",
+				"cloud.google.com/go/foo/apiv1/foo_client_example_test.go",
+			},
+		},
+	} {
+		resp, err := Gen(&pluginpb.CodeGeneratorRequest{
+			FileToGenerate: []string{f.GetName()},
+			Parameter:      proto.String(tst.param),
+			ProtoFile:      []*descriptor.FileDescriptorProto{f},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tst.name, err)
+		}
+
+		var got []string
+		for _, file := range resp.GetFile() {
+			if strings.HasPrefix(file.GetContent(), "//go:build rest\n// +build rest\n\n") {
+				got = append(got, file.GetName())
+			}
+		}
+		if diff := cmp.Diff(got, tst.tagged); diff != "" {
+			t.Errorf("%s: build tagged files got(-),want(+):\n%s", tst.name, diff)
+		}
+	}
+}
+
+func TestGenRESTSeparateFile(t *testing.T) {
+	var servs []*descriptor.ServiceDescriptorProto
+	var msgs []*descriptor.DescriptorProto
+	for _, name := range []string{"Foo", "Bar"} {
+		mOpts := &descriptor.MethodOptions{}
+		setHTTPOption(mOpts, "/v1/"+strings.ToLower(name))
+		sOpts := &descriptor.ServiceOptions{}
+		proto.SetExtension(sOpts, annotations.E_DefaultHost, "foo.googleapis.com")
+		proto.SetExtension(sOpts, annotations.E_OauthScopes, "https://foo.googleapis.com/auth")
+		servs = append(servs, &descriptor.ServiceDescriptorProto{
+			Name: proto.String(name + "Service"),
+			Method: []*descriptor.MethodDescriptorProto{
+				{
+					Name:       proto.String("Get" + name),
+					InputType:  proto.String(".google.cloud.foo.v1.Get" + name + "Request"),
+					OutputType: proto.String(".google.cloud.foo.v1." + name),
+					Options:    mOpts,
+				},
+			},
+			Options: sOpts,
+		})
+		msgs = append(msgs,
+			&descriptor.DescriptorProto{Name: proto.String("Get" + name + "Request")},
+			&descriptor.DescriptorProto{Name: proto.String(name)})
+	}
+	f := &descriptor.FileDescriptorProto{
+		Name:    proto.String("google/cloud/foo/v1/foo.proto"),
+		Package: proto.String("google.cloud.foo.v1"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("google.golang.org/genproto/googleapis/cloud/foo/v1;foo"),
+		},
+		MessageType: msgs,
+		Service:     servs,
+	}
+
+	resp, err := Gen(&pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{f.GetName()},
+		Parameter:      proto.String("go-gapic-package=cloud.google.com/go/foo/apiv1;foo,transport=grpc+rest,rest-separate-file"),
+		ProtoFile:      []*descriptor.FileDescriptorProto{f},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A file without a name continues the file before it.
+	files := map[string]string{}
+	var last string
+	for _, file := range resp.GetFile() {
+		if file.GetName() != "" {
+			last = file.GetName()
+		}
+		files[last] += file.GetContent()
+	}
+	// The service named after the package has the unprefixed client.
+	for name, method := range map[string]string{
+		"foo": "func (c *restClient) GetFoo(",
+		"bar": "func (c *barRESTClient) GetBar(",
+	} {
+		restFile := "cloud.google.com/go/foo/apiv1/" + name + "_rest_client.go"
+		content, ok := files[restFile]
+		if !ok {
+			t.Errorf("TestGenRESTSeparateFile: missing %s", restFile)
+			continue
+		}
+		if strings.HasPrefix(content, "//go:build") {
+			t.Errorf("TestGenRESTSeparateFile: %s is build constrained", restFile)
+		}
+		if !strings.Contains(content, method) {
+			t.Errorf("TestGenRESTSeparateFile: %s missing %q", restFile, method)
+		}
+		if client := files["cloud.google.com/go/foo/apiv1/"+name+"_client.go"]; strings.Contains(client, method) {
+			t.Errorf("TestGenRESTSeparateFile: REST method %q also generated in %s_client.go", method, name)
+		}
+	}
+}
@@ -318,11 +318,26 @@ func (g *generator) grpcClientUtilities(serv *descriptor.ServiceDescriptorProto,
 	clientName = strings.Replace(clientName, "_", " ", -1)
 	lowcaseServName := lowcaseGRPCClientName(servName)
 
+	// With both transports, the REST client can be selected at runtime
+	// without changing the constructor that is called.
+	dispatch := g.restDispatch()
+
 	// Factory function
 	p("// New%sClient creates a new %s client based on gRPC.", servName, clientName)
 	p("// The returned client must be Closed when it is done being used to clean up its underlying connections.")
+	if dispatch {
+		p("//")
+		p("// To use REST instead, set the %s environment", transportVar)
+		p("// variable to \"rest\", which makes it return New%sRESTClient(ctx, opts...).", servName)
+	}
 	g.serviceDoc(serv)
 	p("func New%[1]sClient(ctx context.Context, opts ...option.ClientOption) (*%[1]sClient, error) {", servName)
+	if dispatch {
+		p("  if useRESTTransport() {")
+		p("    return New%sRESTClient(ctx, opts...)", servName)
+		p("  }")
+		p("")
+	}
 	p("  clientOpts := default%[1]sGRPCClientOptions()", servName)
 
 	p("  if new%sClientHook != nil {", servName)
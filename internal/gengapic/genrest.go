@@ -16,15 +16,19 @@ package gengapic
 
 import (
 	"fmt"
-	"net/http"
+	"log"
+	"net/textproto"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
+	"unicode"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/googleapis/gapic-generator-go/internal/errors"
 	"github.com/googleapis/gapic-generator-go/internal/pbinfo"
 	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -45,8 +49,18 @@ func (g *generator) restClientInit(serv *descriptor.ServiceDescriptorProto, serv
 	p("  // The http endpoint to connect to.")
 	p("  endpoint string")
 	p("")
-	p("  // The http client.")
-	p("  httpClient *http.Client")
+	p("  // The http client. The constructor sets an *http.Client.")
+	p("  httpClient httpDoer")
+	p("")
+	p("  // Points back to the CallOptions field of the containing %sClient", servName)
+	p("  CallOptions **%sCallOptions", servName)
+	p("")
+	p("  // Point back to the interceptor fields of the containing %sClient", servName)
+	p("  requestInterceptor *func(*http.Request) error")
+	p("  responseInterceptor *func(*http.Response) error")
+	p("")
+	p("  // flag to opt out of default deadlines via %s", disableDeadlinesVar)
+	p("  disableDeadlines bool")
 	p("")
 	if opServ, ok := g.customOpServices[serv]; ok {
 		opServName := pbinfo.ReduceServName(opServ.GetName(), g.opts.pkgName)
@@ -54,8 +68,20 @@ func (g *generator) restClientInit(serv *descriptor.ServiceDescriptorProto, serv
 		p("operationClient *%sClient", opServName)
 		p("")
 	}
+	if hasRPCForLRO {
+		p("// LROClient is used internally to handle long-running operations.")
+		p("// It is exposed so that its CallOptions can be modified if required.")
+		p("// Users should not Close this client.")
+		p("LROClient **lroauto.OperationsClient")
+		p("")
+		g.imports[pbinfo.ImportSpec{Name: "lroauto", Path: "cloud.google.com/go/longrunning/autogen"}] = true
+	}
 	p("	 // The x-goog-* metadata to be sent with each request.")
-	p("	 xGoogMetadata metadata.MD")
+	if g.restHTTPHeaders() {
+		p("	 xGoogMetadata http.Header")
+	} else {
+		p("	 xGoogMetadata metadata.MD")
+	}
 	p("}")
 	p("")
 	g.restClientUtilities(serv, servName, imp, hasRPCForLRO)
@@ -64,11 +90,58 @@ func (g *generator) restClientInit(serv *descriptor.ServiceDescriptorProto, serv
 	g.imports[pbinfo.ImportSpec{Path: "net/url"}] = true
 	g.imports[pbinfo.ImportSpec{Path: "io/ioutil"}] = true
 	g.imports[pbinfo.ImportSpec{Path: "fmt"}] = true
-	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc/metadata"}] = true
+	if !g.restHTTPHeaders() {
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc/metadata"}] = true
+	}
 	g.imports[pbinfo.ImportSpec{Name: "httptransport", Path: "google.golang.org/api/transport/http"}] = true
 	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/option/internaloption"}] = true
 }
 
+// splitREST reports if the REST client of each service should be generated in
+// its own file rather than alongside the other transports. The file is build
+// constrained if a rest-build-tag is set.
+func (g *generator) splitREST() bool {
+	return (g.opts.restBuildTag != "" || g.opts.separateREST) && containsTransport(g.opts.transports, rest)
+}
+
+// restDispatch reports whether New*Client returns the REST client when the
+// transport is selected at runtime. It cannot when the REST client is build
+// constrained, as the untagged gRPC constructor would not compile without it.
+func (g *generator) restDispatch() bool {
+	return containsTransport(g.opts.transports, rest) && containsTransport(g.opts.transports, grpc) && g.opts.restBuildTag == ""
+}
+
+// genRESTFile generates the REST client type and its methods for the given
+// service. It is used in place of the inline REST generation when the REST
+// client is split into a separate file.
+func (g *generator) genRESTFile(serv *descriptor.ServiceDescriptorProto) error {
+	servName := pbinfo.ReduceServName(serv.GetName(), g.opts.pkgName)
+
+	imp, err := g.descInfo.ImportSpec(serv)
+	if err != nil {
+		return err
+	}
+
+	var hasLRO bool
+	for _, m := range serv.GetMethod() {
+		if g.isLRO(m) {
+			hasLRO = true
+			break
+		}
+	}
+
+	// These are otherwise imported by the shared client surface.
+	g.imports[pbinfo.ImportSpec{Path: "context"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/option"}] = true
+	if !g.opts.omitConnection {
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc"}] = true
+	}
+	g.imports[pbinfo.ImportSpec{Name: "gax", Path: "github.com/googleapis/gax-go/v2"}] = true
+
+	g.restClientInit(serv, servName, imp, hasLRO)
+	return g.genRESTMethods(serv, servName)
+}
+
 func (g *generator) genRESTMethods(serv *descriptor.ServiceDescriptorProto, servName string) error {
 	g.addMetadataServiceForTransport(serv.GetName(), "rest", servName)
 
@@ -81,23 +154,42 @@ func (g *generator) genRESTMethods(serv *descriptor.ServiceDescriptorProto, serv
 		}
 		g.addMetadataMethod(serv.GetName(), "rest", m.GetName())
 	}
+	g.restResourceRegexps()
 
 	return nil
 }
 
 func (g *generator) restClientOptions(serv *descriptor.ServiceDescriptorProto, servName string) error {
+	p := g.printf
+
 	if !proto.HasExtension(serv.GetOptions(), annotations.E_DefaultHost) {
-		// Not an error, just doesn't apply to us.
+		// The constructor always references the defaults, so emit them even
+		// without a host. Users must then supply option.WithEndpoint.
+		p("func default%sRESTClientOptions() []option.ClientOption {", servName)
+		p("  return []option.ClientOption{")
+		p("    internaloption.WithDefaultScopes(DefaultAuthScopes()...),")
+		p("  }")
+		p("}")
+		p("")
 		return nil
 	}
 
-	p := g.printf
-
 	eHost := proto.GetExtension(serv.GetOptions(), annotations.E_DefaultHost)
 
 	// Default to https, just as gRPC defaults to a secure connection.
 	host := fmt.Sprintf("https://%s", eHost.(string))
 
+	// Only internaloption.WithDefault* options belong here. The transport
+	// falls back to them when the user supplies no equivalent, e.g. scopes
+	// given with option.WithScopes replace the defaults instead of adding to
+	// them, and option.WithCredentialsFile or WithCredentialsJSON are used
+	// with whichever scopes apply.
+	//
+	// httptransport.NewClient switches to the mTLS endpoint by itself when
+	// GOOGLE_API_USE_CLIENT_CERTIFICATE enables a client certificate, and
+	// GOOGLE_API_USE_MTLS_ENDPOINT does not disable it, so both endpoints
+	// must be given.
+
 	p("func default%sRESTClientOptions() []option.ClientOption {", servName)
 	p("  return []option.ClientOption{")
 	p("    internaloption.WithDefaultEndpoint(%q),", host)
@@ -106,10 +198,31 @@ func (g *generator) restClientOptions(serv *descriptor.ServiceDescriptorProto, s
 	p("    internaloption.WithDefaultScopes(DefaultAuthScopes()...),")
 	p("  }")
 	p("}")
+	p("")
+
+	if g.opts.regionalEndpoint {
+		tmpl := regionalEndpointTemplate(eHost.(string))
+		p("// With%sRESTRegion returns a ClientOption that sends requests to the regional", servName)
+		p("// endpoint of the service in region, e.g. %q for", fmt.Sprintf(tmpl, "us-central1"))
+		p("// \"us-central1\". Like option.WithEndpoint, it disables the switch to mTLS.")
+		p("func With%sRESTRegion(region string) option.ClientOption {", servName)
+		p("  return option.WithEndpoint(fmt.Sprintf(%q, region))", tmpl)
+		p("}")
+		p("")
+		g.imports[pbinfo.ImportSpec{Path: "fmt"}] = true
+	}
 
 	return nil
 }
 
+// regionalEndpointTemplate returns the format of the regional endpoints of a
+// service at host, which prefix it with the region, e.g.
+// https://us-central1-foo.googleapis.com.
+func regionalEndpointTemplate(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	return "https://%s-" + host
+}
+
 func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto, servName string, imp pbinfo.ImportSpec, hasRPCForLRO bool) {
 	p := g.printf
 	lowcaseServName := lowcaseRestClientName(servName)
@@ -118,25 +231,62 @@ func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto,
 	opServ, hasCustomOp := g.customOpServices[serv]
 
 	p("// New%sRESTClient creates a new %s rest client.", servName, clientName)
+	p("//")
+	p("// To tune the underlying *http.Transport, e.g. its MaxIdleConns or TLS")
+	p("// configuration, pass option.WithHTTPClient with a client using it.")
+	p("// Such a client is used as is, so it must also send any x-goog-user-project")
+	p("// header itself, as option.WithQuotaProject only applies to a transport")
+	p("// created by the client.")
+	if hasCustomOp {
+		p("// The client is shared with the operation client.")
+	}
 	g.serviceDoc(serv)
 	p("func New%[1]sRESTClient(ctx context.Context, opts ...option.ClientOption) (*%[1]sClient, error) {", servName)
+	// All user-supplied options must reach httptransport.NewClient unmodified.
+	// The transport it creates sends the x-goog-user-project header of
+	// option.WithQuotaProject, and is shared with the operation clients.
+	p("    if err := checkRESTClientOptions(opts); err != nil {")
+	p("        return nil, err")
+	p("    }")
+	p("    disableDeadlines, err := checkDisableDeadlines()")
+	p("    if err != nil {")
+	p("        return nil, err")
+	p("    }")
+	p("")
 	p("    clientOpts := append(default%sRESTClientOptions(), opts...)", servName)
-	p("    httpClient, endpoint, err := httptransport.NewClient(ctx, clientOpts...)")
+	// Cancelling ctx once the client is created must not break the token
+	// refreshes of its credentials, see detachedContext.
+	p("    httpClient, endpoint, err := httptransport.NewClient(detachedContext{ctx}, clientOpts...)")
 	p("    if err != nil {")
 	p("        return nil, err")
 	p("    }")
 	p("")
+	if g.opts.retryIdempotent {
+		p("    client := %[1]sClient{CallOptions: default%[1]sRESTCallOptions()}", servName)
+	} else {
+		p("    client := %[1]sClient{CallOptions: &%[1]sCallOptions{}}", servName)
+	}
+	p("")
 	p("    c := &%s{", lowcaseServName)
-	p("        endpoint: endpoint,")
+	// The endpoint may carry a path prefix, e.g. when served behind a gateway.
+	// Each method appends a path template that starts with a slash, so drop
+	// any trailing slashes here to avoid a "//" where the two are joined.
+	p(`        endpoint: strings.TrimRight(endpoint, "/"),`)
 	p("        httpClient: httpClient,")
+	p("        CallOptions: &client.CallOptions,")
+	p("        requestInterceptor: &client.RequestInterceptor,")
+	p("        responseInterceptor: &client.ResponseInterceptor,")
+	p("        disableDeadlines: disableDeadlines,")
 	p("    }")
 	p("    c.setGoogleClientInfo()")
 	p("")
 	if hasCustomOp {
+		// httpClient is the one given with option.WithHTTPClient, if any, so
+		// the operation client reuses the transport tuned by the user.
 		opServName := pbinfo.ReduceServName(opServ.GetName(), g.opts.pkgName)
 		p("o := []option.ClientOption{")
 		p("  option.WithHTTPClient(httpClient),")
-		p("  option.WithEndpoint(endpoint),")
+		p("  option.WithEndpoint(c.endpoint),")
 		p("}")
 		p("opC, err := New%sRESTClient(ctx, o...)", opServName)
 		p("if err != nil {")
@@ -146,22 +296,54 @@ func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto,
 		p("")
 		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/option"}] = true
 	}
+	if hasRPCForLRO {
+		// Operations are polled over the same transport, and at the same
+		// endpoint, as the service. The trimmed endpoint is given, as the
+		// operations client joins paths to it the same way.
+		p("lroOpts := []option.ClientOption{")
+		p("  option.WithHTTPClient(httpClient),")
+		p("  option.WithEndpoint(c.endpoint),")
+		p("}")
+		p("opClient, err := lroauto.NewOperationsRESTClient(ctx, lroOpts...)")
+		p("if err != nil {")
+		p("  return nil, err")
+		p("}")
+		p("client.LROClient = opClient")
+		p("c.LROClient = &client.LROClient")
+		p("")
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/option"}] = true
+	}
 	// TODO(dovs): make rest default call options
-	// TODO(dovs): set the LRO client
-	p("    return &%[1]sClient{internalClient: c, CallOptions: &%[1]sCallOptions{}}, nil", servName)
+	// Each method prepends its CallOptions to those of the call, so that
+	// the latter take precedence.
+	p("    client.internalClient = c")
+	p("")
+	p("    return &client, nil")
 	p("}")
 	p("")
+	g.imports[pbinfo.ImportSpec{Path: "strings"}] = true
 
 	g.restClientOptions(serv, servName)
+	if g.opts.retryIdempotent {
+		g.restCallOptions(serv, servName)
+	}
 
 	// setGoogleClientInfo method
+	header := g.restAPIClientHeader()
 	p("// setGoogleClientInfo sets the name and version of the application in")
-	p("// the `x-goog-api-client` header passed on each request. Intended for")
+	p("// the `%s` header passed on each request. Intended for", header)
 	p("// use by Google-written clients.")
 	p("func (c *%s) setGoogleClientInfo(keyval ...string) {", lowcaseServName)
 	p(`  kv := append([]string{"gl-go", versionGo()}, keyval...)`)
 	p(`  kv = append(kv, "gapic", versionClient, "gax", gax.Version, "rest", "UNKNOWN")`)
-	p(`  c.xGoogMetadata = metadata.Pairs("x-goog-api-client", gax.XGoogHeader(kv...))`)
+	if features := g.restFeatureTokens(); len(features) > 0 {
+		p("  kv = append(kv, %s)", strings.Join(features, ", "))
+	}
+	if g.restHTTPHeaders() {
+		p(`  c.xGoogMetadata = http.Header{%q: []string{gax.XGoogHeader(kv...)}}`, textproto.CanonicalMIMEHeaderKey(header))
+	} else {
+		p(`  c.xGoogMetadata = metadata.Pairs(%q, gax.XGoogHeader(kv...))`, header)
+	}
 	p("}")
 	p("")
 
@@ -169,7 +351,11 @@ func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto,
 	p("// Close closes the connection to the API service. The user should invoke this when")
 	p("// the client is no longer required.")
 	p("func (c *%s) Close() error {", lowcaseServName)
-	p("    // Replace httpClient with nil to force cleanup.")
+	p("    // Release any pooled connections held by the transport, then")
+	p("    // replace httpClient with nil to force cleanup.")
+	p("    if hc, ok := c.httpClient.(interface{ CloseIdleConnections() }); ok {")
+	p("        hc.CloseIdleConnections()")
+	p("    }")
 	p("    c.httpClient = nil")
 	if hasCustomOp {
 		p("if err := c.operationClient.Close(); err != nil {")
@@ -180,6 +366,32 @@ func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto,
 	p("}")
 	p("")
 
+	p("// interceptRequest calls the RequestInterceptor of the client, if any, with req.")
+	p("func (c *%s) interceptRequest(req *http.Request) error {", lowcaseServName)
+	p("    if c.requestInterceptor == nil || *c.requestInterceptor == nil {")
+	p("        return nil")
+	p("    }")
+	p("    return (*c.requestInterceptor)(req)")
+	p("}")
+	p("")
+	p("// interceptResponse calls the ResponseInterceptor of the client, if any, with resp.")
+	p("func (c *%s) interceptResponse(resp *http.Response) error {", lowcaseServName)
+	p("    if c.responseInterceptor == nil || *c.responseInterceptor == nil {")
+	p("        return nil")
+	p("    }")
+	p("    return (*c.responseInterceptor)(resp)")
+	p("}")
+	p("")
+	p("// Endpoint returns the endpoint requests are sent to, as resolved from the")
+	p("// client options and the environment when the client was created.")
+	p("func (c *%s) Endpoint() string {", lowcaseServName)
+	p("    return c.endpoint")
+	p("}")
+	p("")
+
+	if g.opts.omitConnection {
+		return
+	}
 	p("// Connection returns a connection to the API service.")
 	p("//")
 	p("// Deprecated.")
@@ -190,17 +402,33 @@ func (g *generator) restClientUtilities(serv *descriptor.ServiceDescriptorProto,
 
 type httpInfo struct {
 	verb, url, body string
+	// bindings are the additional_bindings of the rule, in declared order.
+	bindings []*httpInfo
+}
+
+// invalidBody reports whether the rule binds a body to a verb that cannot
+// carry one, which HTTP transcoding forbids.
+func (info *httpInfo) invalidBody() bool {
+	return info.body != "" && (info.verb == "get" || info.verb == "delete")
 }
 
 func (g *generator) pathParams(m *descriptor.MethodDescriptorProto) map[string]*descriptor.FieldDescriptorProto {
+	return g.bindingPathParams(m, getHTTPInfo(m))
+}
+
+// bindingPathParams returns the path params of m bound by the URL of info,
+// which is either the primary HTTP rule of m or one of its additional
+// bindings.
+func (g *generator) bindingPathParams(m *descriptor.MethodDescriptorProto, info *httpInfo) map[string]*descriptor.FieldDescriptorProto {
 	pathParams := map[string]*descriptor.FieldDescriptorProto{}
-	info := getHTTPInfo(m)
 	if info == nil {
 		return pathParams
 	}
 
 	// Match using the curly braces but don't include them in the grouping.
-	re := regexp.MustCompile("{([^}]+)}")
+	// Any path template after the '=' is not part of the field name, e.g.
+	// {name=projects/*}:cancel only binds the name field.
+	re := regexp.MustCompile(`{([a-zA-Z0-9_.]+?)(=[^{}]+)?}`)
 	for _, p := range re.FindAllStringSubmatch(info.url, -1) {
 		// In the returned slice, the zeroth element is the full regex match,
 		// and the subsequent elements are the sub group matches.
@@ -217,8 +445,14 @@ func (g *generator) pathParams(m *descriptor.MethodDescriptorProto) map[string]*
 }
 
 func (g *generator) queryParams(m *descriptor.MethodDescriptorProto) map[string]*descriptor.FieldDescriptorProto {
+	return g.bindingQueryParams(m, getHTTPInfo(m))
+}
+
+// bindingQueryParams returns the query params of m when it is sent with the
+// binding info, which is either the primary HTTP rule of m or one of its
+// additional bindings.
+func (g *generator) bindingQueryParams(m *descriptor.MethodDescriptorProto, info *httpInfo) map[string]*descriptor.FieldDescriptorProto {
 	queryParams := map[string]*descriptor.FieldDescriptorProto{}
-	info := getHTTPInfo(m)
 	if info == nil {
 		return queryParams
 	}
@@ -227,7 +461,7 @@ func (g *generator) queryParams(m *descriptor.MethodDescriptorProto) map[string]
 		return queryParams
 	}
 
-	pathParams := g.pathParams(m)
+	pathParams := g.bindingPathParams(m, info)
 	// Minor hack: we want to make sure that the body parameter is NOT a query parameter.
 	pathParams[info.body] = &descriptor.FieldDescriptorProto{}
 
@@ -239,8 +473,11 @@ func (g *generator) queryParams(m *descriptor.MethodDescriptorProto) map[string]
 	bodyField := g.lookupField(m.GetInputType(), info.body)
 
 	// Possible query parameters are all leaf fields in the request or body.
+	// getLeafs does not descend into an excluded field, so every leaf under
+	// a message body field is dropped along with it, however deeply nested.
 	pathToLeaf := g.getLeafs(request, bodyField)
-	// Iterate in sorted order to
+	// The result is a map, so callers that emit code from it must sort its
+	// keys to keep regenerations byte-identical.
 	for path, leaf := range pathToLeaf {
 		// If, and only if, a leaf field is not a path parameter or a body parameter,
 		// it is a query parameter.
@@ -298,12 +535,26 @@ func (g *generator) getLeafs(msg *descriptor.DescriptorProto, excludedFields ...
 		if field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED {
 			// Repeated message fields must not be mapped because no
 			// client library can support such complicated mappings.
+			// This applies at any depth, including direct fields of the
+			// request, and covers repeated wrappers and map fields, whose
+			// entries are repeated messages.
 			// https://cloud.google.com/endpoints/docs/grpc-service-config/reference/rpc/google.api#grpc-transcoding
 			return
 		}
 		if contains(excludedFields, field) {
 			return
 		}
+		// Well-known dynamic JSON types, and Any, are free-form and have
+		// no meaningful query param encoding, so they are never leafs.
+		if isDynamicJSONType(field.GetTypeName()) {
+			return
+		}
+		// Wrapper types are encoded as their bare value, and a FieldMask,
+		// e.g. the update_mask of a PATCH, as its paths, so they are leafs.
+		if isWrapperType(field.GetTypeName()) || field.GetTypeName() == fieldMaskType {
+			handleLeaf(field, stack)
+			return
+		}
 		// Short circuit on infinite recursion
 		if contains(stack, field) {
 			return
@@ -330,9 +581,83 @@ func (g *generator) getLeafs(msg *descriptor.DescriptorProto, excludedFields ...
 	return pathsToLeafs
 }
 
-func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto) {
+// isDynamicJSONType reports if the fully qualified type name refers to one of
+// the well-known types used to represent arbitrary JSON: Struct, Value, and
+// ListValue, or to Any, whose JSON embeds an arbitrary message and whose
+// type_url and value fields have no meaning on their own.
+func isDynamicJSONType(typeName string) bool {
+	switch typeName {
+	case structType, valueType, listValueType, anyType:
+		return true
+	}
+	return false
+}
+
+// isWrapperType reports if the fully qualified type name refers to one of
+// the well-known wrapper types, e.g. google.protobuf.Int64Value.
+func isWrapperType(typeName string) bool {
+	switch typeName {
+	case ".google.protobuf.DoubleValue", ".google.protobuf.FloatValue",
+		".google.protobuf.Int64Value", ".google.protobuf.UInt64Value",
+		".google.protobuf.Int32Value", ".google.protobuf.UInt32Value",
+		".google.protobuf.BoolValue", ".google.protobuf.StringValue",
+		".google.protobuf.BytesValue":
+		return true
+	}
+	return false
+}
+
+// queryParamKey returns the query param key of the field at path in the
+// message msgName, which is the JSON name of each field along the path joined
+// by dots, e.g. field_one.field_two becomes fieldOne.fieldTwo, unless a field
+// sets another json_name.
+func (g *generator) queryParamKey(msgName, path string) string {
+	segs := strings.Split(path, ".")
+	keys := make([]string, len(segs))
+	for i, seg := range segs {
+		keys[i] = g.lookupField(msgName, strings.Join(segs[:i+1], ".")).GetJsonName()
+		if keys[i] == "" {
+			// Descriptors built without protoc may lack a json_name.
+			keys[i] = jsonName(seg)
+		}
+	}
+	return strings.Join(keys, ".")
+}
+
+// jsonName returns the default JSON name protoc gives to a field, which drops
+// underscores and capitalizes the letter following each.
+func jsonName(field string) string {
+	var b strings.Builder
+	up := false
+	for _, r := range field {
+		if r == '_' {
+			up = true
+			continue
+		}
+		if up {
+			r = unicode.ToUpper(r)
+			up = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto, errPrefix string) {
+	info := getHTTPInfo(m)
+	if info == nil || len(urlBindings(info)) > 1 {
+		// generateURLString builds the query string of each binding it
+		// selects from along with its path.
+		return
+	}
+	g.bindingQueryString(m, info, errPrefix)
+}
+
+// bindingQueryString emits the query string of m when it is sent with the
+// binding info.
+func (g *generator) bindingQueryString(m *descriptor.MethodDescriptorProto, info *httpInfo, errPrefix string) {
 	p := g.printf
-	queryParams := g.queryParams(m)
+	queryParams := g.bindingQueryParams(m, info)
 	if len(queryParams) == 0 {
 		return
 	}
@@ -353,12 +678,40 @@ func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto) {
 		singularPrimitive := field.GetType() != fieldTypeMessage &&
 			field.GetType() != fieldTypeBytes &&
 			field.GetLabel() != fieldLabelRepeated
-		paramAdd := fmt.Sprintf("params.Add(%q, fmt.Sprintf(%q, req%s))", lowerFirst(snakeToCamel(path)), "%v", accessor)
+		value := g.enumValue(field, "req"+accessor)
+		if isWrapperType(field.GetTypeName()) {
+			// Query params carry the bare wrapped value, e.g. a number
+			// rather than the quoted string protojson uses for 64-bit ints.
+			value += ".GetValue()"
+		}
+		key := g.queryParamKey(m.GetInputType(), path)
+		if g.opts.protoNames {
+			// Match the field names of the body under UseProtoNames.
+			key = path
+		}
+		// Values, including the names of enums, are added unescaped, since
+		// params.Encode escapes them and escaping here would encode them twice.
+		paramAdd := func() {
+			// Use string format specifier here in order to allow %v to be a raw string.
+			p("params.Add(%q, fmt.Sprintf(%q, %s))", key, "%v", value)
+		}
+		if field.GetTypeName() == fieldMaskType {
+			// A FieldMask is sent as its JSON encoding, a single
+			// comma-separated list of its paths in lowerCamelCase, without
+			// the quotes of the JSON string.
+			paramAdd = func() {
+				p("field, err := protojson.Marshal(%s)", value)
+				p("if err != nil {")
+				p("  return %serr", errPrefix)
+				p("}")
+				p("params.Add(%q, string(field[1:len(field)-1]))", key)
+			}
+			g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/encoding/protojson"}] = true
+		}
 
 		// Only required, singular, primitive field types should be added regardless.
 		if required && singularPrimitive {
-			// Use string format specifier here in order to allow %v to be a raw string.
-			p("%s", paramAdd)
+			paramAdd()
 			continue
 		}
 
@@ -366,6 +719,8 @@ func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto) {
 			// It's a slice, so check for nil
 			p("if req%s != nil {", accessor)
 		} else if field.GetProto3Optional() {
+			// Check presence rather than the value, so that a field
+			// explicitly set to its zero value is still sent.
 			// Split right before the raw access
 			toks := strings.Split(path, ".")
 			toks = toks[:len(toks)-1]
@@ -386,7 +741,7 @@ func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto) {
 				p(`if req%s != 0 {`, accessor)
 			}
 		}
-		p("    %s", paramAdd)
+		paramAdd()
 		p("}")
 	}
 	p("")
@@ -394,156 +749,1513 @@ func (g *generator) generateQueryString(m *descriptor.MethodDescriptorProto) {
 	p("")
 }
 
-func (g *generator) generateURLString(m *descriptor.MethodDescriptorProto) error {
+// generateURLString emits the construction of baseUrl, the request URL without
+// its query string. errPrefix holds any other values the enclosing function
+// returns before the error.
+func (g *generator) generateURLString(m *descriptor.MethodDescriptorProto, errPrefix string) error {
 	info := getHTTPInfo(m)
 	if info == nil {
 		return errors.E(nil, "method has no http info: %s", m.GetName())
 	}
 
+	// A path field missing from the request, e.g. in a template bound to a
+	// request without fields, would emit a getter that does not exist.
+	for _, b := range append([]*httpInfo{info}, info.bindings...) {
+		for _, path := range urlParamRegexp.FindAllStringSubmatch(b.url, -1) {
+			if g.lookupField(m.GetInputType(), path[1]) == nil {
+				return errors.E(nil, "path template %q of method %s binds %q, which is not a field of %s", b.url, m.GetName(), path[1], m.GetInputType())
+			}
+		}
+	}
+
 	p := g.printf
 
-	fmtStr := info.url
-	// TODO(dovs): handle more complex path urls involving = and *,
-	// e.g. v1beta1/repeat/{info.f_string=first/*}/{info.f_child.f_string=second/**}:pathtrailingresource
-	re := regexp.MustCompile(`{([a-zA-Z0-9_.]+?)(=[^{}]+)?}`)
-	fmtStr = re.ReplaceAllStringFunc(fmtStr, func(s string) string { return "%v" })
+	if g.opts.callEndpoint {
+		p("baseUrl, err := url.Parse(callEndpoint(opts, c.endpoint))")
+	} else {
+		p("baseUrl, err := url.Parse(c.endpoint)")
+	}
+	p("if err != nil {")
+	p("  return %serr", errPrefix)
+	p("}")
 
-	// TODO(dovs): handle error
-	p("baseUrl, _ := url.Parse(c.endpoint)")
+	bindings := urlBindings(info)
+	if len(bindings) == 1 {
+		g.urlPath(m, info.url)
+		p("")
+		return nil
+	}
 
-	tokens := []string{fmt.Sprintf(`"%s"`, fmtStr)}
-	// Can't just reuse pathParams because the order matters
-	for _, path := range re.FindAllStringSubmatch(info.url, -1) {
-		// In the returned slice, the zeroth element is the full regex match,
-		// and the subsequent elements are the sub group matches.
-		// See the docs for FindStringSubmatch for further details.
-		tokens = append(tokens, fmt.Sprintf("req%s", fieldGetter(path[1])))
+	// The first binding, in declared order, whose path fields are all
+	// populated is used, falling back to the primary binding. The fields
+	// left out of its path are those sent in the query string, and the
+	// required ones among them are checked for that binding alone.
+	selected := func(b *httpInfo) {
+		g.bindingRequiredChecks(m, b, errPrefix)
+		g.urlPath(m, b.url)
+		g.bindingQueryString(m, b, errPrefix)
+	}
+	p("switch {")
+	for _, b := range bindings {
+		var conds []string
+		for _, path := range urlParamRegexp.FindAllStringSubmatch(b.url, -1) {
+			conds = append(conds, g.populatedCheck(m, path[1]))
+		}
+		if len(conds) == 0 {
+			// A binding without path fields always applies.
+			p("default:")
+			selected(b)
+			p("}")
+			p("")
+			return nil
+		}
+		p("case %s:", strings.Join(conds, " && "))
+		selected(b)
 	}
-	p("baseUrl.Path += fmt.Sprintf(%s)", strings.Join(tokens, ", "))
+	p("default:")
+	selected(info)
+	p("}")
 	p("")
 	return nil
 }
 
-func getHTTPInfo(m *descriptor.MethodDescriptorProto) *httpInfo {
-	if m == nil || m.GetOptions() == nil {
-		return nil
+// urlBindings returns the bindings generateURLString selects from: info, then
+// those of its additional bindings that differ from it only in their URL.
+// Others, with another verb or body, would need a different request.
+func urlBindings(info *httpInfo) []*httpInfo {
+	bindings := []*httpInfo{info}
+	for _, b := range info.bindings {
+		if b.verb == info.verb && b.body == info.body {
+			bindings = append(bindings, b)
+		}
 	}
+	return bindings
+}
 
-	eHTTP := proto.GetExtension(m.GetOptions(), annotations.E_Http)
-
-	httpRule := eHTTP.(*annotations.HttpRule)
-	info := httpInfo{body: httpRule.GetBody()}
-
-	switch httpRule.GetPattern().(type) {
-	case *annotations.HttpRule_Get:
-		info.verb = "get"
-		info.url = httpRule.GetGet()
-	case *annotations.HttpRule_Post:
-		info.verb = "post"
-		info.url = httpRule.GetPost()
-	case *annotations.HttpRule_Patch:
-		info.verb = "patch"
-		info.url = httpRule.GetPatch()
-	case *annotations.HttpRule_Put:
-		info.verb = "put"
-		info.url = httpRule.GetPut()
-	case *annotations.HttpRule_Delete:
-		info.verb = "delete"
-		info.url = httpRule.GetDelete()
+// TODO(dovs): handle more complex path urls involving = and *,
+// e.g. v1beta1/repeat/{info.f_string=first/*}/{info.f_child.f_string=second/**}:pathtrailingresource
+var urlParamRegexp = regexp.MustCompile(`{([a-zA-Z0-9_.]+?)(=[^{}]+)?}`)
+
+// urlPath emits the addition of the path of the URL template tmpl, filled in
+// with the path fields of req, to baseUrl. A single-segment variable, e.g.
+// {foo} or its equivalent {foo=*}, is one segment whatever its value, so a
+// "/" in a string bound to it is escaped in RawPath, which URL.String then
+// prefers to the escaping of Path. So is a literal of tmpl that is already
+// percent-encoded, e.g. "a%2Fb", which must be sent verbatim.
+func (g *generator) urlPath(m *descriptor.MethodDescriptorProto, tmpl string) {
+	if raw := g.urlRawPathExpr(m, tmpl); raw != "" {
+		g.printf("baseUrl.RawPath = baseUrl.EscapedPath() + %s", raw)
+		g.imports[pbinfo.ImportSpec{Path: "net/url"}] = true
 	}
+	g.printf("baseUrl.Path += %s", g.urlPathExpr(m, tmpl))
+}
 
-	return &info
+// urlRawPathExpr returns the expression formatting the escaped path of the
+// URL template tmpl, or "" if tmpl binds no string to a single-segment
+// variable and has no percent-encoded literal, in which case the escaping of
+// Path is enough. Literals are kept verbatim, and the strings bound
+// to multi-segment variables are escaped but for their slashes.
+func (g *generator) urlRawPathExpr(m *descriptor.MethodDescriptorProto, tmpl string) string {
+	tokens := []string{fmt.Sprintf(`"%s"`, urlFormat(tmpl, true))}
+	// A literal that is percent-encoded differs from its unescaped form in Path.
+	rawNeeded := urlFormat(tmpl, true) != urlFormat(tmpl, false)
+	var multi bool
+	for _, path := range urlParamRegexp.FindAllStringSubmatch(tmpl, -1) {
+		field := g.lookupField(m.GetInputType(), path[1])
+		value := g.enumValue(field, "req"+fieldGetter(path[1]))
+		if field.GetType() == fieldTypeString {
+			if path[2] == "" || path[2] == "=*" {
+				value = fmt.Sprintf("url.PathEscape(%s)", value)
+				rawNeeded = true
+			} else {
+				value = fmt.Sprintf(`strings.ReplaceAll(url.PathEscape(%s), "%%2F", "/")`, value)
+				multi = true
+			}
+		}
+		tokens = append(tokens, value)
+	}
+	if !rawNeeded {
+		return ""
+	}
+	if multi {
+		g.imports[pbinfo.ImportSpec{Path: "strings"}] = true
+	}
+	return fmt.Sprintf("fmt.Sprintf(%s)", strings.Join(tokens, ", "))
 }
 
-// genRESTMethod generates a single method from a client. m must be a method declared in serv.
-// If the generated method requires an auxiliary type, it is added to aux.
-func (g *generator) genRESTMethod(servName string, serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
-	if g.isLRO(m) {
-		g.aux.lros[m] = true
-		return g.lroRESTCall(servName, m)
+// urlPathExpr returns the expression formatting the path of the URL template
+// tmpl with the path fields of req.
+func (g *generator) urlPathExpr(m *descriptor.MethodDescriptorProto, tmpl string) string {
+	tokens := []string{fmt.Sprintf(`"%s"`, urlFormat(tmpl, false))}
+	// Can't just reuse pathParams because the order matters
+	for _, path := range urlParamRegexp.FindAllStringSubmatch(tmpl, -1) {
+		// In the returned slice, the zeroth element is the full regex match,
+		// and the subsequent elements are the sub group matches.
+		// See the docs for FindStringSubmatch for further details.
+		tokens = append(tokens, g.enumValue(g.lookupField(m.GetInputType(), path[1]), "req"+fieldGetter(path[1])))
 	}
+	return fmt.Sprintf("fmt.Sprintf(%s)", strings.Join(tokens, ", "))
+}
 
-	if m.GetOutputType() == emptyType {
-		return g.emptyUnaryRESTCall(servName, m)
+// urlFormat returns the format string of the URL template tmpl, with a %v for
+// each variable. Its literals are kept verbatim if raw, as in RawPath, or else
+// unescaped, as in Path, and in either case have their "%" doubled for fmt.
+func urlFormat(tmpl string, raw bool) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range urlParamRegexp.FindAllStringIndex(tmpl, -1) {
+		b.WriteString(urlLiteral(tmpl[last:loc[0]], raw))
+		b.WriteString("%v")
+		last = loc[1]
 	}
+	b.WriteString(urlLiteral(tmpl[last:], raw))
+	return b.String()
+}
 
-	if pf, ps, err := g.getPagingFields(m); err != nil {
-		return err
-	} else if pf != nil {
-		iter, err := g.iterTypeOf(pf)
-		if err != nil {
-			return err
+func urlLiteral(lit string, raw bool) string {
+	if !raw {
+		// A literal that is not a valid escaping is taken as it is.
+		if u, err := url.PathUnescape(lit); err == nil {
+			lit = u
 		}
+	}
+	return strings.ReplaceAll(lit, "%", "%%")
+}
 
-		return g.pagingRESTCall(servName, m, pf, ps, iter)
+// enumValue returns the expression of the value of field, read by accessor,
+// sent in the URL. Enums are sent by name, which is how fmt formats them,
+// unless the rest-numeric-enums option is enabled, in which case their number
+// is sent, as in the body.
+func (g *generator) enumValue(field *descriptor.FieldDescriptorProto, accessor string) string {
+	if g.opts.numericEnums && field.GetType() == descriptor.FieldDescriptorProto_TYPE_ENUM && field.GetLabel() != fieldLabelRepeated {
+		return fmt.Sprintf("int32(%s)", accessor)
 	}
+	return accessor
+}
 
-	switch {
-	case m.GetClientStreaming():
-		return g.noRequestStreamRESTCall(servName, serv, m)
-	case m.GetServerStreaming():
-		return g.serverStreamRESTCall(servName, serv, m)
+// populatedCheck returns the condition under which the field at path of the
+// request of m is set to a non-zero value.
+func (g *generator) populatedCheck(m *descriptor.MethodDescriptorProto, path string) string {
+	accessor := "req" + fieldGetter(path)
+	switch g.lookupField(m.GetInputType(), path).GetType() {
+	case fieldTypeString:
+		return accessor + ` != ""`
+	case fieldTypeBool:
+		return accessor
+	case fieldTypeMessage, fieldTypeBytes:
+		return accessor + " != nil"
 	default:
-		return g.unaryRESTCall(servName, m)
+		return accessor + " != 0"
 	}
 }
 
-func (g *generator) serverStreamRESTCall(servName string, s *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
-	// Streaming calls are not currently supported for REST clients,
-	// but the interface signature must be preserved.
-	// Unimplemented REST methods will always error.
+// restHTTPHeaders reports whether REST clients build their headers with
+// net/http types instead of gRPC metadata. This is only possible when no gRPC
+// client is generated alongside them, as both share the package helpers.
+func (g *generator) restHTTPHeaders() bool {
+	return g.opts.omitMetadata && !containsTransport(g.opts.transports, grpc)
+}
 
-	inType := g.descInfo.Type[m.GetInputType()]
+// restReadBody returns the expression reading the body of httpRsp whole. The
+// request is created with NewRequestWithContext, so net/http aborts the read
+// once ctx is done.
+func (g *generator) restReadBody() string {
+	return "ioutil.ReadAll(httpRsp.Body)"
+}
 
-	inSpec, err := g.descInfo.ImportSpec(inType)
-	if err != nil {
-		return err
+// restFeatureTokens returns the quoted key-value pairs advertising, in the
+// api-client header, the generator options that change what REST requests
+// look like on the wire, so that the backend can tell such clients apart.
+func (g *generator) restFeatureTokens() []string {
+	var kv []string
+	if g.opts.numericEnums {
+		kv = append(kv, `"numeric-enums"`, `"1"`)
 	}
-	g.imports[inSpec] = true
+	if g.opts.protoNames {
+		kv = append(kv, `"proto-names"`, `"1"`)
+	}
+	return kv
+}
 
-	servSpec, err := g.descInfo.ImportSpec(s)
-	if err != nil {
-		return err
+// restAPIClientHeader returns the name of the header identifying the client
+// library on REST requests, x-goog-api-client unless the rest-header-prefix
+// option replaces its x-goog- prefix, e.g. for a private gateway.
+func (g *generator) restAPIClientHeader() string {
+	prefix := "x-goog-"
+	if g.opts.headerPrefix != "" {
+		prefix = g.opts.headerPrefix
 	}
-	g.imports[servSpec] = true
+	return strings.ToLower(prefix) + "api-client"
+}
+
+// restHeaders returns the expression that builds the HTTP headers for a
+// REST call from the client and context metadata.
+func (g *generator) restHeaders() string {
+	if g.restHTTPHeaders() {
+		return `buildHeaders(ctx, c.xGoogMetadata, http.Header{"Content-Type": []string{"application/json"}})`
+	}
+	return `buildHeaders(ctx, c.xGoogMetadata, metadata.Pairs("Content-Type", "application/json"))`
+}
 
+// restTraceSpan emits the start of a trace span named after m's fully-qualified
+// RPC name, when the rest-tracing option is enabled. gRPC clients get such
+// spans from the transport's stats handler, but REST calls have no equivalent.
+func (g *generator) restTraceSpan(m *descriptor.MethodDescriptorProto) {
+	if !g.opts.restTracing {
+		return
+	}
 	p := g.printf
-	lowcaseServName := lowcaseRestClientName(servName)
-	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) (%s.%s_%sClient, error) {",
-		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName(), servSpec.Name, s.GetName(), m.GetName())
-	p(`  return nil, fmt.Errorf("%s not yet supported for REST clients")`, m.GetName())
-	p("}")
-	p("")
 
-	return nil
+	p("ctx, span := trace.StartSpan(ctx, %q)", g.restMethodName(m))
+	p("defer span.End()")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "go.opencensus.io/trace"}] = true
 }
 
-func (g *generator) noRequestStreamRESTCall(servName string, s *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
-	// Streaming calls are not currently supported for REST clients,
-	// but the interface signature must be preserved.
-	// Unimplemented REST methods will always error.
-
+// restStreamTraceSpan generates the span of a server-streaming call. Unlike
+// restTraceSpan, the span outlives the method when the stream is created,
+// and is ended by the stream client instead.
+func (g *generator) restStreamTraceSpan(m *descriptor.MethodDescriptorProto) {
+	if !g.opts.restTracing {
+		return
+	}
 	p := g.printf
 
-	servSpec, err := g.descInfo.ImportSpec(s)
-	if err != nil {
-		return err
-	}
-	g.imports[servSpec] = true
+	p("ctx, span := trace.StartSpan(ctx, %q)", g.restMethodName(m))
+	p("endSpan := true")
+	p("defer func() {")
+	p("  if endSpan {")
+	p("    span.End()")
+	p("  }")
+	p("}()")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "go.opencensus.io/trace"}] = true
+}
 
-	lowcaseServName := lowcaseRestClientName(servName)
+// restMethodName returns the fully qualified name of m, e.g.
+// google.example.v1.FooService/GetBar, used to label its spans and metrics.
+func (g *generator) restMethodName(m *descriptor.MethodDescriptorProto) string {
+	if serv, ok := g.descInfo.ParentElement[m].(*descriptor.ServiceDescriptorProto); ok {
+		return fmt.Sprintf("%s.%s/%s", g.descInfo.ParentFile[serv].GetPackage(), serv.GetName(), m.GetName())
+	}
+	return m.GetName()
+}
 
-	p("func (c *%s) %s(ctx context.Context, opts ...gax.CallOption) (%s.%s_%sClient, error) {",
-		lowcaseServName, m.GetName(), servSpec.Name, s.GetName(), m.GetName())
-	p(`  return nil, fmt.Errorf("%s not yet supported for REST clients")`, m.GetName())
+// restNoContent generates the short-circuit of a 204 No Content response,
+// which leaves the response message zero-valued rather than unmarshaling an
+// empty body. An empty 200 is handled after the body is read.
+func (g *generator) restNoContent() {
+	p := g.printf
+	p("if httpRsp.StatusCode == http.StatusNoContent {")
+	p("  return nil")
 	p("}")
 	p("")
-
-	return nil
 }
 
-func (g *generator) pagingRESTCall(servName string, m *descriptor.MethodDescriptorProto, elemField, pageSize *descriptor.FieldDescriptorProto, pt *iterType) error {
-	lowcaseServName := lowcaseRestClientName(servName)
+// restETagField returns the singular string etag field of the message named
+// typeName, when the rest-etag option is enabled, or nil otherwise. A member of
+// a real oneof is not returned, as it cannot be set directly.
+func (g *generator) restETagField(typeName string) *descriptor.FieldDescriptorProto {
+	if !g.opts.etagHeaders {
+		return nil
+	}
+	f := g.lookupField(typeName, "etag")
+	if f.GetType() != fieldTypeString || f.GetLabel() == fieldLabelRepeated {
+		return nil
+	}
+	if f.OneofIndex != nil && !f.GetProto3Optional() {
+		return nil
+	}
+	return f
+}
+
+// restIfMatch emits the setting of the If-Match header from the etag field of
+// the request, when set, making any request but a GET conditional on the
+// version of the resource.
+func (g *generator) restIfMatch(m *descriptor.MethodDescriptorProto, info *httpInfo) {
+	if info.verb == "get" || g.restETagField(m.GetInputType()) == nil {
+		return
+	}
+	p := g.printf
+	p(`if etag := req.GetEtag(); etag != "" {`)
+	p(`  headers.Set("If-Match", quoteETag(etag))`)
+	p("}")
+}
+
+// restAttemptTimeout emits, at the start of the closure given to gax.Invoke,
+// the derivation of the context of the attempt bounded by the duration of any
+// WithAttemptTimeout call option. The deadline of the ctx given by Invoke is
+// that of the whole call.
+func (g *generator) restAttemptTimeout() {
+	p := g.printf
+	p("if d := attemptTimeout(opts); d > 0 {")
+	p("  var cancel context.CancelFunc")
+	p("  ctx, cancel = context.WithTimeout(ctx, d)")
+	p("  defer cancel()")
+	p("}")
+}
+
+// restInterceptRequest emits the call of the RequestInterceptor of the client
+// with httpReq, once its headers are set.
+func (g *generator) restInterceptRequest() {
+	p := g.printf
+	p("if err := c.interceptRequest(httpReq); err != nil {")
+	p("  return err")
+	p("}")
+}
+
+// restInterceptResponse emits the call of the ResponseInterceptor of the
+// client with httpRsp, before its status is checked. The body is closed if
+// the interceptor fails, as the call then never reaches the code reading it.
+func (g *generator) restInterceptResponse() {
+	p := g.printf
+	p("if err := c.interceptResponse(httpRsp); err != nil {")
+	p("  httpRsp.Body.Close()")
+	p("  return err")
+	p("}")
+}
+
+// restDo emits the sending of httpReq. When the rest-metrics option is
+// enabled, the request count, latency and errors of each attempt are recorded.
+func (g *generator) restDo(m *descriptor.MethodDescriptorProto) {
+	p := g.printf
+
+	if !g.opts.restMetrics {
+		p("httpRsp, err := c.httpClient.Do(httpReq)")
+		return
+	}
+	p("start := time.Now()")
+	p("httpRsp, err := c.httpClient.Do(httpReq)")
+	p("recordRESTCall(ctx, %q, start, httpRsp, err)", g.restMethodName(m))
+	g.imports[pbinfo.ImportSpec{Path: "time"}] = true
+}
+
+// restTimeout returns the default timeout of m in milliseconds: the timeout
+// of the gRPC service config, as for gRPC methods, or else that of the
+// rest-default-timeout option. ok is false if m has neither.
+func (g *generator) restTimeout(m *descriptor.MethodDescriptorProto) (t int64, ok bool) {
+	if serv, found := g.descInfo.ParentElement[m]; found {
+		t, ok = g.grpcConf.Timeout(g.fqn(serv), m.GetName())
+	}
+	if !ok && g.opts.defaultTimeout > 0 {
+		t, ok = g.opts.defaultTimeout.Milliseconds(), true
+	}
+	return t, ok
+}
+
+// restPageDeadline emits, inside a paging InternalFetch, a per-page timeout
+// derived from the method's default timeout. A deadline on the caller's
+// context bounds the whole iteration and is left to do so; otherwise each
+// page gets a fresh sub-context, so that one slow page does not eat into the
+// time available to the next. The captured ctx is shadowed rather than
+// reassigned so that the timeout does not leak into later fetches. Like the
+// deadlines of gRPC methods, it is skipped if default deadlines are disabled.
+func (g *generator) restPageDeadline(m *descriptor.MethodDescriptorProto) {
+	t, ok := g.restTimeout(m)
+	if !ok {
+		return
+	}
+	p := g.printf
+
+	p("  ctx := ctx")
+	p("  if _, ok := ctx.Deadline(); !ok && !c.disableDeadlines {")
+	p("    cctx, cancel := context.WithTimeout(ctx, %d * time.Millisecond)", t)
+	p("    defer cancel()")
+	p("    ctx = cctx")
+	p("  }")
+	g.imports[pbinfo.ImportSpec{Path: "time"}] = true
+}
+
+// restRetryableCodes are the HTTP status codes on which idempotent REST
+// methods are retried by the rest-retry-idempotent option.
+var restRetryableCodes = []string{
+	"http.StatusTooManyRequests",
+	"http.StatusInternalServerError",
+	"http.StatusBadGateway",
+	"http.StatusServiceUnavailable",
+	"http.StatusGatewayTimeout",
+}
+
+// restCallOptions emits the default CallOptions of the REST client under the
+// rest-retry-idempotent option. Methods bound to GET, PUT or DELETE are
+// idempotent and so retried on restRetryableCodes and on transient network
+// failures; the others, like POST and PATCH, are never retried, as a failed
+// attempt may already have applied.
+func (g *generator) restCallOptions(serv *descriptor.ServiceDescriptorProto, servName string) {
+	p := g.printf
+
+	p("func default%[1]sRESTCallOptions() *%[1]sCallOptions {", servName)
+	p("  return &%sCallOptions{", servName)
+	for _, m := range append(serv.GetMethod(), g.getMixinMethods()...) {
+		p("%s: []gax.CallOption{", m.GetName())
+		if info := getHTTPInfo(m); info != nil && (info.verb == "get" || info.verb == "put" || info.verb == "delete") {
+			p("gax.WithRetry(func() gax.Retryer {")
+			p("  return onHTTPCodesOrTransient(gax.Backoff{")
+			p("    Initial:    100 * time.Millisecond,")
+			p("    Max:        60000 * time.Millisecond,")
+			p("    Multiplier: 1.30,")
+			p("  },")
+			for _, c := range restRetryableCodes {
+				p("  %s,", c)
+			}
+			p("  )")
+			p("}),")
+			g.imports[pbinfo.ImportSpec{Path: "time"}] = true
+			g.imports[pbinfo.ImportSpec{Path: "net/http"}] = true
+		}
+		p("},")
+	}
+	p("  }")
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Name: "gax", Path: "github.com/googleapis/gax-go/v2"}] = true
+}
+
+// restDefaultTimeout emits, at the start of a unary REST method, the bound of
+// the call by its default timeout, as deadline does for gRPC methods. Only a
+// context without a deadline is bounded, so a deadline of the caller always
+// wins.
+func (g *generator) restDefaultTimeout(m *descriptor.MethodDescriptorProto) {
+	t, ok := g.restTimeout(m)
+	if !ok {
+		return
+	}
+	p := g.printf
+
+	p("if _, ok := ctx.Deadline(); !ok && !c.disableDeadlines {")
+	p("  cctx, cancel := context.WithTimeout(ctx, %d * time.Millisecond)", t)
+	p("  defer cancel()")
+	p("  ctx = cctx")
+	p("}")
+	g.imports[pbinfo.ImportSpec{Path: "time"}] = true
+}
+
+// invalidRESTMethod reports a method whose google.api.http annotation binds a
+// body to a GET or DELETE, which cannot be transcoded, and finishes its REST
+// implementation with a body that always returns an error. The method set of
+// the client is kept intact, so the rest of the generation can go on.
+func (g *generator) invalidRESTMethod(m *descriptor.MethodDescriptorProto, info *httpInfo, errPrefix string, specs ...pbinfo.ImportSpec) {
+	name := m.GetName()
+	if serv, ok := g.descInfo.ParentElement[m]; ok {
+		name = fmt.Sprintf("%s.%s", g.fqn(serv), m.GetName())
+	}
+	msg := fmt.Sprintf("invalid use of body parameter for a %s method %s", info.verb, name)
+	// protoc shows the output of the plugin on stderr, so the diagnostic
+	// names the rule and how to fix it rather than just the method.
+	verb := strings.ToUpper(info.verb)
+	log.Printf("warning: skipping REST implementation: %s: google.api.http rule {%s: %q, body: %q} binds a body to %s, which cannot carry one; use POST, PUT or PATCH, or remove the body",
+		msg, info.verb, info.url, info.body, verb)
+
+	p := g.printf
+	p("  return %serrors.New(%q)", errPrefix, msg+"; it is not supported by REST clients")
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+	for _, spec := range specs {
+		g.imports[spec] = true
+	}
+}
+
+// restFieldsParam emits the addition of the fields system parameter requested
+// with the WithFields call option, when the rest-partial-response option is
+// enabled. Partial responses need no other handling, since responses are
+// unmarshaled with AllowPartial.
+func (g *generator) restFieldsParam() {
+	if !g.opts.partialResponse {
+		return
+	}
+	p := g.printf
+
+	p("if fields := callFields(opts); fields != \"\" {")
+	p("  q := baseUrl.Query()")
+	p(`  q.Set("fields", fields)`)
+	p("  baseUrl.RawQuery = q.Encode()")
+	p("}")
+	p("")
+}
+
+// restMarshalOptions returns the protojson.MarshalOptions literal with the
+// given fields that marshalOpts, shared by the REST request bodies of the
+// package, is set to. Zero values are included when the rest-emit-unpopulated
+// option is enabled, proto field names are used when the rest-proto-names
+// option is, and enum numbers when the rest-numeric-enums option is.
+func (g *generator) restMarshalOptions(fields string) string {
+	if g.opts.protoNames {
+		fields += ", UseProtoNames: true"
+	}
+	if g.opts.emitUnpopulated {
+		fields += ", EmitUnpopulated: true"
+	}
+	if g.opts.numericEnums {
+		fields += ", UseEnumNumbers: true"
+	}
+	return fmt.Sprintf("protojson.MarshalOptions{%s}", fields)
+}
+
+// restLogRequest emits a call logging the outgoing request, when the
+// rest-debug-logging option is enabled.
+func (g *generator) restLogRequest() {
+	if !g.opts.debugLogging {
+		return
+	}
+	g.printf("logRequest(httpReq)")
+}
+
+// restAutoUpdateMask emits code that fills in an unset update mask from the
+// populated top-level fields of the resource sent as the body, when the
+// rest-auto-update-mask option is enabled. Only PATCH methods whose body
+// names a message field and whose request has a google.protobuf.FieldMask
+// field are eligible. A mask set by the caller is always left untouched.
+func (g *generator) restAutoUpdateMask(m *descriptor.MethodDescriptorProto) error {
+	if !g.opts.autoUpdateMask {
+		return nil
+	}
+	info := getHTTPInfo(m)
+	if info == nil || info.verb != "patch" || info.body == "" || info.body == "*" {
+		return nil
+	}
+	body := g.lookupField(m.GetInputType(), info.body)
+	if body.GetType() != fieldTypeMessage || body.GetLabel() == fieldLabelRepeated {
+		return nil
+	}
+
+	inType, ok := g.descInfo.Type[m.GetInputType()].(*descriptor.DescriptorProto)
+	if !ok {
+		return nil
+	}
+	var mask *descriptor.FieldDescriptorProto
+	for _, f := range inType.GetField() {
+		if f.GetTypeName() == fieldMaskType && f.GetLabel() != fieldLabelRepeated {
+			mask = f
+			break
+		}
+	}
+	if mask == nil {
+		return nil
+	}
+
+	inSpec, err := g.descInfo.ImportSpec(inType)
+	if err != nil {
+		return err
+	}
+	maskType := g.descInfo.Type[fieldMaskType]
+	maskName, maskSpec, err := g.descInfo.NameSpec(maskType)
+	if err != nil {
+		return err
+	}
+
+	p := g.printf
+	p("if req%s == nil {", fieldGetter(mask.GetName()))
+	p("  // Clone so that setting the mask does not modify the caller's request.")
+	p("  req = proto.Clone(req).(*%s.%s)", inSpec.Name, inType.GetName())
+	p("  var paths []string")
+	p("  req%s.ProtoReflect().Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {", fieldGetter(info.body))
+	p("    paths = append(paths, string(fd.Name()))")
+	p("    return true")
+	p("  })")
+	p("  req.%s = &%s.%s{Paths: paths}", snakeToCamel(mask.GetName()), maskSpec.Name, maskName)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/proto"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/reflect/protoreflect"}] = true
+	g.imports[maskSpec] = true
+	return nil
+}
+
+// The google.api.field_info extension of FieldOptions, and the value of its
+// format field marking a UUID4 idempotency token (AIP-155). The extension is
+// newer than the genproto this module depends on, so it is read from the
+// unknown fields of the options.
+const (
+	fieldInfoExtension   = 291403980
+	fieldInfoFormat      = 1
+	fieldInfoFormatUUID4 = 1
+)
+
+// isUUID4Field reports whether f is annotated with a google.api.field_info
+// format of UUID4.
+func isUUID4Field(f *descriptor.FieldDescriptorProto) bool {
+	if f.GetOptions() == nil {
+		return false
+	}
+	var uuid4 bool
+	forEachWireField(f.GetOptions().ProtoReflect().GetUnknown(), func(num protowire.Number, typ protowire.Type, v []byte) {
+		if num != fieldInfoExtension || typ != protowire.BytesType {
+			return
+		}
+		info, _ := protowire.ConsumeBytes(v)
+		forEachWireField(info, func(num protowire.Number, typ protowire.Type, v []byte) {
+			if num == fieldInfoFormat && typ == protowire.VarintType {
+				format, _ := protowire.ConsumeVarint(v)
+				uuid4 = format == fieldInfoFormatUUID4
+			}
+		})
+	})
+	return uuid4
+}
+
+// forEachWireField calls fn with the number, type and encoded value of each
+// field of the wire-encoded message b, stopping at the first malformed one.
+func forEachWireField(b []byte, fn func(protowire.Number, protowire.Type, []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return
+		}
+		fn(num, typ, b[:n])
+		b = b[n:]
+	}
+}
+
+// requestIDField returns the idempotency token field of msg, a singular
+// string field annotated as a UUID4 by google.api.field_info, or nil if there
+// is none. Members of a real oneof are skipped, as they cannot be set without
+// the wrapper type of their case.
+func requestIDField(msg *descriptor.DescriptorProto) *descriptor.FieldDescriptorProto {
+	for _, f := range msg.GetField() {
+		if f.GetType() != fieldTypeString || f.GetLabel() == fieldLabelRepeated {
+			continue
+		}
+		if f.OneofIndex != nil && !f.GetProto3Optional() {
+			continue
+		}
+		if isUUID4Field(f) {
+			return f
+		}
+	}
+	return nil
+}
+
+// restRequestID emits the population of an unset idempotency token of the
+// request with a random UUID, when the rest-request-id option is enabled and m
+// is not a GET. It happens once, before the retried closure, so that every
+// attempt sends the same token and the server can deduplicate them.
+func (g *generator) restRequestID(m *descriptor.MethodDescriptorProto, info *httpInfo) error {
+	if !g.opts.requestID || info.verb == "get" {
+		return nil
+	}
+	inType := g.descInfo.Type[m.GetInputType()]
+	msg, ok := inType.(*descriptor.DescriptorProto)
+	if !ok {
+		return nil
+	}
+	f := requestIDField(msg)
+	if f == nil {
+		return nil
+	}
+	inSpec, err := g.descInfo.ImportSpec(inType)
+	if err != nil {
+		return err
+	}
+
+	token := "uuid.New().String()"
+	if f.GetProto3Optional() {
+		token = fmt.Sprintf("proto.String(%s)", token)
+	}
+	p := g.printf
+	p(`if req%s == "" {`, fieldGetter(f.GetName()))
+	p("  // Clone so that setting the token does not modify the caller's request.")
+	p("  req = proto.Clone(req).(*%s.%s)", inSpec.Name, inType.GetName())
+	p("  req.%s = %s", snakeToCamel(f.GetName()), token)
+	p("}")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/proto"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "github.com/google/uuid"}] = true
+	return nil
+}
+
+// restRequiredChecks emits client-side checks that the REQUIRED path and query
+// parameters of m are set, when the rest-validate-required option is enabled.
+// errPrefix holds any other values the enclosing function returns before the
+// error. Singular numeric, bool and enum fields cannot be distinguished from
+// their zero value and are not checked.
+func (g *generator) restRequiredChecks(m *descriptor.MethodDescriptorProto, errPrefix string) {
+	info := getHTTPInfo(m)
+	if info == nil || len(urlBindings(info)) > 1 {
+		// generateURLString checks the params of the binding it selects.
+		return
+	}
+	g.bindingRequiredChecks(m, info, errPrefix)
+}
+
+// bindingRequiredChecks emits the checks of restRequiredChecks for the path
+// and query params of m when it is sent with the binding info.
+func (g *generator) bindingRequiredChecks(m *descriptor.MethodDescriptorProto, info *httpInfo, errPrefix string) {
+	if !g.opts.validateRequired {
+		return
+	}
+	p := g.printf
+
+	params := g.bindingQueryParams(m, info)
+	pathParams := g.bindingPathParams(m, info)
+	for path, field := range pathParams {
+		params[path] = field
+	}
+	paths := make([]string, 0, len(params))
+	for path, field := range params {
+		if isRequired(field) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var checked bool
+	for _, path := range paths {
+		field := params[path]
+		accessor := fieldGetter(path)
+		switch {
+		case field.GetLabel() == fieldLabelRepeated:
+			p("if len(req%s) == 0 {", accessor)
+		case field.GetProto3Optional():
+			p("if req%s == nil {", directAccess(path))
+		case field.GetType() == fieldTypeMessage, field.GetType() == fieldTypeBytes:
+			p("if req%s == nil {", accessor)
+		case field.GetType() == fieldTypeString:
+			p(`if req%s == "" {`, accessor)
+		default:
+			continue
+		}
+		msg := fmt.Sprintf("required field %s is not set", path)
+		if _, ok := pathParams[path]; ok {
+			// Otherwise the URL gets an empty segment, and the server
+			// replies with a confusing 404.
+			msg += "; it is part of the request URL path"
+		}
+		p("  return %serrors.New(%q)", errPrefix, msg)
+		p("}")
+		g.imports[pbinfo.ImportSpec{Path: "errors"}] = true
+		checked = true
+	}
+	if checked {
+		p("")
+	}
+}
+
+// restResourceChecks emits client-side checks that the string path params of
+// m annotated with a google.api.resource_reference match one of the patterns
+// of the referenced resource, when the rest-validate-resource-names option is
+// enabled. References by child_type, or to resources that are not defined in
+// the input files, are not checked.
+func (g *generator) restResourceChecks(m *descriptor.MethodDescriptorProto, errPrefix string) {
+	if !g.opts.validateResources {
+		return
+	}
+	p := g.printf
+
+	params := g.pathParams(m)
+	paths := make([]string, 0, len(params))
+	for path := range params {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var checked bool
+	for _, path := range paths {
+		field := params[path]
+		if field.GetType() != fieldTypeString || field.GetOptions() == nil {
+			continue
+		}
+		ref := proto.GetExtension(field.GetOptions(), annotations.E_ResourceReference).(*annotations.ResourceReference)
+		if ref.GetType() == "" || ref.GetType() == "*" {
+			continue
+		}
+		patterns := g.resourcePatterns(ref.GetType())
+		if len(patterns) == 0 {
+			continue
+		}
+
+		accessor := fieldGetter(path)
+		msg := fmt.Sprintf("invalid resource name %%q for field %s, want %s", path, strings.Join(patterns, " or "))
+		p("if !%s.MatchString(req%s) {", g.resourceRegexpVar(ref.GetType(), patterns), accessor)
+		p("  return %sfmt.Errorf(%q, req%s)", errPrefix, msg, accessor)
+		p("}")
+		checked = true
+	}
+	if checked {
+		p("")
+	}
+}
+
+// resourceRegexp is a package-level regexp matching the names of a resource.
+type resourceRegexp struct {
+	varName   string
+	expr      string
+	generated bool
+}
+
+// resourceRegexpVar returns the name of the package-level variable holding the
+// regexp that matches the given patterns of resource type typ, registering it
+// to be generated by restResourceRegexps if it is new.
+func (g *generator) resourceRegexpVar(typ string, patterns []string) string {
+	expr := resourcePatternRegexp(patterns)
+	base := lowerFirst(typ[strings.LastIndex(typ, "/")+1:]) + "NameRegexp"
+	// Distinct resource types may share a name, e.g. across API domains.
+	name := base
+	for i := 2; ; i++ {
+		re, ok := g.aux.resourceRegexps[name]
+		if !ok {
+			g.aux.resourceRegexps[name] = &resourceRegexp{varName: name, expr: expr}
+			return name
+		}
+		if re.expr == expr {
+			return name
+		}
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// restResourceRegexps emits the resource name regexps referenced by the REST
+// methods that have not already been generated in this package.
+func (g *generator) restResourceRegexps() {
+	var res []*resourceRegexp
+	for _, re := range g.aux.resourceRegexps {
+		if re.generated {
+			continue
+		}
+		re.generated = true
+		res = append(res, re)
+	}
+	if len(res) == 0 {
+		return
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].varName < res[j].varName
+	})
+
+	p := g.printf
+	p("var (")
+	for _, re := range res {
+		p("  %s = regexp.MustCompile(%q)", re.varName, re.expr)
+	}
+	p(")")
+	p("")
+	g.imports[pbinfo.ImportSpec{Path: "regexp"}] = true
+}
+
+// resourcePatterns returns the patterns of the resource with the given type,
+// defined either on a message or in a file-level resource_definition.
+func (g *generator) resourcePatterns(typ string) []string {
+	// Visit types in a deterministic order, so that a resource type that is
+	// (incorrectly) defined more than once always resolves the same way.
+	names := make([]string, 0, len(g.descInfo.Type))
+	for name := range g.descInfo.Type {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := map[*descriptor.FileDescriptorProto]bool{}
+	var files []*descriptor.FileDescriptorProto
+	for _, name := range names {
+		t := g.descInfo.Type[name]
+		if f := g.descInfo.ParentFile[t]; f != nil && !seen[f] {
+			seen[f] = true
+			files = append(files, f)
+		}
+		msg, ok := t.(*descriptor.DescriptorProto)
+		if !ok || msg.GetOptions() == nil {
+			continue
+		}
+		res := proto.GetExtension(msg.GetOptions(), annotations.E_Resource).(*annotations.ResourceDescriptor)
+		if res.GetType() == typ {
+			return res.GetPattern()
+		}
+	}
+	for _, f := range files {
+		if f.GetOptions() == nil {
+			continue
+		}
+		for _, res := range proto.GetExtension(f.GetOptions(), annotations.E_ResourceDefinition).([]*annotations.ResourceDescriptor) {
+			if res.GetType() == typ {
+				return res.GetPattern()
+			}
+		}
+	}
+	return nil
+}
+
+// resourcePatternRegexp converts resource name patterns, e.g.
+// projects/{project}/books/{book}, into a regular expression matching any of
+// them, where each variable matches a single path segment.
+func resourcePatternRegexp(patterns []string) string {
+	vars := regexp.MustCompile(`{[^{}]+}`)
+	alts := make([]string, 0, len(patterns))
+	for _, pat := range patterns {
+		var b strings.Builder
+		last := 0
+		for _, loc := range vars.FindAllStringIndex(pat, -1) {
+			b.WriteString(regexp.QuoteMeta(pat[last:loc[0]]))
+			b.WriteString("[^/]+")
+			last = loc[1]
+		}
+		b.WriteString(regexp.QuoteMeta(pat[last:]))
+		alts = append(alts, b.String())
+	}
+	return "^(?:" + strings.Join(alts, "|") + ")$"
+}
+
+// restRequestObject emits the setup for the value sent as the HTTP request
+// body and returns the name of the variable holding it.
+//
+// When the whole request is the body, top-level fields bound to the URL path
+// are cleared so they are not sent twice. This is done on a clone, because the
+// path is built from the original request and the caller's message must not be
+// mutated.
+func (g *generator) restRequestObject(m *descriptor.MethodDescriptorProto, info *httpInfo) (string, error) {
+	p := g.printf
+
+	if info.body != "*" {
+		// The getter of a oneof member returns nil unless that member is
+		// set, so the body carries it alone, never another of the oneof.
+		p("body := req%s", fieldGetter(info.body))
+		return "body", nil
+	}
+
+	inType, ok := g.descInfo.Type[m.GetInputType()].(*descriptor.DescriptorProto)
+	if !ok {
+		return "req", nil
+	}
+	pathParams := g.pathParams(m)
+	var params []string
+	for param := range pathParams {
+		// Nested path params would need every parent message to be set,
+		// so only top-level fields are cleared. A member of a real oneof
+		// cannot be set directly, so it is left in the body.
+		f := pathParams[param]
+		if strings.Contains(param, ".") || (f.OneofIndex != nil && !f.GetProto3Optional()) {
+			continue
+		}
+		params = append(params, param)
+	}
+	if len(params) == 0 {
+		return "req", nil
+	}
+	sort.Strings(params)
+
+	inSpec, err := g.descInfo.ImportSpec(inType)
+	if err != nil {
+		return "", err
+	}
+	p("body := proto.Clone(req).(*%s.%s)", inSpec.Name, inType.GetName())
+	for _, param := range params {
+		p("body.%s = %s", snakeToCamel(param), zeroValue(pathParams[param], isOptional(inType, param)))
+	}
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/proto"}] = true
+	return "body", nil
+}
+
+// zeroValue returns the Go literal for the zero value of a singular field.
+func zeroValue(f *descriptor.FieldDescriptorProto, optional bool) string {
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE, descriptor.FieldDescriptorProto_TYPE_BYTES:
+		return "nil"
+	}
+	if optional {
+		return "nil"
+	}
+	switch f.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		return `""`
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return "false"
+	default:
+		return "0"
+	}
+}
+
+// marshalRESTBody emits the statement that marshals requestObject into jsonReq.
+// A body that names a repeated message field is sent as a JSON array, which
+// protojson cannot produce from a Go slice, so each element is marshaled on
+// its own. errRet is what the enclosing function returns on failure.
+func (g *generator) marshalRESTBody(m *descriptor.MethodDescriptorProto, info *httpInfo, requestObject, errRet string) error {
+	p := g.printf
+
+	field := g.lookupField(m.GetInputType(), info.body)
+	if info.body != "*" && field.GetType() == fieldTypeBytes && field.GetLabel() != fieldLabelRepeated {
+		// A bare []byte is no proto.Message. Its JSON value is a base64
+		// string, as encoding/json, like protojson, encodes it.
+		p("jsonReq, err := json.Marshal(%s)", requestObject)
+		g.imports[pbinfo.ImportSpec{Path: "encoding/json"}] = true
+		return nil
+	}
+	if info.body == "*" || field.GetLabel() != descriptor.FieldDescriptorProto_LABEL_REPEATED {
+		p("jsonReq, err := marshalOpts.Marshal(%s)", requestObject)
+		return nil
+	}
+
+	if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return errors.E(nil, "unsupported repeated non-message body field %q in method %q", info.body, m.GetName())
+	}
+	if msg, ok := g.descInfo.Type[field.GetTypeName()].(*descriptor.DescriptorProto); ok && msg.GetOptions().GetMapEntry() {
+		return g.marshalRESTMapBody(m, info, msg, requestObject, errRet)
+	}
+
+	p("elems := make([]json.RawMessage, 0, len(%s))", requestObject)
+	p("for _, elem := range %s {", requestObject)
+	p("  b, err := marshalOpts.Marshal(elem)")
+	p("  if err != nil {")
+	p("    return %s", errRet)
+	p("  }")
+	p("  elems = append(elems, b)")
+	p("}")
+	p("jsonReq, err := json.Marshal(elems)")
+	g.imports[pbinfo.ImportSpec{Path: "encoding/json"}] = true
+	return nil
+}
+
+// marshalRESTMapBody generates the marshaling of a body that names a map
+// field, which is sent as a JSON object. Like a repeated body, the Go map
+// cannot be given to protojson, so each value is marshaled on its own, the
+// way protojson encodes it: messages with marshalOpts, enums by name and
+// 64-bit integers as strings. Keys are formatted like protojson map keys.
+func (g *generator) marshalRESTMapBody(m *descriptor.MethodDescriptorProto, info *httpInfo, entry *descriptor.DescriptorProto, requestObject, errRet string) error {
+	p := g.printf
+
+	var value *descriptor.FieldDescriptorProto
+	for _, f := range entry.GetField() {
+		if f.GetName() == "value" {
+			value = f
+		}
+	}
+	var marshal string
+	switch value.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		marshal = "marshalOpts.Marshal(v)"
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		marshal = "json.Marshal(v.String())"
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		marshal = "json.Marshal(fmt.Sprint(v))"
+	case descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES,
+		descriptor.FieldDescriptorProto_TYPE_BOOL,
+		descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SINT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_FLOAT,
+		descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		marshal = "json.Marshal(v)"
+	default:
+		return errors.E(nil, "unsupported map body field %q in method %q", info.body, m.GetName())
+	}
+
+	p("elems := make(map[string]json.RawMessage, len(%s))", requestObject)
+	p("for k, v := range %s {", requestObject)
+	p("  b, err := %s", marshal)
+	p("  if err != nil {")
+	p("    return %s", errRet)
+	p("  }")
+	p("  elems[fmt.Sprint(k)] = b")
+	p("}")
+	p("jsonReq, err := json.Marshal(elems)")
+	g.imports[pbinfo.ImportSpec{Path: "encoding/json"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "fmt"}] = true
+	return nil
+}
+
+func getHTTPInfo(m *descriptor.MethodDescriptorProto) *httpInfo {
+	if m == nil || m.GetOptions() == nil {
+		return nil
+	}
+
+	eHTTP := proto.GetExtension(m.GetOptions(), annotations.E_Http)
+
+	httpRule := eHTTP.(*annotations.HttpRule)
+	info := ruleHTTPInfo(httpRule)
+	for _, b := range httpRule.GetAdditionalBindings() {
+		info.bindings = append(info.bindings, ruleHTTPInfo(b))
+	}
+	return info
+}
+
+// ruleHTTPInfo returns the verb, URL and body of a single HTTP rule, ignoring
+// its additional_bindings.
+func ruleHTTPInfo(httpRule *annotations.HttpRule) *httpInfo {
+	info := httpInfo{body: httpRule.GetBody()}
+
+	switch httpRule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		info.verb = "get"
+		info.url = httpRule.GetGet()
+	case *annotations.HttpRule_Post:
+		info.verb = "post"
+		info.url = httpRule.GetPost()
+	case *annotations.HttpRule_Patch:
+		info.verb = "patch"
+		info.url = httpRule.GetPatch()
+	case *annotations.HttpRule_Put:
+		info.verb = "put"
+		info.url = httpRule.GetPut()
+	case *annotations.HttpRule_Delete:
+		info.verb = "delete"
+		info.url = httpRule.GetDelete()
+	case *annotations.HttpRule_Custom:
+		// The kind is an arbitrary HTTP method, e.g. HEAD or OPTIONS. It is
+		// lowercased like the others and uppercased again in the request.
+		info.verb = strings.ToLower(httpRule.GetCustom().GetKind())
+		info.url = httpRule.GetCustom().GetPath()
+	}
+	// Some protos omit the leading slash, which would otherwise join the
+	// template directly onto the endpoint path, e.g. "...comv1/foo".
+	if info.url != "" && !strings.HasPrefix(info.url, "/") {
+		info.url = "/" + info.url
+	}
+
+	return &info
+}
+
+// genRESTMethod generates a single method from a client. m must be a method declared in serv.
+// If the generated method requires an auxiliary type, it is added to aux.
+func (g *generator) genRESTMethod(servName string, serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
+	if g.isLRO(m) {
+		g.aux.lros[m] = true
+		return g.lroRESTCall(servName, serv, m)
+	}
+
+	if m.GetOutputType() == emptyType {
+		return g.emptyUnaryRESTCall(servName, m)
+	}
+
+	if pf, ps, err := g.getPagingFields(m); err != nil {
+		return err
+	} else if pf != nil {
+		iter, err := g.iterTypeOf(pf)
+		if err != nil {
+			return err
+		}
+
+		if err := g.pagingRESTCall(servName, m, pf, ps, iter); err != nil {
+			return err
+		}
+		if g.hasPage(m) {
+			g.printf("")
+			g.printf("// %sPage is like %[1]s, but returns the single page of results requested", m.GetName())
+			g.printf("// by req and the token of the next one, which is empty after the last page.")
+			return g.unaryRESTCall(servName, m, restPage)
+		}
+		return nil
+	}
+
+	switch {
+	case m.GetClientStreaming():
+		return g.noRequestStreamRESTCall(servName, serv, m)
+	case m.GetServerStreaming():
+		return g.serverStreamRESTCall(servName, serv, m)
+	default:
+		if err := g.unaryRESTCall(servName, m, restPlain); err != nil {
+			return err
+		}
+		p := g.printf
+		if g.hasWithResponse(m) {
+			p("")
+			p("// %sWithResponse is like %[1]s, but also returns the HTTP response.", m.GetName())
+			p("// Its body has already been consumed. Its StatusCode tells apart the")
+			p("// successful statuses, e.g. 201 Created from 202 Accepted. It is returned")
+			p("// with an error too, so that RateLimitOf can read its rate-limit headers.")
+			if err := g.unaryRESTCall(servName, m, restWithResponse); err != nil {
+				return err
+			}
+		}
+		if g.hasMedia(m) {
+			p("")
+			p("// %sMedia is like %[1]s, but streams the response body instead of buffering", m.GetName())
+			p("// it. The caller must close it, and ctx must not be done before it is read.")
+			if err := g.unaryRESTCall(servName, m, restMedia); err != nil {
+				return err
+			}
+		}
+		if g.hasUpload(m) {
+			p("")
+			p("// %sUpload is like %[1]s, but also uploads media as the content of the", m.GetName())
+			p("// resource, in a resumable session sent in chunks of the googleapi.ChunkSize")
+			p("// in mediaOpts. Only the creation of the session is retried.")
+			if err := g.unaryRESTCall(servName, m, restUpload); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (g *generator) serverStreamRESTCall(servName string, s *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
+	info := getHTTPInfo(m)
+	if info == nil {
+		return errors.E(nil, "method has no http info: %s", m.GetName())
+	}
+
+	inType := g.descInfo.Type[m.GetInputType()]
+	outType := g.descInfo.Type[m.GetOutputType()]
+
+	inSpec, err := g.descInfo.ImportSpec(inType)
+	if err != nil {
+		return err
+	}
+	outSpec, err := g.descInfo.ImportSpec(outType)
+	if err != nil {
+		return err
+	}
+	servSpec, err := g.descInfo.ImportSpec(s)
+	if err != nil {
+		return err
+	}
+	outFqn := fmt.Sprintf("%s.%s", g.descInfo.ParentFile[outType].GetPackage(), outType.GetName())
+	isHTTPBodyMessage := outFqn == "google.api.HttpBody"
+
+	p := g.printf
+	lowcaseServName := lowcaseRestClientName(servName)
+	streamClient := fmt.Sprintf("%sRESTClient", lowerFirst(m.GetName()))
+	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) (%s.%s_%sClient, error) {",
+		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName(), servSpec.Name, s.GetName(), m.GetName())
+	if info.invalidBody() {
+		g.invalidRESTMethod(m, info, "nil, ", inSpec, servSpec)
+		return nil
+	}
+	g.restStreamTraceSpan(m)
+	g.appendCallOpts(m)
+	g.restRequiredChecks(m, "nil, ")
+	g.restResourceChecks(m, "nil, ")
+
+	body := "nil"
+	verb := strings.ToUpper(info.verb)
+
+	// Marshal body for HTTP methods that take a body.
+	if info.body != "" {
+		requestObject, err := g.restRequestObject(m, info)
+		if err != nil {
+			return err
+		}
+		if err := g.marshalRESTBody(m, info, requestObject, "nil, err"); err != nil {
+			return err
+		}
+		p("if err != nil {")
+		p("  return nil, err")
+		p("}")
+		p("")
+
+		body = "bytes.NewReader(jsonReq)"
+		g.imports[pbinfo.ImportSpec{Path: "bytes"}] = true
+	}
+
+	if err := g.generateURLString(m, "nil, "); err != nil {
+		return err
+	}
+	g.generateQueryString(m, "nil, ")
+	g.restFieldsParam()
+	p("// Build HTTP headers from client and context metadata.")
+	p("headers := %s", g.restHeaders())
+	p("var streamClient *%s", streamClient)
+	p("e := gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
+	p(`  httpReq, err := http.NewRequestWithContext(ctx, "%s", baseUrl.String(), %s)`, verb, body)
+	p("  if err != nil {")
+	p("      return err")
+	p("  }")
+	p("  httpReq.Header = headers")
+	p("  setServerTimeout(ctx, httpReq.Header)")
+	g.restInterceptRequest()
+	g.restLogRequest()
+	p("")
+	g.restDo(m)
+	p("  if err != nil{")
+	p("   return maybeTransient(err)")
+	p("  }")
+	g.restInterceptResponse()
+	p("")
+	p("  if err = googleapi.CheckResponse(httpRsp); err != nil {")
+	p("    httpRsp.Body.Close()")
+	p("    return maybeAPIError(err)")
+	p("  }")
+	p("")
+	// metadata.MD keys are lowercase, whereas http.Header keys are
+	// canonicalized, so they are converted for Header and Trailer.
+	p("  md := metadata.MD{}")
+	p("  for k, v := range httpRsp.Header {")
+	p("    md[strings.ToLower(k)] = v")
+	p("  }")
+	p("")
+	p("  // The response body is consumed, and closed, by the stream client.")
+	p("  streamClient = &%s{", streamClient)
+	p("    ctx: ctx,")
+	p("    md: md,")
+	// grpc.ClientStream exposes headers as metadata, so the import is
+	// needed here even when the client otherwise uses http.Header.
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc/metadata"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "strings"}] = true
+	p("    body: httpRsp.Body,")
+	if !isHTTPBodyMessage {
+		p("    decoder: json.NewDecoder(httpRsp.Body),")
+	}
+	if g.opts.restTracing {
+		p("    span: span,")
+	}
+	p("  }")
+	p("  return nil")
+	p("}, opts...)")
+	p("if e != nil {")
+	p("  return nil, e")
+	p("}")
+	p("")
+	if g.opts.restTracing {
+		p("// The stream client ends the span once the stream is finished.")
+		p("endSpan = false")
+	}
+	p("return streamClient, nil")
+	p("}")
+	p("")
+
+	// Stream client type.
+	p("// %s is the stream client used to consume the server stream created by", streamClient)
+	p("// the REST implementation of %s.", m.GetName())
+	p("type %s struct {", streamClient)
+	p("  ctx context.Context")
+	p("  md metadata.MD")
+	p("  body io.ReadCloser")
+	if !isHTTPBodyMessage {
+		p("  decoder *json.Decoder")
+		p("  started bool")
+	}
+	closeStream := "c.body.Close()"
+	if g.opts.restTracing {
+		p("  span *trace.Span")
+		closeStream = "c.close()"
+	}
+	p("}")
+	p("")
+	if g.opts.restTracing {
+		p("// close releases the response body and ends the span of the call.")
+		p("func (c *%s) close() {", streamClient)
+		p("  c.body.Close()")
+		p("  c.span.End()")
+		p("}")
+		p("")
+	}
+	p("func (c *%s) Recv() (*%s.%s, error) {", streamClient, outSpec.Name, outType.GetName())
+	p("  if err := c.ctx.Err(); err != nil {")
+	p("    defer %s", closeStream)
+	p("    return nil, err")
+	p("  }")
+	if isHTTPBodyMessage {
+		// HttpBody responses are arbitrary data, so each message is simply
+		// the next chunk of the response body.
+		p("  buf := make([]byte, 32*1024)")
+		p("  n, err := c.body.Read(buf)")
+		p("  if n == 0 && err != nil {")
+		p("    defer %s", closeStream)
+		p("    return nil, err")
+		p("  }")
+		p("  res := &%s.%s{Data: buf[:n]}", outSpec.Name, outType.GetName())
+		p(`  if ct := c.md.Get("content-type"); len(ct) > 0 {`)
+		p("    res.ContentType = ct[0]")
+		p("  }")
+		p("  return res, nil")
+	} else {
+		// The stream is a single JSON array of messages, so the brackets
+		// are consumed as tokens around decoding each element.
+		p("  if !c.started {")
+		p("    c.started = true")
+		p("    t, err := c.decoder.Token()")
+		p("    if err != nil {")
+		p("      defer %s", closeStream)
+		p("      return nil, err")
+		p("    }")
+		p("    if d, ok := t.(json.Delim); !ok || d != '[' {")
+		p("      defer %s", closeStream)
+		p(`      return nil, fmt.Errorf("expected the start of a JSON array, got %%v", t)`)
+		p("    }")
+		p("  }")
+		p("  if !c.decoder.More() {")
+		p("    defer %s", closeStream)
+		p("    // Consume the closing bracket of the array.")
+		p("    if _, err := c.decoder.Token(); err != nil {")
+		p("      return nil, err")
+		p("    }")
+		p("    return nil, io.EOF")
+		p("  }")
+		p("  var raw json.RawMessage")
+		p("  if err := c.decoder.Decode(&raw); err != nil {")
+		p("    defer %s", closeStream)
+		p("    return nil, err")
+		p("  }")
+		p("  res := &%s.%s{}", outSpec.Name, outType.GetName())
+		p("  if err := unmarshalOpts.Unmarshal(raw, res); err != nil {")
+		p("    return nil, maybeUnknownEnum(err)")
+		p("  }")
+		p("  return res, nil")
+		g.imports[pbinfo.ImportSpec{Path: "encoding/json"}] = true
+	}
+	p("}")
+	p("")
+	p("func (c *%s) Header() (metadata.MD, error) {", streamClient)
+	p("  return c.md, nil")
+	p("}")
+	p("")
+	p("func (c *%s) Trailer() metadata.MD {", streamClient)
+	p("  return c.md")
+	p("}")
+	p("")
+	p("func (c *%s) CloseSend() error {", streamClient)
+	p("  // This is a no-op to fulfill the interface.")
+	p(`  return fmt.Errorf("this method is not implemented for a server-stream")`)
+	p("}")
+	p("")
+	p("func (c *%s) Context() context.Context {", streamClient)
+	p("  return c.ctx")
+	p("}")
+	p("")
+	p("func (c *%s) SendMsg(m interface{}) error {", streamClient)
+	p("  // This is a no-op to fulfill the interface.")
+	p(`  return fmt.Errorf("this method is not implemented for a server-stream")`)
+	p("}")
+	p("")
+	p("func (c *%s) RecvMsg(m interface{}) error {", streamClient)
+	p("  // This is a no-op to fulfill the interface.")
+	p(`  return fmt.Errorf("this method is not implemented, use Recv")`)
+	p("}")
+	p("")
+
+	g.imports[pbinfo.ImportSpec{Path: "io"}] = true
+	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
+	g.imports[inSpec] = true
+	g.imports[outSpec] = true
+	g.imports[servSpec] = true
+
+	return nil
+}
+
+func (g *generator) noRequestStreamRESTCall(servName string, s *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
+	// Streaming calls are not currently supported for REST clients,
+	// but the interface signature must be preserved.
+	// Unimplemented REST methods will always error.
+
+	p := g.printf
+
+	servSpec, err := g.descInfo.ImportSpec(s)
+	if err != nil {
+		return err
+	}
+	g.imports[servSpec] = true
+
+	lowcaseServName := lowcaseRestClientName(servName)
+
+	p("func (c *%s) %s(ctx context.Context, opts ...gax.CallOption) (%s.%s_%sClient, error) {",
+		lowcaseServName, m.GetName(), servSpec.Name, s.GetName(), m.GetName())
+	p(`  return nil, fmt.Errorf("%s not yet supported for REST clients")`, m.GetName())
+	p("}")
+	p("")
+
+	return nil
+}
+
+func (g *generator) pagingRESTCall(servName string, m *descriptor.MethodDescriptorProto, elemField, pageSize *descriptor.FieldDescriptorProto, pt *iterType) error {
+	lowcaseServName := lowcaseRestClientName(servName)
 	p := g.printf
 
 	inType := g.descInfo.Type[m.GetInputType()].(*descriptor.DescriptorProto)
@@ -559,9 +2271,6 @@ func (g *generator) pagingRESTCall(servName string, m *descriptor.MethodDescript
 		return err
 	}
 	info := getHTTPInfo(m)
-	if err != nil {
-		return err
-	}
 	if info == nil {
 		return errors.E(nil, "method has no http info: %s", m.GetName())
 	}
@@ -576,64 +2285,89 @@ func (g *generator) pagingRESTCall(servName string, m *descriptor.MethodDescript
 		max = fmt.Sprintf("proto.%s(%s)", upperFirst(psTyp), max)
 		ps = fmt.Sprintf("proto.%s(%s)", upperFirst(psTyp), ps)
 	}
+	pageToken := g.pageTokenField(inType)
 	tok := "pageToken"
-	if isOptional(inType, "page_token") {
+	if pageToken.GetProto3Optional() {
 		tok = fmt.Sprintf("proto.String(%s)", tok)
 	}
 
 	pageSizeFieldName := snakeToCamel(pageSize.GetName())
+	pageTokenFieldName := snakeToCamel(pageToken.GetName())
 	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) *%s {",
 		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName(), pt.iterTypeName)
+	g.appendCallOpts(m)
 	p("it := &%s{}", pt.iterTypeName)
 	p("req = proto.Clone(req).(*%s.%s)", inSpec.Name, inType.GetName())
 
 	maybeReqBytes := "nil"
 	if info.body != "" {
-		p("m := protojson.MarshalOptions{AllowPartial: true, UseProtoNames: false}")
 		maybeReqBytes = "bytes.NewReader(jsonReq)"
 		g.imports[pbinfo.ImportSpec{Path: "bytes"}] = true
 	}
 
-	p("unm := protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}")
+	prefetch := g.opts.prefetchPages
+	if prefetch {
+		// The response of a prefetched page must not reach the iterator
+		// before the page is consumed, so InternalFetch only stashes it.
+		p("var lastResp *%s.%s", outSpec.Name, outType.GetName())
+	}
 	p("it.InternalFetch = func(pageSize int, pageToken string) ([]%s, string, error) {", pt.elemTypeName)
-	g.internalFetchSetup(outType, outSpec, tok, pageSizeFieldName, max, ps)
+	g.internalFetchSetup(outType, outSpec, tok, pageTokenFieldName, pageSizeFieldName, max, ps)
+	g.restPageDeadline(m)
+	g.restTraceSpan(m)
+	g.restRequiredChecks(m, `nil, "", `)
+	g.restResourceChecks(m, `nil, "", `)
 
 	if info.body != "" {
-		p("  jsonReq, err := m.Marshal(req)")
+		p("  jsonReq, err := marshalOpts.Marshal(req)")
 		p("  if err != nil {")
 		p(`    return nil, "", err`)
 		p("  }")
 		p("")
 	}
 
-	g.generateURLString(m)
-	g.generateQueryString(m)
+	if err := g.generateURLString(m, `nil, "", `); err != nil {
+		return err
+	}
+	g.generateQueryString(m, `nil, "", `)
+	g.restFieldsParam()
 	p("  // Build HTTP headers from client and context metadata.")
-	p(`  headers := buildHeaders(ctx, c.xGoogMetadata, metadata.Pairs("Content-Type", "application/json"))`)
+	p("  headers := %s", g.restHeaders())
 	p("  e := gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
-	p(`    httpReq, err := http.NewRequest("%s", baseUrl.String(), %s)`, verb, maybeReqBytes)
+	g.restAttemptTimeout()
+	// Binding the request to ctx makes the transport abort the response body
+	// read as soon as ctx is cancelled, not just the round trip.
+	p(`    httpReq, err := http.NewRequestWithContext(ctx, "%s", baseUrl.String(), %s)`, verb, maybeReqBytes)
 	p("    if err != nil {")
 	p(`      return err`)
 	p("    }")
-	// TODO: Should this http.Request use WithContext?
 	p("    httpReq.Header = headers")
+	p("    setServerTimeout(ctx, httpReq.Header)")
+	g.restInterceptRequest()
+	g.restLogRequest()
 	p("")
-	p("    httpRsp, err := c.httpClient.Do(httpReq)")
+	g.restDo(m)
 	p("    if err != nil{")
-	p(`     return err`)
+	p(`     return maybeTransient(err)`)
 	p("    }")
+	g.restInterceptResponse()
 	p("    defer httpRsp.Body.Close()")
 	p("")
 	p("    if err = googleapi.CheckResponse(httpRsp); err != nil {")
-	p(`      return err`)
+	p(`      return maybeAPIError(err)`)
 	p("    }")
 	p("")
-	p("    buf, err := ioutil.ReadAll(httpRsp.Body)")
+	g.restNoContent()
+	p("    buf, err := %s", g.restReadBody())
 	p("    if err != nil {")
 	p(`      return err`)
 	p("    }")
 	p("")
-	p("    if err := unm.Unmarshal(buf, resp); err != nil {")
+	p("    if len(buf) == 0 {")
+	p("      return nil")
+	p("    }")
+	p("")
+	p("    if err := unmarshalOpts.Unmarshal(buf, resp); err != nil {")
 	p("      return maybeUnknownEnum(err)")
 	p("    }")
 	p("")
@@ -642,50 +2376,114 @@ func (g *generator) pagingRESTCall(servName string, m *descriptor.MethodDescript
 	p("  if e != nil {")
 	p(`    return nil, "", e`)
 	p("  }")
-	p("  it.Response = resp")
+	if prefetch {
+		p("  lastResp = resp")
+	} else {
+		p("  it.Response = resp")
+		g.pagingTotalSize(outType, pt)
+	}
 	elems := g.maybeSortMapPage(elemField, pt)
 	p("  return %s, resp.GetNextPageToken(), nil", elems)
 	p("}")
 	p("")
-	g.makeFetchAndIterUpdate(pageSizeFieldName)
+	if prefetch {
+		g.prefetchFetchAndIterUpdate(outType, outSpec, pt, pageSizeFieldName, pageTokenFieldName)
+	} else {
+		g.makeFetchAndIterUpdate(pageSizeFieldName, pageTokenFieldName)
+	}
 	p("}")
 
 	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/iterator"}] = true
 	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/proto"}] = true
-	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/encoding/protojson"}] = true
 	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
 	g.imports[inSpec] = true
 	g.imports[outSpec] = true
+	for _, spec := range pt.elemImports {
+		g.imports[spec] = true
+	}
 
 	return nil
 }
 
-func (g *generator) lroRESTCall(servName string, m *descriptor.MethodDescriptorProto) error {
-	lowcaseServName := lowcaseRestClientName(servName)
+// prefetchFetchAndIterUpdate is like makeFetchAndIterUpdate, but under the
+// rest-prefetch-pages option it fetches the next page in the background once
+// the caller reaches the last item of the current one, i.e. when the
+// buffer of the iterator is about to run dry. At most one page is fetched
+// ahead, and the calls of InternalFetch never overlap, as they share req. A
+// prefetched page is only used if it was fetched with the page size and
+// token requested next, e.g. not after the page size of a Pager changed.
+func (g *generator) prefetchFetchAndIterUpdate(outType *descriptor.DescriptorProto, outSpec pbinfo.ImportSpec, pt *iterType, pageSizeFieldName, pageTokenFieldName string) {
 	p := g.printf
-	inType := g.descInfo.Type[m.GetInputType()].(*descriptor.DescriptorProto)
-	// outType := g.descInfo.Type[m.GetOutputType()].(*descriptor.DescriptorProto)
-
-	inSpec, err := g.descInfo.ImportSpec(inType)
-	if err != nil {
-		return err
-	}
-
-	// outSpec, err := g.descInfo.ImportSpec(outType)
-	// if err != nil {
-	// 	return err
-	// }
 
-	lroType := lroTypeName(m.GetName())
-	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) (*%s, error) {",
-		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName(), lroType)
-	p(`    return nil, fmt.Errorf("%s not yet supported for REST clients")`, m.GetName())
+	p("type fetchedPage struct {")
+	p("  items []%s", pt.elemTypeName)
+	p("  nextPageToken string")
+	p("  resp *%s.%s", outSpec.Name, outType.GetName())
+	p("  err error")
+	p("}")
+	p("fetchPage := func(pageSize int, pageToken string) fetchedPage {")
+	p("  items, nextPageToken, err := it.InternalFetch(pageSize, pageToken)")
+	p("  return fetchedPage{items, nextPageToken, lastResp, err}")
+	p("}")
+	p("var prefetched chan fetchedPage")
+	p("var prefetchedSize, nextSize int")
+	p("var prefetchedToken, nextToken string")
+	p("fetch := func(pageSize int, pageToken string) (string, error) {")
+	p("  var page fetchedPage")
+	p("  if prefetched != nil && pageSize == prefetchedSize && pageToken == prefetchedToken {")
+	p("    page = <-prefetched")
+	p("  } else {")
+	p("    if prefetched != nil {")
+	p("      // Wait for the unused page, which is being fetched with req.")
+	p("      <-prefetched")
+	p("    }")
+	p("    page = fetchPage(pageSize, pageToken)")
+	p("  }")
+	p("  prefetched = nil")
+	p("  if page.err != nil {")
+	p(`    return "", page.err`)
+	p("  }")
+	p("  resp := page.resp")
+	p("  it.Response = resp")
+	g.pagingTotalSize(outType, pt)
+	p("  it.items = append(it.items, page.items...)")
+	p("  nextSize, nextToken = pageSize, page.nextPageToken")
+	p("  return page.nextPageToken, nil")
+	p("}")
+	p("// Next checks the length of the buffer before taking each item, so the")
+	p("// next page is only requested once the last item of this one is taken.")
+	p("// An iteration stopped at that item thus makes one extra request.")
+	p("bufLen := func() int {")
+	p("  n := it.bufLen()")
+	p(`  if n == 1 && prefetched == nil && nextToken != "" {`)
+	p("    prefetched = make(chan fetchedPage, 1)")
+	p(`    prefetchedSize, prefetchedToken, nextToken = nextSize, nextToken, ""`)
+	p("    go func(ch chan<- fetchedPage, pageSize int, pageToken string) {")
+	p("      ch <- fetchPage(pageSize, pageToken)")
+	p("    }(prefetched, prefetchedSize, prefetchedToken)")
+	p("  }")
+	p("  return n")
 	p("}")
 	p("")
+	p("it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, bufLen, it.takeBuf)")
+	p("it.pageInfo.MaxSize = int(req.Get%s())", pageSizeFieldName)
+	p("it.pageInfo.Token = req.Get%s()", pageTokenFieldName)
+	p("")
+	p("return it")
+}
+
+func (g *generator) lroRESTCall(servName string, serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
+	// The returned operation wrapper is typed by the operation_info of m, see
+	// lroType. Resolve it here too, so that a REST-only client with a broken
+	// annotation fails at the method rather than in the auxiliary types. The
+	// types are not imported, since the method itself does not use them.
+	if _, _, _, err := g.lroResultSpecs(serv, m); err != nil {
+		return err
+	}
 
 	g.imports[pbinfo.ImportSpec{Path: "cloud.google.com/go/longrunning"}] = true
 
-	return nil
+	return g.unaryRESTCall(servName, m, restPlain)
 }
 
 func (g *generator) emptyUnaryRESTCall(servName string, m *descriptor.MethodDescriptorProto) error {
@@ -704,11 +2502,24 @@ func (g *generator) emptyUnaryRESTCall(servName string, m *descriptor.MethodDesc
 	lowcaseServName := lowcaseRestClientName(servName)
 	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) error {",
 		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName())
+	if info.invalidBody() {
+		g.invalidRESTMethod(m, info, "", inSpec)
+		return nil
+	}
+	g.restDefaultTimeout(m)
+	g.restTraceSpan(m)
+	g.appendCallOpts(m)
+	if err := g.restAutoUpdateMask(m); err != nil {
+		return err
+	}
+	if err := g.restRequestID(m, info); err != nil {
+		return err
+	}
+	g.restRequiredChecks(m, "")
+	g.restResourceChecks(m, "")
 
 	// TODO(dovs): handle cancellation, metadata, osv.
 	// TODO(dovs): handle http headers
-	// TODO(dovs): handle deadlines
-	// TODO(dovs): handle call options
 
 	body := "nil"
 	verb := strings.ToUpper(info.verb)
@@ -716,46 +2527,49 @@ func (g *generator) emptyUnaryRESTCall(servName string, m *descriptor.MethodDesc
 	// Marshal body for HTTP methods that take a body.
 	// TODO(dovs): add tests generating methods with(out) a request body.
 	if info.body != "" {
-		if verb == http.MethodGet || verb == http.MethodDelete {
-			return fmt.Errorf("invalid use of body parameter for a get/delete method %q", m.GetName())
+		requestObject, err := g.restRequestObject(m, info)
+		if err != nil {
+			return err
 		}
-		p("m := protojson.MarshalOptions{AllowPartial: true, UseProtoNames: false}")
-		requestObject := "req"
-		if info.body != "*" {
-			requestObject = "body"
-			p("body := req%s", fieldGetter(info.body))
+		if err := g.marshalRESTBody(m, info, requestObject, "err"); err != nil {
+			return err
 		}
-		p("jsonReq, err := m.Marshal(%s)", requestObject)
 		p("if err != nil {")
 		p("  return err")
 		p("}")
 		p("")
 		body = "bytes.NewReader(jsonReq)"
 		g.imports[pbinfo.ImportSpec{Path: "bytes"}] = true
-		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/encoding/protojson"}] = true
 	}
 
-	g.generateURLString(m)
-	g.generateQueryString(m)
+	if err := g.generateURLString(m, ""); err != nil {
+		return err
+	}
+	g.generateQueryString(m, "")
 	p("// Build HTTP headers from client and context metadata.")
-	p(`headers := buildHeaders(ctx, c.xGoogMetadata, metadata.Pairs("Content-Type", "application/json"))`)
+	p("headers := %s", g.restHeaders())
+	g.restIfMatch(m, info)
 	p("return gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
-	p(`  httpReq, err := http.NewRequest("%s", baseUrl.String(), %s)`, verb, body)
+	g.restAttemptTimeout()
+	p(`  httpReq, err := http.NewRequestWithContext(ctx, "%s", baseUrl.String(), %s)`, verb, body)
 	p("  if err != nil {")
 	p("      return err")
 	p("  }")
-	p("  httpReq = httpReq.WithContext(ctx)")
 	p("  httpReq.Header = headers")
+	p("  setServerTimeout(ctx, httpReq.Header)")
+	g.restInterceptRequest()
+	g.restLogRequest()
 	p("")
-	p("  httpRsp, err := c.httpClient.Do(httpReq)")
+	g.restDo(m)
 	p("  if err != nil{")
-	p("   return err")
+	p("   return maybeTransient(err)")
 	p("  }")
+	g.restInterceptResponse()
 	p("  defer httpRsp.Body.Close()")
 	p("")
 	p("  // Returns nil if there is no error, otherwise wraps")
 	p("  // the response code and body into a non-nil error")
-	p("  return googleapi.CheckResponse(httpRsp)")
+	p("  return maybeAPIError(googleapi.CheckResponse(httpRsp))")
 	p("  }, opts...)")
 	p("}")
 
@@ -764,7 +2578,92 @@ func (g *generator) emptyUnaryRESTCall(servName string, m *descriptor.MethodDesc
 	return nil
 }
 
-func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescriptorProto) error {
+// restVariant selects which variant of a unary REST method is generated.
+type restVariant int
+
+const (
+	// restPlain is the method of the client interface.
+	restPlain restVariant = iota
+	// restWithResponse also returns the *http.Response.
+	restWithResponse
+	// restMedia returns the streamed body of a google.api.HttpBody.
+	restMedia
+	// restUpload uploads media in a resumable upload session.
+	restUpload
+	// restPage returns a single page of a paginated method.
+	restPage
+)
+
+// hasWithResponse reports whether a WithResponse variant of m is generated,
+// which also returns the *http.Response. Only unary methods returning a plain
+// message have one, when the rest-with-response option is enabled.
+func (g *generator) hasWithResponse(m *descriptor.MethodDescriptorProto) bool {
+	return g.opts.withResponse && g.isPlainUnaryREST(m)
+}
+
+// hasMedia reports whether a Media variant of m is generated, which streams
+// the body of the response. Only unary methods returning a google.api.HttpBody
+// have one, when the rest-stream-media option is enabled.
+func (g *generator) hasMedia(m *descriptor.MethodDescriptorProto) bool {
+	if !g.opts.streamMedia || !g.isPlainUnaryREST(m) {
+		return false
+	}
+	outType := g.descInfo.Type[m.GetOutputType()]
+	return fmt.Sprintf("%s.%s", g.descInfo.ParentFile[outType].GetPackage(), outType.GetName()) == "google.api.HttpBody"
+}
+
+// hasUpload reports whether an Upload variant of m is generated, which uploads
+// media in a resumable session. Methods whose HTTP rule has a body and a path
+// under /upload/, the convention of Google media upload endpoints, have one
+// when the rest-media-upload option is enabled.
+func (g *generator) hasUpload(m *descriptor.MethodDescriptorProto) bool {
+	if !g.opts.mediaUpload || !g.isPlainUnaryREST(m) {
+		return false
+	}
+	info := getHTTPInfo(m)
+	if info.body == "" || !strings.HasPrefix(info.url, "/upload/") {
+		return false
+	}
+	outType := g.descInfo.Type[m.GetOutputType()]
+	return fmt.Sprintf("%s.%s", g.descInfo.ParentFile[outType].GetPackage(), outType.GetName()) != "google.api.HttpBody"
+}
+
+// hasPage reports whether a Page variant of the paginated method m is generated,
+// which returns the response of a single call instead of an iterator. Paginated
+// methods have one when the rest-disable-iterators option is enabled.
+func (g *generator) hasPage(m *descriptor.MethodDescriptorProto) bool {
+	if !g.opts.disableIterators || !containsTransport(g.opts.transports, rest) {
+		return false
+	}
+	info := getHTTPInfo(m)
+	if info == nil || info.invalidBody() || g.isLRO(m) {
+		return false
+	}
+	pf, _, err := g.getPagingFields(m)
+	return err == nil && pf != nil
+}
+
+// isPlainUnaryREST reports whether m is generated as a REST unary method
+// returning a plain message, i.e. not an LRO, custom operation, paginated,
+// streaming or Empty returning method.
+func (g *generator) isPlainUnaryREST(m *descriptor.MethodDescriptorProto) bool {
+	if !containsTransport(g.opts.transports, rest) {
+		return false
+	}
+	info := getHTTPInfo(m)
+	if info == nil || info.invalidBody() || g.isLRO(m) || g.isCustomOp(m, info) || m.GetOutputType() == emptyType {
+		return false
+	}
+	if m.GetClientStreaming() || m.GetServerStreaming() {
+		return false
+	}
+	pf, _, err := g.getPagingFields(m)
+	return err == nil && pf == nil
+}
+
+// unaryRESTCall generates the given variant of the REST implementation of
+// unary method m.
+func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescriptorProto, v restVariant) error {
 	info := getHTTPInfo(m)
 	if info == nil {
 		return errors.E(nil, "method has no http info: %s", m.GetName())
@@ -777,6 +2676,8 @@ func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescripto
 	if err != nil {
 		return err
 	}
+	// The output type may belong to another package than the service, e.g. a
+	// response message shared by several APIs, so it has its own alias.
 	outSpec, err := g.descInfo.ImportSpec(outType)
 	if err != nil {
 		return err
@@ -789,16 +2690,57 @@ func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescripto
 	retTyp, _ := g.returnType(m)
 
 	isCustomOp := g.isCustomOp(m, info)
+	isLRO := g.isLRO(m)
+	if isLRO {
+		retTyp = "*" + lroTypeName(m.GetName())
+	}
 
 	p := g.printf
 	lowcaseServName := lowcaseRestClientName(servName)
-	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) (%s, error) {",
-		lowcaseServName, m.GetName(), inSpec.Name, inType.GetName(), retTyp)
+	name, errPrefix := m.GetName(), "nil, "
+	withResponse, media, upload, page := v == restWithResponse, v == restMedia, v == restUpload, v == restPage
+	var uploadParams string
+	if page {
+		name += "Page"
+		errPrefix = `nil, "", `
+		retTyp = fmt.Sprintf("*%s.%s, string", outSpec.Name, outType.GetName())
+	} else if withResponse {
+		name += "WithResponse"
+		errPrefix = "nil, nil, "
+		retTyp += ", *http.Response"
+	} else if media {
+		name += "Media"
+		retTyp = "io.ReadCloser"
+		g.imports[pbinfo.ImportSpec{Path: "io"}] = true
+	} else if upload {
+		name += "Upload"
+		uploadParams = "media io.Reader, mediaOpts []googleapi.MediaOption, "
+		g.imports[pbinfo.ImportSpec{Path: "io"}] = true
+	}
+	p("func (c *%s) %s(ctx context.Context, req *%s.%s, %sopts ...gax.CallOption) (%s, error) {",
+		lowcaseServName, name, inSpec.Name, inType.GetName(), uploadParams, retTyp)
+	if info.invalidBody() {
+		g.invalidRESTMethod(m, info, errPrefix, inSpec, outSpec)
+		return nil
+	}
+	// The body of a Media variant outlives the call, so it is left to the
+	// caller's context.
+	if !media {
+		g.restDefaultTimeout(m)
+	}
+	g.restTraceSpan(m)
+	g.appendCallOpts(m)
+	if err := g.restAutoUpdateMask(m); err != nil {
+		return err
+	}
+	if err := g.restRequestID(m, info); err != nil {
+		return err
+	}
+	g.restRequiredChecks(m, errPrefix)
+	g.restResourceChecks(m, errPrefix)
 
 	// TODO(dovs): handle cancellation, metadata, osv.
 	// TODO(dovs): handle http headers
-	// TODO(dovs): handle deadlines?
-	// TODO(dovs): handle calloptions
 
 	body := "nil"
 	verb := strings.ToUpper(info.verb)
@@ -806,53 +2748,155 @@ func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescripto
 	// Marshal body for HTTP methods that take a body.
 	// TODO(dovs): add tests generating methods with(out) a request body.
 	if info.body != "" {
-		if verb == http.MethodGet || verb == http.MethodDelete {
-			return fmt.Errorf("invalid use of body parameter for a get/delete method %q", m.GetName())
+		requestObject, err := g.restRequestObject(m, info)
+		if err != nil {
+			return err
 		}
-		p("m := protojson.MarshalOptions{AllowPartial: true}")
-		requestObject := "req"
-		if info.body != "*" {
-			requestObject = "body"
-			p("body := req%s", fieldGetter(info.body))
+		if err := g.marshalRESTBody(m, info, requestObject, errPrefix+"err"); err != nil {
+			return err
 		}
-		p("jsonReq, err := m.Marshal(%s)", requestObject)
 		p("if err != nil {")
-		p("  return nil, err")
+		p("  return %serr", errPrefix)
 		p("}")
 		p("")
 
+		// The reader is created inside the retried closure, so every attempt
+		// sends the full body, and net/http sets ContentLength and GetBody.
 		body = "bytes.NewReader(jsonReq)"
 		g.imports[pbinfo.ImportSpec{Path: "bytes"}] = true
 	}
 
 	// TOOD(dovs) reenable
-	g.generateURLString(m)
-	g.generateQueryString(m)
+	if err := g.generateURLString(m, errPrefix); err != nil {
+		return err
+	}
+	g.generateQueryString(m, errPrefix)
+	g.restFieldsParam()
+	if upload {
+		p("q := baseUrl.Query()")
+		p(`q.Set("uploadType", "resumable")`)
+		p("baseUrl.RawQuery = q.Encode()")
+		p("")
+	}
 	p("// Build HTTP headers from client and context metadata.")
-	p(`headers := buildHeaders(ctx, c.xGoogMetadata, metadata.Pairs("Content-Type", "application/json"))`)
-	if !isHTTPBodyMessage {
-		p("unm := protojson.UnmarshalOptions{AllowPartial: true, DiscardUnknown: true}")
+	p("headers := %s", g.restHeaders())
+	g.restIfMatch(m, info)
+	if upload {
+		p("mo := googleapi.ProcessMediaOptions(mediaOpts)")
+		p("if mo.ContentType != \"\" {")
+		p(`  headers.Set("X-Upload-Content-Type", mo.ContentType)`)
+		p("}")
+	}
+	if upload {
+		p("var session string")
+	}
+	if media {
+		p("var resp io.ReadCloser")
+	} else {
+		p("resp := &%s.%s{}", outSpec.Name, outType.GetName())
+	}
+	if withResponse {
+		p("var httpResp *http.Response")
+	}
+	var etagField *descriptor.FieldDescriptorProto
+	if !media && !upload && !isHTTPBodyMessage {
+		etagField = g.restETagField(m.GetOutputType())
+	}
+	readETag := etagField != nil
+	if readETag {
+		p("var etag string")
 	}
-	p("resp := &%s.%s{}", outSpec.Name, outType.GetName())
 	p("e := gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
-	p(`  httpReq, err := http.NewRequest("%s", baseUrl.String(), %s)`, verb, body)
+	// The body of a Media variant is read after the attempt has returned.
+	if !media {
+		g.restAttemptTimeout()
+	}
+	p(`  httpReq, err := http.NewRequestWithContext(ctx, "%s", baseUrl.String(), %s)`, verb, body)
 	p("  if err != nil {")
 	p("      return err")
 	p("  }")
-	p("  httpReq = httpReq.WithContext(ctx)")
 	p("  httpReq.Header = headers")
+	p("  setServerTimeout(ctx, httpReq.Header)")
+	g.restInterceptRequest()
+	g.restLogRequest()
 	p("")
-	p("  httpRsp, err := c.httpClient.Do(httpReq)")
+	g.restDo(m)
 	p("  if err != nil{")
-	p("   return err")
+	p("   return maybeTransient(err)")
 	p("  }")
+	g.restInterceptResponse()
+	if upload {
+		// Only the session is created in the retried closure. The media is
+		// read once, so its chunks cannot be replayed by gax.Invoke.
+		p("  defer httpRsp.Body.Close()")
+		p("")
+		p("  if err = googleapi.CheckResponse(httpRsp); err != nil {")
+		p("    return maybeAPIError(err)")
+		p("  }")
+		p(`  session = httpRsp.Header.Get("Location")`)
+		p("  return nil")
+		p("}, opts...)")
+		p("if e != nil {")
+		p("  return nil, e")
+		p("}")
+		p("")
+		p("// The chunks carry the x-goog headers of the session request, but not its")
+		p("// JSON Content-Type.")
+		p("buf, err := uploadMedia(ctx, c.httpClient, session, buildHeaders(ctx, c.xGoogMetadata), media, mo.ChunkSize)")
+		p("if err != nil {")
+		p("  return nil, err")
+		p("}")
+		p("if len(buf) == 0 {")
+		p("  return resp, nil")
+		p("}")
+		p("if err := unmarshalOpts.Unmarshal(buf, resp); err != nil {")
+		p("  return nil, maybeUnknownEnum(err)")
+		p("}")
+		p("return resp, nil")
+		p("}")
+
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
+		g.imports[inSpec] = true
+		g.imports[outSpec] = true
+		return nil
+	}
+	if media {
+		// The body is handed to the caller, so it is only closed here if
+		// the response is an error.
+		p("")
+		p("  if err = googleapi.CheckResponse(httpRsp); err != nil {")
+		p("    httpRsp.Body.Close()")
+		p("    return maybeAPIError(err)")
+		p("  }")
+		p("  resp = httpRsp.Body")
+		p("  return nil")
+		p("}, opts...)")
+		p("if e != nil {")
+		p("  return nil, e")
+		p("}")
+		p("return resp, nil")
+		p("}")
+
+		g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
+		g.imports[inSpec] = true
+		return nil
+	}
 	p("  defer httpRsp.Body.Close()")
+	if withResponse {
+		p("  httpResp = httpRsp")
+	}
 	p("")
 	p("  if err = googleapi.CheckResponse(httpRsp); err != nil {")
-	p("    return err")
+	p("    return maybeAPIError(err)")
 	p("  }")
 	p("")
-	p("  buf, err := ioutil.ReadAll(httpRsp.Body)")
+	if readETag {
+		p(`  etag = httpRsp.Header.Get("ETag")`)
+	}
+	if !isHTTPBodyMessage {
+		g.restNoContent()
+	}
+	p("  buf, err := %s", g.restReadBody())
 	p("  if err != nil {")
 	p("    return err")
 	p("  }")
@@ -862,8 +2906,16 @@ func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescripto
 		p(`if headers := httpRsp.Header; len(headers["Content-Type"]) > 0 {`)
 		p(`  resp.ContentType = headers["Content-Type"][0]`)
 		p("}")
+		p("")
+		p("return nil")
 	} else {
-		p("if err := unm.Unmarshal(buf, resp); err != nil {")
+		// Some gateways reply 200 with no body for an all-default message,
+		// which protojson rejects as invalid JSON.
+		p("if len(buf) == 0 {")
+		p("  return nil")
+		p("}")
+		p("")
+		p("if err := unmarshalOpts.Unmarshal(buf, resp); err != nil {")
 		p("  return maybeUnknownEnum(err)")
 		p("}")
 		p("")
@@ -871,19 +2923,50 @@ func (g *generator) unaryRESTCall(servName string, m *descriptor.MethodDescripto
 	}
 	p("}, opts...)")
 	p("if e != nil {")
-	p("  return nil, e")
+	if withResponse {
+		// Unsuccessful responses carry headers too, so return them with the error.
+		p("  return nil, httpResp, e")
+	} else {
+		p("  return %se", errPrefix)
+	}
 	p("}")
+	if readETag {
+		// The etag of the body, if any, is the one of the resource.
+		etag := "unquoteETag(etag)"
+		if etagField.GetProto3Optional() {
+			etag = fmt.Sprintf("proto.String(%s)", etag)
+			g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/proto"}] = true
+		}
+		p(`if resp.GetEtag() == "" {`)
+		p("  resp.Etag = %s", etag)
+		p("}")
+	}
 	ret := "return resp, nil"
-	if isCustomOp {
+	if withResponse {
+		ret = "return resp, httpResp, nil"
+	} else if page {
+		ret = "return resp, resp.GetNextPageToken(), nil"
+	} else if isCustomOp {
+		// Only a successful response is wrapped in an operation. The error
+		// of a failed call, e.g. the *apierror.APIError of a 4xx, has
+		// already been returned as is.
 		opVar := "op"
 		g.customOpInit("resp", "req", opVar, inType.(*descriptor.DescriptorProto), g.customOpService(m))
 		ret = fmt.Sprintf("return %s, nil", opVar)
+	} else if isLRO {
+		// The operation is polled with the GetOperation binding of the
+		// Operations mixin, filled in with the name returned by the call.
+		p("override := fmt.Sprintf(%q, resp.GetName())", g.getOperationPathOverride())
+		p("op := &%s{", lroTypeName(m.GetName()))
+		p("  lro: longrunning.InternalNewOperation(*c.LROClient, resp),")
+		p("  pollPath: override,")
+		p("}")
+		ret = "return op, nil"
 	}
 	p(ret)
 	p("}")
 
 	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/api/googleapi"}] = true
-	g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/encoding/protojson"}] = true
 	g.imports[inSpec] = true
 	g.imports[outSpec] = true
 	return nil
@@ -15,21 +15,40 @@
 package gengapic
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"net/url"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	plugin "github.com/golang/protobuf/protoc-gen-go/plugin"
 	"github.com/google/go-cmp/cmp"
+	conf "github.com/googleapis/gapic-generator-go/internal/grpc_service_config"
 	"github.com/googleapis/gapic-generator-go/internal/pbinfo"
 	"github.com/googleapis/gapic-generator-go/internal/txtdiff"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/genproto/googleapis/cloud/extendedops"
+	"google.golang.org/genproto/googleapis/longrunning"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protodesc"
 	"google.golang.org/protobuf/runtime/protoiface"
+	duration "google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 // Note: the fields parameter contains the names of _all_ the request message's fields,
@@ -72,6 +91,7 @@ func setupMethod(g *generator, url, body string, fields []string) (*descriptor.M
 	fds := []*descriptor.FileDescriptorProto{
 		{
 			Package:     proto.String("identify"),
+			Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/identifypb;identifypb")},
 			Service:     []*descriptor.ServiceDescriptorProto{srv},
 			MessageType: []*descriptor.DescriptorProto{msg},
 		},
@@ -139,6 +159,18 @@ func TestPathParams(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "custom_verb",
+			url:    "/kingdom/{kingdom=kingdoms/*}:cancel",
+			fields: []string{"kingdom", "mass_kg"},
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"kingdom": {
+					Name:   proto.String("kingdom"),
+					Number: proto.Int32(int32(0)),
+					Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+				},
+			},
+		},
 		{
 			name:   "fields_subset_of_params",
 			url:    "/kingdom/{kingdom}/phylum/{phylum}/class/{class}",
@@ -219,6 +251,18 @@ func TestQueryParams(t *testing.T) {
 				},
 			},
 		},
+		{
+			name:   "custom_verb",
+			url:    "/kingdom/{kingdom=kingdoms/*}:cancel",
+			fields: []string{"kingdom", "mass_kg"},
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"mass_kg": {
+					Name:   proto.String("mass_kg"),
+					Number: proto.Int32(int32(1)),
+					Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+				},
+			},
+		},
 	} {
 		mthd, err := setupMethod(&g, tst.url, tst.body, tst.fields)
 		if err != nil {
@@ -232,425 +276,4530 @@ func TestQueryParams(t *testing.T) {
 	}
 }
 
-func TestLeafFields(t *testing.T) {
+func TestQueryParamsNestedBody(t *testing.T) {
 	var g generator
-	g.apiName = "Awesome Mollusc API"
-	g.imports = map[pbinfo.ImportSpec]bool{}
-	g.opts = &options{transports: []transport{rest}}
 
-	basicMsg := &descriptor.DescriptorProto{
-		Name: proto.String("Clam"),
+	mantle := &descriptor.DescriptorProto{
+		Name: proto.String("Mantle"),
 		Field: []*descriptor.FieldDescriptorProto{
-			{
-				Name:   proto.String("mass_kg"),
-				Number: proto.Int32(int32(0)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
-			},
-			{
-				Name:   proto.String("saltwater_p"),
-				Number: proto.Int32(int32(1)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_BOOL),
-			},
+			{Name: proto.String("mass_kg"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
 		},
 	}
-
-	innermostMsg := &descriptor.DescriptorProto{
-		Name: proto.String("Chromatophore"),
+	squid := &descriptor.DescriptorProto{
+		Name: proto.String("Squid"),
 		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("length_cm"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
 			{
-				Name:   proto.String("color_code"),
-				Number: proto.Int32(int32(0)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+				Name:     proto.String("mantle"),
+				Number:   proto.Int32(2),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".identify.Mantle"),
 			},
 		},
 	}
-	nestedMsg := &descriptor.DescriptorProto{
-		Name: proto.String("Mantle"),
+	sibling := &descriptor.FieldDescriptorProto{Name: proto.String("count"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("CreateSquidRequest"),
 		Field: []*descriptor.FieldDescriptorProto{
 			{
-				Name:   proto.String("mass_kg"),
-				Number: proto.Int32(int32(0)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
-			},
-			{
-				Name:     proto.String("chromatophore"),
-				Number:   proto.Int32(int32(1)),
+				Name:     proto.String("squid"),
+				Number:   proto.Int32(1),
 				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
-				TypeName: proto.String(".animalia.mollusca.Chromatophore"),
+				TypeName: proto.String(".identify.Squid"),
 			},
+			sibling,
 		},
 	}
-	complexMsg := &descriptor.DescriptorProto{
-		Name: proto.String("Squid"),
-		Field: []*descriptor.FieldDescriptorProto{
-			{
-				Name:   proto.String("length_m"),
-				Number: proto.Int32(int32(0)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
-			},
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateSquid"),
+		InputType:  proto.String(".identify.CreateSquidRequest"),
+		OutputType: proto.String(".identify.Squid"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "squid",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/squids",
+		},
+	})
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
 			{
-				Name:     proto.String("mantle"),
-				Number:   proto.Int32(int32(1)),
-				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
-				TypeName: proto.String(".animalia.mollusca.Mantle"),
+				Package: proto.String("identify"),
+				Options: &descriptor.FileOptions{GoPackage: proto.String("path/to/identifypb;identifypb")},
+				Service: []*descriptor.ServiceDescriptorProto{
+					{Name: proto.String("SquidService"), Method: []*descriptor.MethodDescriptorProto{mthd}},
+				},
+				MessageType: []*descriptor.DescriptorProto{mantle, squid, req},
 			},
 		},
+	})
+
+	// Neither the body field nor any leaf of its subtree, e.g.
+	// squid.mantle.mass_kg, may be sent as a query param.
+	want := map[string]*descriptor.FieldDescriptorProto{"count": sibling}
+	if diff := cmp.Diff(g.queryParams(mthd), want, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("TestQueryParamsNestedBody: got(-),want(+):\n%s", diff)
 	}
+}
 
-	recursiveMsg := &descriptor.DescriptorProto{
-		// Usually it's turtles all the way down, but here it's whelks
-		Name: proto.String("Whelk"),
-		Field: []*descriptor.FieldDescriptorProto{
-			{
-				Name:   proto.String("mass_kg"),
-				Number: proto.Int32(int32(0)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
-			},
-			{
-				Name:     proto.String("whelk"),
-				Number:   proto.Int32(int32(1)),
-				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
-				TypeName: proto.String(".animalia.mollusca.Whelk"),
-			},
-		},
+func TestQueryParamsAny(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "mass_kg"})
+	if err != nil {
+		t.Fatal(err)
 	}
+	req := g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto)
+	req.Field = append(req.Field, &descriptor.FieldDescriptorProto{
+		Name:     proto.String("evidence"),
+		Number:   proto.Int32(2),
+		Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+		TypeName: proto.String(anyType),
+	})
 
-	overarchingMsg := &descriptor.DescriptorProto{
-		Name: proto.String("Trawl"),
+	// Any is an opaque leaf, neither a query param nor recursed into.
+	got := g.queryParams(mthd)
+	if _, ok := got["mass_kg"]; !ok || len(got) != 1 {
+		t.Errorf("TestQueryParamsAny: got %v, want only mass_kg", got)
+	}
+}
+
+func TestQueryParamsRepeatedMessage(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "mass_kg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.descInfo.Type[".identify.Mantle"] = &descriptor.DescriptorProto{
+		Name: proto.String("Mantle"),
 		Field: []*descriptor.FieldDescriptorProto{
-			{
-				Name:     proto.String("clams"),
-				Number:   proto.Int32(int32(0)),
-				Label:    labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED),
-				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
-				TypeName: proto.String(".animalia.mollusca"),
-			},
-			{
-				Name:   proto.String("mass_kg"),
-				Number: proto.Int32(int32(1)),
-				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
-			},
+			{Name: proto.String("length_cm"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
 		},
 	}
-
-	file := &descriptor.FileDescriptorProto{
-		Package: proto.String("animalia.mollusca"),
-		Options: &descriptor.FileOptions{
-			GoPackage: proto.String("mypackage"),
+	req := g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto)
+	req.Field = append(req.Field,
+		&descriptor.FieldDescriptorProto{
+			Name:     proto.String("mantles"),
+			Number:   proto.Int32(2),
+			Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+			TypeName: proto.String(".identify.Mantle"),
 		},
-		MessageType: []*descriptor.DescriptorProto{
-			basicMsg,
-			innermostMsg,
-			nestedMsg,
-			complexMsg,
-			recursiveMsg,
-			overarchingMsg,
+		&descriptor.FieldDescriptorProto{
+			Name:     proto.String("lengths"),
+			Number:   proto.Int32(3),
+			Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+			TypeName: proto.String(".google.protobuf.Int32Value"),
 		},
+	)
+
+	// Neither a repeated message nor its fields are query params, even when
+	// it is a direct field of the request.
+	got := g.queryParams(mthd)
+	if _, ok := got["mass_kg"]; !ok || len(got) != 1 {
+		t.Errorf("TestQueryParamsRepeatedMessage: got %v, want only mass_kg", got)
 	}
-	req := plugin.CodeGeneratorRequest{
-		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
-		ProtoFile: []*descriptor.FileDescriptorProto{file},
+}
+
+func TestGenerateURLStringSingleSegment(t *testing.T) {
+	var outputs []string
+	for _, tmpl := range []string{
+		"/v1/{parent=kingdoms/*}/phyla/{phylum}:classify",
+		"/v1/{parent=kingdoms/*}/phyla/{phylum=*}:classify",
+	} {
+		var g generator
+		mthd, err := setupMethod(&g, tmpl, "", []string{"parent", "phylum"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+			f.Type = typep(descriptor.FieldDescriptorProto_TYPE_STRING)
+		}
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		if err := g.generateURLString(mthd, "nil, "); err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, g.pt.String())
 	}
-	g.init(&req)
+
+	// {phylum} is shorthand for {phylum=*}, so both are one escaped segment.
+	if outputs[0] != outputs[1] {
+		t.Errorf("TestGenerateURLStringSingleSegment: {phylum} and {phylum=*} differ, got:\n%s\nand:\n%s", outputs[0], outputs[1])
+	}
+	want := `baseUrl.RawPath = baseUrl.EscapedPath() + fmt.Sprintf("/v1/%v/phyla/%v:classify", strings.ReplaceAll(url.PathEscape(req.GetParent()), "%2F", "/"), url.PathEscape(req.GetPhylum()))`
+	if !strings.Contains(outputs[0], want) {
+		t.Errorf("TestGenerateURLStringSingleSegment: missing %q, got:\n%s", want, outputs[0])
+	}
+
+	// Replay the generated code: a "/" stays in a single segment, but not in
+	// a multi-segment variable.
+	baseUrl, err := url.Parse("https://linnaean.taxonomy.com/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, phylum := "kingdoms/animal ia", "mol/lusca"
+	baseUrl.RawPath = baseUrl.EscapedPath() + fmt.Sprintf("/v1/%v/phyla/%v:classify", strings.ReplaceAll(url.PathEscape(parent), "%2F", "/"), url.PathEscape(phylum))
+	baseUrl.Path += fmt.Sprintf("/v1/%v/phyla/%v:classify", parent, phylum)
+	if got, want := baseUrl.String(), "https://linnaean.taxonomy.com/api/v1/kingdoms/animal%20ia/phyla/mol%2Flusca:classify"; got != want {
+		t.Errorf("TestGenerateURLStringSingleSegment: got URL %q, want %q", got, want)
+	}
+}
+
+func TestGenerateURLStringEncodedLiteral(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/mollusca%2Fgastropoda/{phylum=phyla/*}:classify", "", []string{"phylum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+		f.Type = typep(descriptor.FieldDescriptorProto_TYPE_STRING)
+	}
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.generateURLString(mthd, "nil, "); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The literal is sent as it is, already encoded, and only the variable is
+	// escaped. Path holds the literal unescaped.
+	for _, want := range []string{
+		`baseUrl.RawPath = baseUrl.EscapedPath() + fmt.Sprintf("/v1/mollusca%%2Fgastropoda/%v:classify", strings.ReplaceAll(url.PathEscape(req.GetPhylum()), "%2F", "/"))`,
+		`baseUrl.Path += fmt.Sprintf("/v1/mollusca/gastropoda/%v:classify", req.GetPhylum())`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestGenerateURLStringEncodedLiteral: missing %q, got:\n%s", want, got)
+		}
+	}
+
+	// Replay the generated code.
+	baseUrl, err := url.Parse("https://linnaean.taxonomy.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	phylum := "phyla/snail s"
+	baseUrl.RawPath = baseUrl.EscapedPath() + fmt.Sprintf("/v1/mollusca%%2Fgastropoda/%v:classify", strings.ReplaceAll(url.PathEscape(phylum), "%2F", "/"))
+	baseUrl.Path += fmt.Sprintf("/v1/mollusca/gastropoda/%v:classify", phylum)
+	if got, want := baseUrl.String(), "https://linnaean.taxonomy.com/v1/mollusca%2Fgastropoda/phyla/snail%20s:classify"; got != want {
+		t.Errorf("TestGenerateURLStringEncodedLiteral: got URL %q, want %q", got, want)
+	}
+}
+
+func TestGenerateURLString(t *testing.T) {
+	var g generator
+	g.apiName = "Awesome Mollusc API"
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	g.opts = &options{transports: []transport{rest}}
 
 	for _, tst := range []struct {
-		name           string
-		msg            *descriptor.DescriptorProto
-		expected       map[string]*descriptor.FieldDescriptorProto
-		excludedFields []*descriptor.FieldDescriptorProto
+		name   string
+		url    string
+		fields []string
+		want   string
 	}{
 		{
-			name: "basic_message_test",
-			msg:  basicMsg,
-			expected: map[string]*descriptor.FieldDescriptorProto{
-				"mass_kg":     basicMsg.GetField()[0],
-				"saltwater_p": basicMsg.GetField()[1],
-			},
-		},
-		{
-			name: "complex_message_test",
-			msg:  complexMsg,
-			expected: map[string]*descriptor.FieldDescriptorProto{
-				"length_m":                        complexMsg.GetField()[0],
-				"mantle.mass_kg":                  nestedMsg.GetField()[0],
-				"mantle.chromatophore.color_code": innermostMsg.GetField()[0],
-			},
-		},
-		{
-			name: "excluded_message_test",
-			msg:  complexMsg,
-			expected: map[string]*descriptor.FieldDescriptorProto{
-				"length_m":       complexMsg.GetField()[0],
-				"mantle.mass_kg": nestedMsg.GetField()[0],
-			},
-			excludedFields: []*descriptor.FieldDescriptorProto{
-				nestedMsg.GetField()[1],
-			},
+			name:   "no_params",
+			url:    "/kingdom",
+			fields: []string{"kingdom"},
+			want:   `baseUrl.Path += fmt.Sprintf("/kingdom")`,
 		},
 		{
-			name: "recursive_message_test",
-			msg:  recursiveMsg,
-			expected: map[string]*descriptor.FieldDescriptorProto{
-				"mass_kg":       recursiveMsg.GetField()[0],
-				"whelk.mass_kg": recursiveMsg.GetField()[0],
-			},
+			name:   "path_params",
+			url:    "/kingdom/{kingdom}/phylum/{phylum=phyla/*}",
+			fields: []string{"kingdom", "phylum"},
+			want:   `baseUrl.Path += fmt.Sprintf("/kingdom/%v/phylum/%v", req.GetKingdom(), req.GetPhylum())`,
 		},
 		{
-			name: "repeated_message_test",
-			msg:  overarchingMsg,
-			expected: map[string]*descriptor.FieldDescriptorProto{
-				"mass_kg": overarchingMsg.GetField()[1],
-			},
+			name:   "custom_verb",
+			url:    "/kingdom/{kingdom=kingdoms/*}:cancel",
+			fields: []string{"kingdom"},
+			want:   `baseUrl.Path += fmt.Sprintf("/kingdom/%v:cancel", req.GetKingdom())`,
 		},
 	} {
-		actual := g.getLeafs(tst.msg, tst.excludedFields...)
-		if diff := cmp.Diff(actual, tst.expected, cmp.Comparer(proto.Equal)); diff != "" {
-			t.Errorf("test %s, got(-),want(+):\n%s", tst.name, diff)
+		mthd, err := setupMethod(&g, tst.url, "", tst.fields)
+		if err != nil {
+			t.Errorf("test %s setup got error: %s", tst.name, err.Error())
+		}
+
+		if err := g.generateURLString(mthd, "nil, "); err != nil {
+			t.Errorf("test %s got error: %v", tst.name, err)
+		}
+		if got := g.pt.String(); !strings.Contains(got, tst.want) {
+			t.Errorf("test %s, got:\n%s\nwant line:\n%s", tst.name, got, tst.want)
 		}
+		g.reset()
 	}
 }
 
-func TestGenRestMethod(t *testing.T) {
-	pkg := "google.cloud.foo.v1"
+func TestGenerateURLStringNested(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
 
-	sizeOpts := &descriptor.FieldOptions{}
-	proto.SetExtension(sizeOpts, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_REQUIRED})
-	sizeField := &descriptor.FieldDescriptorProto{
-		Name:    proto.String("size"),
-		Type:    descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
-		Options: sizeOpts,
+	id := &descriptor.FieldDescriptorProto{
+		Name:   proto.String("id"),
+		Number: proto.Int32(1),
+		Type:   typep(descriptor.FieldDescriptorProto_TYPE_STRING),
 	}
-	otherField := &descriptor.FieldDescriptorProto{
-		Name:           proto.String("other"),
-		Type:           descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
-		Proto3Optional: proto.Bool(true),
+	parent := &descriptor.DescriptorProto{
+		Name:  proto.String("Parent"),
+		Field: []*descriptor.FieldDescriptorProto{id},
 	}
-
-	foo := &descriptor.DescriptorProto{
-		Name:  proto.String("Foo"),
-		Field: []*descriptor.FieldDescriptorProto{sizeField, otherField},
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("CreateChildRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("parent"),
+				Number:   proto.Int32(1),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".identify.Parent"),
+			},
+		},
 	}
-	foofqn := fmt.Sprintf(".%s.Foo", pkg)
-
-	pageSizeField := &descriptor.FieldDescriptorProto{
-		Name: proto.String("page_size"),
-		Type: descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateChild"),
+		InputType:  proto.String(".identify.CreateChildRequest"),
+		OutputType: proto.String(".identify.Parent"),
+		Options:    &descriptor.MethodOptions{},
 	}
-	pageTokenField := &descriptor.FieldDescriptorProto{
-		Name: proto.String("page_token"),
-		Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/parents/{parent.id}/children",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("ChildService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("identify"),
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{parent, req},
+			},
+		},
+	})
+
+	want := map[string]*descriptor.FieldDescriptorProto{"parent.id": id}
+	if diff := cmp.Diff(g.pathParams(mthd), want, cmp.Comparer(proto.Equal)); diff != "" {
+		t.Errorf("TestGenerateURLStringNested: path params got(-),want(+):\n%s", diff)
+	}
+	if f := g.lookupField(mthd.GetInputType(), "parent.name"); f != nil {
+		t.Errorf("TestGenerateURLStringNested: lookupField(parent.name) = %v, want nil", f)
+	}
+
+	// Getters on nil messages return zero values, so an unset parent yields
+	// an empty segment rather than a panic.
+	if err := g.generateURLString(mthd, "nil, "); err != nil {
+		t.Fatal(err)
+	}
+	wantURL := `baseUrl.Path += fmt.Sprintf("/v1/parents/%v/children", req.GetParent().GetId())`
+	if got := g.pt.String(); !strings.Contains(got, wantURL) {
+		t.Errorf("TestGenerateURLStringNested: got:\n%s\nwant line:\n%s", got, wantURL)
+	}
+}
+
+func TestGenerateQueryStringInt64(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+
+	int64Value := &descriptor.DescriptorProto{
+		Name: proto.String("Int64Value"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("value"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT64)},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("ListClamsRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("mass_mg"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT64)},
+			{Name: proto.String("depth_delta"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_SINT64)},
+			{Name: proto.String("shell_count"), Number: proto.Int32(3), Type: typep(descriptor.FieldDescriptorProto_TYPE_FIXED64)},
+			{Name: proto.String("min_age"), Number: proto.Int32(4), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".google.protobuf.Int64Value")},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ListClams"),
+		InputType:  proto.String(".identify.ListClamsRequest"),
+		OutputType: proto.String(emptyType),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/clams",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("ClamService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	fds := []*descriptor.FileDescriptorProto{
+		{
+			Package:     proto.String("google.protobuf"),
+			MessageType: []*descriptor.DescriptorProto{int64Value},
+		},
+		{
+			Package:     proto.String("identify"),
+			Service:     []*descriptor.ServiceDescriptorProto{srv},
+			MessageType: []*descriptor.DescriptorProto{req},
+		},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: fds,
+	})
+
+	g.generateQueryString(mthd, "nil, ")
+	got := g.pt.String()
+
+	// 64-bit integers are formatted as bare numbers, never quoted strings.
+	for _, want := range []string{
+		`params.Add("massMg", fmt.Sprintf("%v", req.GetMassMg()))`,
+		`params.Add("depthDelta", fmt.Sprintf("%v", req.GetDepthDelta()))`,
+		`params.Add("shellCount", fmt.Sprintf("%v", req.GetShellCount()))`,
+		// The wrapper is sent by its bare value whenever it is set, even to zero.
+		`if req.GetMinAge() != nil {`,
+		`params.Add("minAge", fmt.Sprintf("%v", req.GetMinAge().GetValue()))`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestGenerateQueryStringInt64: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "minAge.value") {
+		t.Errorf("TestGenerateQueryStringInt64: wrapper should not be flattened, got:\n%s", got)
+	}
+}
+
+func TestGenRestMethodDeterministic(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+
+	mantle := &descriptor.DescriptorProto{
+		Name: proto.String("Mantle"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("mass_kg"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("tags"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING), Label: labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED)},
+			{Name: proto.String("color"), Number: proto.Int32(3), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING), Proto3Optional: proto.Bool(true)},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("UpdateSquidRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("project"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: proto.String("squid"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: proto.String("mantle"), Number: proto.Int32(3), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".identify.Mantle")},
+			{Name: proto.String("names"), Number: proto.Int32(4), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING), Label: labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED)},
+			{Name: proto.String("depth"), Number: proto.Int32(5), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT64), Proto3Optional: proto.Bool(true)},
+			{Name: proto.String("ink"), Number: proto.Int32(6), Type: typep(descriptor.FieldDescriptorProto_TYPE_BOOL)},
+			{Name: proto.String("zone"), Number: proto.Int32(7), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING), Proto3Optional: proto.Bool(true)},
+			{Name: proto.String("arms"), Number: proto.Int32(8), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32), Label: labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("UpdateSquid"),
+		InputType:  proto.String(".identify.UpdateSquidRequest"),
+		OutputType: proto.String(".identify.Mantle"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/projects/{project}/squids/{squid}",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("SquidService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest,rest-validate-required"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("identify"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/identifypb;identifypb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{mantle, req},
+			},
+		},
+	})
+
+	var want string
+	// Map iteration order is randomized, so a handful of regenerations
+	// would surface any map feeding the output unsorted.
+	for i := 0; i < 20; i++ {
+		g.reset()
+		if err := g.genRESTMethod("Squid", srv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+		if i == 0 {
+			want = got
+			continue
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Fatalf("TestGenRestMethodDeterministic: regeneration %d differs (-got, +want):\n%s", i, diff)
+		}
+	}
+}
+
+func TestQueryParamKey(t *testing.T) {
+	mantle := &descriptor.DescriptorProto{
+		Name: proto.String("Mantle"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("mass_kg"), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("length_cm"), JsonName: proto.String("len"), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("SquidRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("mass_kg"), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("squid_mantle"), JsonName: proto.String("mantle"), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".squid.Mantle")},
+			{Name: proto.String("field_one"), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".squid.Mantle")},
+		},
+	}
+	g := generator{descInfo: pbinfo.Info{Type: map[string]pbinfo.ProtoType{
+		".squid.Mantle":       mantle,
+		".squid.SquidRequest": req,
+	}}}
+
+	for _, tst := range []struct {
+		path, want string
+	}{
+		{path: "mass_kg", want: "massKg"},
+		{path: "field_one.mass_kg", want: "fieldOne.massKg"},
+		// An explicit json_name is used for every field along the path.
+		{path: "squid_mantle.length_cm", want: "mantle.len"},
+		{path: "field_one.length_cm", want: "fieldOne.len"},
+		// Fields missing from the descriptors get the default JSON name.
+		{path: "a.b.c", want: "a.b.c"},
+		{path: "field_one._field_two", want: "fieldOne.FieldTwo"},
+	} {
+		if got := g.queryParamKey(".squid.SquidRequest", tst.path); got != tst.want {
+			t.Errorf("queryParamKey(%q) = %q, want %q", tst.path, got, tst.want)
+		}
+	}
+}
+
+func TestQueryParamsEncodedOnce(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "filter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+		f.Type = typep(descriptor.FieldDescriptorProto_TYPE_STRING)
+	}
+
+	g.generateQueryString(mthd, "nil, ")
+	got := g.pt.String()
+	want := `params.Add("filter", fmt.Sprintf("%v", req.GetFilter()))`
+	if !strings.Contains(got, want) || strings.Contains(got, "Escape") {
+		t.Fatalf("TestQueryParamsEncodedOnce: want raw value added with %q, got:\n%s", want, got)
+	}
+
+	// Replay the generated statements: the raw value is escaped exactly once,
+	// so the already escaped %2F in it is escaped again rather than decoded.
+	filter := "name = a&b/c%2Fd"
+	params := url.Values{}
+	params.Add("filter", fmt.Sprintf("%v", filter))
+	baseUrl := &url.URL{Path: "/v1/kingdom/animalia"}
+	baseUrl.RawQuery = params.Encode()
+	if got, want := baseUrl.RawQuery, "filter=name+%3D+a%26b%2Fc%252Fd"; got != want {
+		t.Errorf("TestQueryParamsEncodedOnce: query = %q, want %q", got, want)
+	}
+	if got := baseUrl.Query().Get("filter"); got != filter {
+		t.Errorf("TestQueryParamsEncodedOnce: decoded filter = %q, want %q", got, filter)
+	}
+}
+
+func TestQueryParamsOptionalZero(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "number"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.lookupField(mthd.GetInputType(), "number").Proto3Optional = proto.Bool(true)
+
+	g.generateQueryString(mthd, "nil, ")
+	got := g.pt.String()
+	// The guard checks presence rather than the value, which would drop an
+	// explicit zero.
+	for _, want := range []string{
+		"if req != nil && req.Number != nil {",
+		`params.Add("number", fmt.Sprintf("%v", req.GetNumber()))`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestQueryParamsOptionalZero: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "req.GetNumber() != 0") {
+		t.Errorf("TestQueryParamsOptionalZero: want presence guard, got:\n%s", got)
+	}
+
+	// Replay the generated statements on a message with the same presence
+	// semantics, with the field explicitly set to zero and unset.
+	for _, tst := range []struct {
+		req  *descriptor.FieldDescriptorProto
+		want string
+	}{
+		{req: &descriptor.FieldDescriptorProto{Number: proto.Int32(0)}, want: "number=0"},
+		{req: &descriptor.FieldDescriptorProto{}, want: ""},
+	} {
+		req := tst.req
+		params := url.Values{}
+		if req != nil && req.Number != nil {
+			params.Add("number", fmt.Sprintf("%v", req.GetNumber()))
+		}
+		if got := params.Encode(); got != tst.want {
+			t.Errorf("TestQueryParamsOptionalZero(%v): query = %q, want %q", req, got, tst.want)
+		}
+	}
+}
+
+func TestLeafFields(t *testing.T) {
+	var g generator
+	g.apiName = "Awesome Mollusc API"
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	g.opts = &options{transports: []transport{rest}}
+
+	basicMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Clam"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("mass_kg"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+			{
+				Name:   proto.String("saltwater_p"),
+				Number: proto.Int32(int32(1)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_BOOL),
+			},
+		},
+	}
+
+	innermostMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Chromatophore"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("color_code"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+		},
+	}
+	nestedMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Mantle"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("mass_kg"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+			{
+				Name:     proto.String("chromatophore"),
+				Number:   proto.Int32(int32(1)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".animalia.mollusca.Chromatophore"),
+			},
+		},
+	}
+	complexMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Squid"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("length_m"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+			{
+				Name:     proto.String("mantle"),
+				Number:   proto.Int32(int32(1)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".animalia.mollusca.Mantle"),
+			},
+		},
+	}
+
+	recursiveMsg := &descriptor.DescriptorProto{
+		// Usually it's turtles all the way down, but here it's whelks
+		Name: proto.String("Whelk"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("mass_kg"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+			{
+				Name:     proto.String("whelk"),
+				Number:   proto.Int32(int32(1)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".animalia.mollusca.Whelk"),
+			},
+		},
+	}
+
+	overarchingMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Trawl"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("clams"),
+				Number:   proto.Int32(int32(0)),
+				Label:    labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".animalia.mollusca"),
+			},
+			{
+				Name:   proto.String("mass_kg"),
+				Number: proto.Int32(int32(1)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+		},
+	}
+
+	dynamicMsg := &descriptor.DescriptorProto{
+		Name: proto.String("Octopus"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:   proto.String("mass_kg"),
+				Number: proto.Int32(int32(0)),
+				Type:   typep(descriptor.FieldDescriptorProto_TYPE_INT32),
+			},
+			{
+				Name:     proto.String("attributes"),
+				Number:   proto.Int32(int32(1)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(structType),
+			},
+			{
+				Name:     proto.String("markings"),
+				Number:   proto.Int32(int32(2)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(listValueType),
+			},
+			{
+				Name:     proto.String("mood"),
+				Number:   proto.Int32(int32(3)),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(valueType),
+			},
+		},
+	}
+
+	file := &descriptor.FileDescriptorProto{
+		Package: proto.String("animalia.mollusca"),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("mypackage"),
+		},
+		MessageType: []*descriptor.DescriptorProto{
+			basicMsg,
+			innermostMsg,
+			nestedMsg,
+			complexMsg,
+			recursiveMsg,
+			overarchingMsg,
+			dynamicMsg,
+		},
+	}
+	req := plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{file},
+	}
+	g.init(&req)
+
+	for _, tst := range []struct {
+		name           string
+		msg            *descriptor.DescriptorProto
+		expected       map[string]*descriptor.FieldDescriptorProto
+		excludedFields []*descriptor.FieldDescriptorProto
+	}{
+		{
+			name: "basic_message_test",
+			msg:  basicMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"mass_kg":     basicMsg.GetField()[0],
+				"saltwater_p": basicMsg.GetField()[1],
+			},
+		},
+		{
+			name: "complex_message_test",
+			msg:  complexMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"length_m":                        complexMsg.GetField()[0],
+				"mantle.mass_kg":                  nestedMsg.GetField()[0],
+				"mantle.chromatophore.color_code": innermostMsg.GetField()[0],
+			},
+		},
+		{
+			name: "excluded_message_test",
+			msg:  complexMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"length_m":       complexMsg.GetField()[0],
+				"mantle.mass_kg": nestedMsg.GetField()[0],
+			},
+			excludedFields: []*descriptor.FieldDescriptorProto{
+				nestedMsg.GetField()[1],
+			},
+		},
+		{
+			name: "recursive_message_test",
+			msg:  recursiveMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"mass_kg":       recursiveMsg.GetField()[0],
+				"whelk.mass_kg": recursiveMsg.GetField()[0],
+			},
+		},
+		{
+			name: "repeated_message_test",
+			msg:  overarchingMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"mass_kg": overarchingMsg.GetField()[1],
+			},
+		},
+		{
+			name: "dynamic_json_message_test",
+			msg:  dynamicMsg,
+			expected: map[string]*descriptor.FieldDescriptorProto{
+				"mass_kg": dynamicMsg.GetField()[0],
+			},
+		},
+	} {
+		actual := g.getLeafs(tst.msg, tst.excludedFields...)
+		if diff := cmp.Diff(actual, tst.expected, cmp.Comparer(proto.Equal)); diff != "" {
+			t.Errorf("test %s, got(-),want(+):\n%s", tst.name, diff)
+		}
+	}
+}
+
+func TestGenRestMethod(t *testing.T) {
+	pkg := "google.cloud.foo.v1"
+
+	sizeOpts := &descriptor.FieldOptions{}
+	proto.SetExtension(sizeOpts, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_REQUIRED})
+	sizeField := &descriptor.FieldDescriptorProto{
+		Name:    proto.String("size"),
+		Type:    descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+		Options: sizeOpts,
+	}
+	otherField := &descriptor.FieldDescriptorProto{
+		Name:           proto.String("other"),
+		Type:           descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Proto3Optional: proto.Bool(true),
+	}
+
+	foo := &descriptor.DescriptorProto{
+		Name:  proto.String("Foo"),
+		Field: []*descriptor.FieldDescriptorProto{sizeField, otherField},
+	}
+	foofqn := fmt.Sprintf(".%s.Foo", pkg)
+
+	pageSizeField := &descriptor.FieldDescriptorProto{
+		Name: proto.String("page_size"),
+		Type: descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+	}
+	pageTokenField := &descriptor.FieldDescriptorProto{
+		Name: proto.String("page_token"),
+		Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
 	}
 	pagedFooReq := &descriptor.DescriptorProto{
 		Name:  proto.String("PagedFooRequest"),
 		Field: []*descriptor.FieldDescriptorProto{pageSizeField, pageTokenField},
 	}
-	pagedFooReqFQN := fmt.Sprintf(".%s.PagedFooRequest", pkg)
+	pagedFooReqFQN := fmt.Sprintf(".%s.PagedFooRequest", pkg)
+
+	maxResultsFooReq := &descriptor.DescriptorProto{
+		Name: proto.String("MaxResultsFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:           proto.String("max_results"),
+				Type:           descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+				Proto3Optional: proto.Bool(true),
+			},
+			{
+				Name:           proto.String("page_token"),
+				Type:           descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Proto3Optional: proto.Bool(true),
+			},
+		},
+	}
+	maxResultsFooReqFQN := fmt.Sprintf(".%s.MaxResultsFooRequest", pkg)
+
+	foosField := &descriptor.FieldDescriptorProto{
+		Name:     proto.String("foos"),
+		Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String(foofqn),
+		Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+	}
+	nextPageTokenField := &descriptor.FieldDescriptorProto{
+		Name: proto.String("next_page_token"),
+		Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+	}
+	pagedFooRes := &descriptor.DescriptorProto{
+		Name:  proto.String("PagedFooResponse"),
+		Field: []*descriptor.FieldDescriptorProto{foosField, nextPageTokenField},
+	}
+	pagedFooResFQN := fmt.Sprintf(".%s.PagedFooResponse", pkg)
+
+	batchFoosField := &descriptor.FieldDescriptorProto{
+		Name:     proto.String("foos"),
+		Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String(foofqn),
+		Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+	}
+	batchFooReq := &descriptor.DescriptorProto{
+		Name:  proto.String("BatchFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{batchFoosField},
+	}
+	batchFooReqFQN := fmt.Sprintf(".%s.BatchFooRequest", pkg)
+
+	requiredOpts := &descriptor.FieldOptions{}
+	proto.SetExtension(requiredOpts, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_REQUIRED})
+	validatedFooReq := &descriptor.DescriptorProto{
+		Name: proto.String("ValidatedFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:    proto.String("name"),
+				Type:    descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Options: requiredOpts,
+			},
+			{
+				Name:    proto.String("filter"),
+				Type:    descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Options: requiredOpts,
+			},
+			{
+				Name:    proto.String("count"),
+				Type:    descriptor.FieldDescriptorProto_TYPE_INT32.Enum(),
+				Options: requiredOpts,
+			},
+			{
+				Name: proto.String("order_by"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+		},
+	}
+	validatedFooReqFQN := fmt.Sprintf(".%s.ValidatedFooRequest", pkg)
+
+	fieldMaskFile := protodesc.ToFileDescriptorProto(fieldmaskpb.File_google_protobuf_field_mask_proto)
+	fieldMask := fieldMaskFile.GetMessageType()[0]
+	updateFooReq := &descriptor.DescriptorProto{
+		Name: proto.String("UpdateFooRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("foo"),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(foofqn),
+			},
+			{
+				Name:     proto.String("update_mask"),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(fieldMaskType),
+			},
+		},
+	}
+	updateFooReqFQN := fmt.Sprintf(".%s.UpdateFooRequest", pkg)
+
+	barPkg := "google.cloud.bar.v1"
+	bar := &descriptor.DescriptorProto{
+		Name: proto.String("Bar"),
+	}
+	barfqn := fmt.Sprintf(".%s.Bar", barPkg)
+	barFile := &descriptor.FileDescriptorProto{
+		Package: proto.String(barPkg),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("google.golang.org/genproto/cloud/bar/v1;bar"),
+		},
+	}
+
+	barsEntry := &descriptor.DescriptorProto{
+		Name:    proto.String("BarsEntry"),
+		Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name: proto.String("key"),
+				Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+			},
+			{
+				Name:     proto.String("value"),
+				Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: proto.String(barfqn),
+			},
+		},
+	}
+	barsField := &descriptor.FieldDescriptorProto{
+		Name:     proto.String("bars"),
+		Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+		TypeName: proto.String(fmt.Sprintf(".%s.MapPagedFooResponse.BarsEntry", pkg)),
+		Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+	}
+	mapPagedFooRes := &descriptor.DescriptorProto{
+		Name:       proto.String("MapPagedFooResponse"),
+		Field:      []*descriptor.FieldDescriptorProto{barsField, nextPageTokenField},
+		NestedType: []*descriptor.DescriptorProto{barsEntry},
+	}
+	mapPagedFooResFQN := fmt.Sprintf(".%s.MapPagedFooResponse", pkg)
+
+	nameOpts := &descriptor.FieldOptions{}
+	proto.SetExtension(nameOpts, extendedops.E_OperationField, extendedops.OperationResponseMapping_NAME)
+	nameField := &descriptor.FieldDescriptorProto{
+		Name:    proto.String("name"),
+		Type:    descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+		Options: nameOpts,
+	}
+	op := &descriptor.DescriptorProto{
+		Name:  proto.String("Operation"),
+		Field: []*descriptor.FieldDescriptorProto{nameField},
+	}
+	opfqn := fmt.Sprintf(".%s.Operation", pkg)
+
+	opRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(opRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foo",
+		},
+	})
+	proto.SetExtension(opRPCOpt, extendedops.E_OperationService, "FooOperationService")
+
+	opRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CustomOp"),
+		InputType:  proto.String(foofqn),
+		OutputType: proto.String(opfqn),
+		Options:    opRPCOpt,
+	}
+
+	emptyRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(emptyRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Delete{
+			Delete: "/v1/foo",
+		},
+	})
+
+	emptyRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("EmptyRPC"),
+		InputType:  proto.String(foofqn),
+		OutputType: proto.String(emptyType),
+		Options:    emptyRPCOpt,
+	}
+
+	unaryRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(unaryRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foo",
+		},
+		Body: "*",
+	})
+
+	unaryRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("UnaryRPC"),
+		InputType:  proto.String(foofqn),
+		OutputType: proto.String(foofqn),
+		Options:    unaryRPCOpt,
+	}
+
+	pagingRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(pagingRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foo",
+		},
+	})
+
+	pagingRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("PagingRPC"),
+		InputType:  proto.String(pagedFooReqFQN),
+		OutputType: proto.String(pagedFooResFQN),
+		Options:    pagingRPCOpt,
+	}
+
+	maxResultsPagingRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(maxResultsPagingRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foo:maxResults",
+		},
+	})
+
+	maxResultsPagingRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("MaxResultsPagingRPC"),
+		InputType:  proto.String(maxResultsFooReqFQN),
+		OutputType: proto.String(pagedFooResFQN),
+		Options:    maxResultsPagingRPCOpt,
+	}
+
+	mapPagingRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(mapPagingRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foo:bars",
+		},
+	})
+
+	mapPagingRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("MapPagingRPC"),
+		InputType:  proto.String(pagedFooReqFQN),
+		OutputType: proto.String(mapPagedFooResFQN),
+		Options:    mapPagingRPCOpt,
+	}
+
+	validatedRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(validatedRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/{name=foos/*}",
+		},
+	})
+
+	validatedRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ValidatedRPC"),
+		InputType:  proto.String(validatedFooReqFQN),
+		OutputType: proto.String(foofqn),
+		Options:    validatedRPCOpt,
+	}
+
+	updateRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(updateRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/foo",
+		},
+		Body: "foo",
+	})
+
+	updateRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("UpdateRPC"),
+		InputType:  proto.String(updateFooReqFQN),
+		OutputType: proto.String(foofqn),
+		Options:    updateRPCOpt,
+	}
+
+	pathBodyRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(pathBodyRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/foo/{size}",
+		},
+		Body: "*",
+	})
+
+	pathBodyRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("PathBodyRPC"),
+		InputType:  proto.String(foofqn),
+		OutputType: proto.String(foofqn),
+		Options:    pathBodyRPCOpt,
+	}
+
+	repeatedBodyRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(repeatedBodyRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foo:batch",
+		},
+		Body: "foos",
+	})
+
+	repeatedBodyRPC := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("RepeatedBodyRPC"),
+		InputType:  proto.String(batchFooReqFQN),
+		OutputType: proto.String(foofqn),
+		Options:    repeatedBodyRPCOpt,
+	}
+
+	serverStreamRPCOpt := &descriptor.MethodOptions{}
+	proto.SetExtension(serverStreamRPCOpt, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foo:stream",
+		},
+		Body: "*",
+	})
+
+	serverStreamRPC := &descriptor.MethodDescriptorProto{
+		Name:            proto.String("ServerStreamRPC"),
+		InputType:       proto.String(foofqn),
+		OutputType:      proto.String(foofqn),
+		ServerStreaming: proto.Bool(true),
+		Options:         serverStreamRPCOpt,
+	}
+
+	s := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooService"),
+	}
+	opS := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooOperationService"),
+	}
+
+	f := &descriptor.FileDescriptorProto{
+		Package: proto.String(pkg),
+		Options: &descriptor.FileOptions{
+			GoPackage: proto.String("google.golang.org/genproto/cloud/foo/v1;foo"),
+		},
+		Service: []*descriptor.ServiceDescriptorProto{s, opS},
+	}
+
+	g := &generator{
+		aux: &auxTypes{
+			customOp: &customOp{
+				message: op,
+			},
+			iters: map[string]*iterType{},
+		},
+		opts: &options{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{
+			s: opS,
+		},
+		descInfo: pbinfo.Info{
+			ParentFile: map[protoiface.MessageV1]*descriptor.FileDescriptorProto{
+				op:               f,
+				opS:              f,
+				opRPC:            f,
+				foo:              f,
+				s:                f,
+				pagedFooReq:      f,
+				pagedFooRes:      f,
+				mapPagedFooRes:   f,
+				updateFooReq:     f,
+				fieldMask:        fieldMaskFile,
+				maxResultsFooReq: f,
+				batchFooReq:      f,
+				validatedFooReq:  f,
+				barsEntry:        f,
+				bar:              barFile,
+			},
+			ParentElement: map[pbinfo.ProtoType]pbinfo.ProtoType{
+				opRPC:               s,
+				emptyRPC:            s,
+				unaryRPC:            s,
+				pagingRPC:           s,
+				mapPagingRPC:        s,
+				updateRPC:           s,
+				maxResultsPagingRPC: s,
+				repeatedBodyRPC:     s,
+				pathBodyRPC:         s,
+				validatedRPC:        s,
+				serverStreamRPC:     s,
+				barsEntry:           mapPagedFooRes,
+				nameField:           op,
+				sizeField:           foo,
+				otherField:          foo,
+			},
+			Type: map[string]pbinfo.ProtoType{
+				opfqn:                            op,
+				foofqn:                           foo,
+				emptyType:                        protodesc.ToDescriptorProto((&emptypb.Empty{}).ProtoReflect().Descriptor()),
+				pagedFooReqFQN:                   pagedFooReq,
+				pagedFooResFQN:                   pagedFooRes,
+				mapPagedFooResFQN:                mapPagedFooRes,
+				mapPagedFooResFQN + ".BarsEntry": barsEntry,
+				barfqn:                           bar,
+				batchFooReqFQN:                   batchFooReq,
+				updateFooReqFQN:                  updateFooReq,
+				fieldMaskType:                    fieldMask,
+				maxResultsFooReqFQN:              maxResultsFooReq,
+				validatedFooReqFQN:               validatedFooReq,
+			},
+		},
+	}
+
+	for _, tst := range []struct {
+		name    string
+		method  *descriptor.MethodDescriptorProto
+		options *options
+		imports map[pbinfo.ImportSpec]bool
+	}{
+		{
+			name:    "custom_op",
+			method:  opRPC,
+			options: &options{diregapic: true},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "empty_rpc",
+			method:  emptyRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "unary_rpc",
+			method:  unaryRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "bytes"}: true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "paging_rpc",
+			method:  pagingRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "math"}: true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Path: "google.golang.org/api/iterator"}:                         true,
+				{Path: "google.golang.org/protobuf/proto"}:                       true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "max_results_paging_rpc",
+			method:  maxResultsPagingRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "math"}: true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Path: "google.golang.org/api/iterator"}:                         true,
+				{Path: "google.golang.org/protobuf/proto"}:                       true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "map_paging_rpc",
+			method:  mapPagingRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "math"}: true,
+				{Path: "sort"}: true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Path: "google.golang.org/api/iterator"}:                         true,
+				{Path: "google.golang.org/protobuf/proto"}:                       true,
+				{Name: "barpb", Path: "google.golang.org/genproto/cloud/bar/v1"}: true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "validated_rpc",
+			method:  validatedRPC,
+			options: &options{validateRequired: true},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "errors"}: true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "update_rpc",
+			method:  updateRPC,
+			options: &options{autoUpdateMask: true},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "bytes"}: true,
+				{Path: "google.golang.org/protobuf/proto"}:                                        true,
+				{Path: "google.golang.org/protobuf/reflect/protoreflect"}:                         true,
+				{Path: "google.golang.org/api/googleapi"}:                                         true,
+				{Path: "google.golang.org/protobuf/encoding/protojson"}:                           true,
+				{Name: "fieldmaskpb", Path: "google.golang.org/protobuf/types/known/fieldmaskpb"}: true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}:                  true,
+			},
+		},
+		{
+			name:    "path_body_rpc",
+			method:  pathBodyRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "bytes"}: true,
+				{Path: "google.golang.org/protobuf/proto"}:                       true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "repeated_body_rpc",
+			method:  repeatedBodyRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "bytes"}:                           true,
+				{Path: "encoding/json"}:                   true,
+				{Path: "google.golang.org/api/googleapi"}: true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+		{
+			name:    "server_stream_rpc",
+			method:  serverStreamRPC,
+			options: &options{},
+			imports: map[pbinfo.ImportSpec]bool{
+				{Path: "bytes"}:         true,
+				{Path: "encoding/json"}: true,
+				{Path: "io"}:            true,
+				{Path: "strings"}:       true,
+				{Path: "google.golang.org/api/googleapi"}:                        true,
+				{Path: "google.golang.org/grpc/metadata"}:                        true,
+				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			},
+		},
+	} {
+		s.Method = []*descriptor.MethodDescriptorProto{tst.method}
+		g.opts = tst.options
+		g.imports = make(map[pbinfo.ImportSpec]bool)
+
+		if err := g.genRESTMethod("Foo", s, tst.method); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(g.imports, tst.imports); diff != "" {
+			t.Errorf("TestGenRESTMethod(%s): imports got(-),want(+):\n%s", tst.name, diff)
+		}
+
+		got := g.pt.String()
+		// An empty 200 response leaves the response message at its zero value.
+		if strings.Contains(got, "unm.Unmarshal(buf, resp)") && !strings.Contains(got, "if len(buf) == 0 {") {
+			t.Errorf("TestGenRESTMethod(%s): missing empty response body guard, got:\n%s", tst.name, got)
+		}
+		// Every variant should bind the context when the request is built,
+		// rather than copying the request with WithContext.
+		if !strings.Contains(got, "http.NewRequestWithContext(ctx, ") || strings.Contains(got, "httpReq.WithContext(ctx)") {
+			t.Errorf("TestGenRESTMethod(%s): want http.NewRequestWithContext, got:\n%s", tst.name, got)
+		}
+		// Each attempt is bound by the ctx gax.Invoke passes to the closure,
+		// which carries any gax.WithTimeout deadline.
+		if i := strings.Index(got, "http.NewRequestWithContext(ctx, "); i >= 0 && i < strings.Index(got, "func(ctx context.Context, settings gax.CallSettings) error {") {
+			t.Errorf("TestGenRESTMethod(%s): request not bound to the attempt context, got:\n%s", tst.name, got)
+		}
+		// Error responses are parsed for the typed details of their status.
+		if strings.Contains(got, "googleapi.CheckResponse(") && !strings.Contains(got, "maybeAPIError(") {
+			t.Errorf("TestGenRESTMethod(%s): error response not wrapped in an APIError, got:\n%s", tst.name, got)
+		}
+		// A failed custom operation call returns the HTTP error, never an
+		// operation wrapping a partial response.
+		if tst.method == opRPC {
+			if i, j := strings.Index(got, "return nil, e\n"), strings.Index(got, "op := &Operation{"); i < 0 || j < i {
+				t.Errorf("TestGenRESTMethod(%s): want the error returned before the operation is built, got:\n%s", tst.name, got)
+			}
+		}
+		// Network failures are classified, so that transient ones are retried.
+		if strings.Count(got, "c.httpClient.Do(httpReq)") != strings.Count(got, "return maybeTransient(err)") {
+			t.Errorf("TestGenRESTMethod(%s): transport error not classified, got:\n%s", tst.name, got)
+		}
+		// Errors must never be discarded, which strict linters reject.
+		if strings.Contains(got, ", _ :=") {
+			t.Errorf("TestGenRESTMethod(%s): error discarded by blank assignment, got:\n%s", tst.name, got)
+		}
+		// A request body must be read anew on each retry attempt, so its
+		// reader may only be created inside the retried closure.
+		if i := strings.Index(got, "bytes.NewReader(jsonReq)"); i >= 0 && i < strings.Index(got, "gax.Invoke(") {
+			t.Errorf("TestGenRESTMethod(%s): request body reader created outside of gax.Invoke, got:\n%s", tst.name, got)
+		}
+
+		txtdiff.Diff(t, fmt.Sprintf("%s_%s", t.Name(), tst.name), got, filepath.Join("testdata", fmt.Sprintf("rest_%s.want", tst.method.GetName())))
+		g.reset()
+	}
+}
+
+func TestRESTClientQuotaProject(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo", transports: []transport{rest}},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, true)
+	got := g.pt.String()
+
+	// The options are forwarded to the transport, which sends the
+	// x-goog-user-project header of option.WithQuotaProject, and whose client
+	// the operations client reuses.
+	last := 0
+	for _, want := range []string{
+		"// Such a client is used as is, so it must also send any x-goog-user-project",
+		"clientOpts := append(defaultFooRESTClientOptions(), opts...)",
+		"httptransport.NewClient(detachedContext{ctx}, clientOpts...)",
+		"option.WithHTTPClient(httpClient),",
+		"opClient, err := lroauto.NewOperationsRESTClient(ctx, lroOpts...)",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTClientQuotaProject: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+	if strings.Contains(got, "quotaProject") {
+		t.Errorf("TestRESTClientQuotaProject: want the quota project left to the transport, got:\n%s", got)
+	}
+}
+
+func TestRESTClientGRPCOptions(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo"},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+
+	// The options of the user are checked before the transport sees them.
+	check := strings.Index(got, "if err := checkRESTClientOptions(opts); err != nil {")
+	if check < 0 || check > strings.Index(got, "httptransport.NewClient(") {
+		t.Errorf("TestRESTClientGRPCOptions: want options checked before the transport, got:\n%s", got)
+	}
+}
+
+func TestRESTClientTransport(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+	opServ := &descriptor.ServiceDescriptorProto{Name: proto.String("FooOperationsService")}
+
+	g := &generator{
+		opts:     &options{pkgName: "foo"},
+		imports:  map[pbinfo.ImportSpec]bool{},
+		comments: map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{
+			serv: opServ,
+		},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+
+	// A client given with option.WithHTTPClient is returned as is by
+	// httptransport.NewClient, so its transport must reach the operation
+	// client too.
+	for _, want := range []string{
+		"// configuration, pass option.WithHTTPClient with a client using it.",
+		"// The client is shared with the operation client.",
+		"httpClient, endpoint, err := httptransport.NewClient(detachedContext{ctx}, clientOpts...)",
+		"option.WithHTTPClient(httpClient),",
+		"opC, err := NewFooOperationsRESTClient(ctx, o...)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTClientTransport: generated constructor missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTHeaderPrefix(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	for _, tst := range []struct {
+		opts *options
+		want string
+	}{
+		{
+			opts: &options{pkgName: "foo"},
+			want: `c.xGoogMetadata = metadata.Pairs("x-goog-api-client", gax.XGoogHeader(kv...))`,
+		},
+		{
+			opts: &options{pkgName: "foo", headerPrefix: "X-Acme-"},
+			want: `c.xGoogMetadata = metadata.Pairs("x-acme-api-client", gax.XGoogHeader(kv...))`,
+		},
+		{
+			opts: &options{pkgName: "foo", headerPrefix: "x-acme-", transports: []transport{rest}, omitMetadata: true},
+			want: `c.xGoogMetadata = http.Header{"X-Acme-Api-Client": []string{gax.XGoogHeader(kv...)}}`,
+		},
+	} {
+		g := &generator{
+			opts:             tst.opts,
+			imports:          map[pbinfo.ImportSpec]bool{},
+			comments:         map[protoiface.MessageV1]string{},
+			customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+		}
+		g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+		if got := g.pt.String(); !strings.Contains(got, tst.want) {
+			t.Errorf("TestRESTHeaderPrefix(%q): missing %q, got:\n%s", tst.opts.headerPrefix, tst.want, got)
+		}
+	}
+}
+
+func TestRESTClientInfoFeatures(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	for _, tst := range []struct {
+		opts *options
+		want string
+	}{
+		{
+			opts: &options{pkgName: "foo", numericEnums: true},
+			want: `kv = append(kv, "numeric-enums", "1")`,
+		},
+		{
+			opts: &options{pkgName: "foo", numericEnums: true, protoNames: true},
+			want: `kv = append(kv, "numeric-enums", "1", "proto-names", "1")`,
+		},
+	} {
+		g := &generator{
+			opts:             tst.opts,
+			imports:          map[pbinfo.ImportSpec]bool{},
+			comments:         map[protoiface.MessageV1]string{},
+			customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+		}
+		g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+		got := g.pt.String()
+		// The features follow the versions, before the header is built.
+		i := strings.Index(got, tst.want)
+		if i < 0 || i < strings.Index(got, `"rest", "UNKNOWN")`) || i > strings.Index(got, "gax.XGoogHeader(kv...)") {
+			t.Errorf("TestRESTClientInfoFeatures: want %q in setGoogleClientInfo, got:\n%s", tst.want, got)
+		}
+	}
+
+	g := &generator{
+		opts:             &options{pkgName: "foo"},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	if got := g.pt.String(); strings.Contains(got, "numeric-enums") {
+		t.Errorf("TestRESTClientInfoFeatures: want no feature without options, got:\n%s", got)
+	}
+}
+
+func TestRESTClientOmitConnection(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	for _, omit := range []bool{false, true} {
+		g := &generator{
+			opts:             &options{pkgName: "foo", transports: []transport{rest}, omitConnection: omit},
+			imports:          map[pbinfo.ImportSpec]bool{},
+			comments:         map[protoiface.MessageV1]string{},
+			customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+		}
+		if err := g.internalClientIntfInit(serv, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+		got := g.pt.String()
+
+		if want := !omit; strings.Contains(got, "Connection() *grpc.ClientConn") != want {
+			t.Errorf("TestRESTClientOmitConnection(omit=%v): want Connection method %v, got:\n%s", omit, want, got)
+		}
+		if want := !omit; g.imports[pbinfo.ImportSpec{Path: "google.golang.org/grpc"}] != want {
+			t.Errorf("TestRESTClientOmitConnection(omit=%v): want grpc import %v", omit, want)
+		}
+	}
+}
+
+func TestRESTClientClose(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo"},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+
+	// Idle connections must be closed before the client is dropped,
+	// otherwise they are held open by the transport's connection pool.
+	for _, want := range []string{"hc.CloseIdleConnections()", "c.httpClient = nil"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTClientClose: generated Close missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTClientEndpoint(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo", transports: []transport{rest}},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+
+	// The endpoint after option and environment resolution is retrievable.
+	for _, want := range []string{"func (c *fooRESTClient) Endpoint() string {", "return c.endpoint"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTClientEndpoint: generated client missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTClientHTTPDoer(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo", transports: []transport{rest}},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientInit(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+
+	// Depending on the interface lets tests substitute a fake transport.
+	if want := "httpClient httpDoer"; !strings.Contains(got, want) || strings.Contains(got, "httpClient *http.Client") {
+		t.Errorf("TestRESTClientHTTPDoer: want field %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTClientEndpointPathPrefix(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo"},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	endpoint := generatedExpr(t, g.pt.String(), func(n ast.Node) ast.Expr {
+		if kv, ok := n.(*ast.KeyValueExpr); ok && types.ExprString(kv.Key) == "endpoint" {
+			return kv.Value
+		}
+		return nil
+	})
+
+	// Join the endpoint the constructor keeps with the path a method appends,
+	// for an endpoint that has a path prefix.
+	mg := &generator{imports: map[pbinfo.ImportSpec]bool{}}
+	mthd, err := setupMethod(mg, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mg.generateURLString(mthd, "nil, "); err != nil {
+		t.Fatal(err)
+	}
+	path := generatedExpr(t, mg.pt.String(), func(n ast.Node) ast.Expr {
+		if as, ok := n.(*ast.AssignStmt); ok && as.Tok == token.ADD_ASSIGN && types.ExprString(as.Lhs[0]) == "baseUrl.Path" {
+			return as.Rhs[0]
+		}
+		return nil
+	})
+	for _, ep := range []string{"https://gateway.example.com/api/", "https://gateway.example.com/api//"} {
+		vars := map[string]string{"endpoint": ep, "req.GetKingdom()": "animalia"}
+		e, err := evalStringExpr(endpoint, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseUrl, err := url.Parse(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := evalStringExpr(path, vars)
+		if err != nil {
+			t.Fatal(err)
+		}
+		baseUrl.Path += p
+		if got, want := baseUrl.Path, "/api/v1/kingdom/animalia"; got != want {
+			t.Errorf("TestRESTClientEndpointPathPrefix(%q): URL path = %q, want %q", ep, got, want)
+		}
+	}
+
+	// The operations client is given the trimmed endpoint too.
+	g.reset()
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, true)
+	if got := g.pt.String(); !strings.Contains(got, "option.WithEndpoint(c.endpoint)") || strings.Contains(got, "option.WithEndpoint(endpoint)") {
+		t.Errorf("TestRESTClientEndpointPathPrefix: want the operations client at the trimmed endpoint, got:\n%s", got)
+	}
+}
+
+// generatedExpr parses the generated declarations or statements src and
+// returns the first expression that find picks out of them.
+func generatedExpr(t *testing.T, src string, find func(ast.Node) ast.Expr) ast.Expr {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+src, 0)
+	if err != nil {
+		// Statements need an enclosing function.
+		file, err = parser.ParseFile(token.NewFileSet(), "", "package p\nfunc f() {\n"+src+"\n}", 0)
+	}
+	if err != nil {
+		t.Fatalf("cannot parse generated code: %v\n%s", err, src)
+	}
+	var expr ast.Expr
+	ast.Inspect(file, func(n ast.Node) bool {
+		if expr == nil {
+			expr = find(n)
+		}
+		return expr == nil
+	})
+	if expr == nil {
+		t.Fatalf("expression not found in generated code:\n%s", src)
+	}
+	return expr
+}
+
+// evalStringExpr evaluates the generated string expression e, which may only
+// use string literals, the strings trimming functions, fmt.Sprintf, and the
+// variables and calls without arguments whose values are given in vars.
+func evalStringExpr(e ast.Expr, vars map[string]string) (string, error) {
+	switch e := e.(type) {
+	case *ast.BasicLit:
+		return strconv.Unquote(e.Value)
+	case *ast.Ident:
+		if v, ok := vars[e.Name]; ok {
+			return v, nil
+		}
+	case *ast.CallExpr:
+		if v, ok := vars[types.ExprString(e)]; ok && len(e.Args) == 0 {
+			return v, nil
+		}
+		args := make([]string, len(e.Args))
+		for i, a := range e.Args {
+			v, err := evalStringExpr(a, vars)
+			if err != nil {
+				return "", err
+			}
+			args[i] = v
+		}
+		switch fn := types.ExprString(e.Fun); {
+		case fn == "strings.TrimRight" && len(args) == 2:
+			return strings.TrimRight(args[0], args[1]), nil
+		case fn == "strings.TrimSuffix" && len(args) == 2:
+			return strings.TrimSuffix(args[0], args[1]), nil
+		case fn == "fmt.Sprintf" && len(args) > 0:
+			vals := make([]interface{}, len(args)-1)
+			for i, a := range args[1:] {
+				vals[i] = a
+			}
+			return fmt.Sprintf(args[0], vals...), nil
+		}
+	}
+	return "", fmt.Errorf("cannot evaluate %s", types.ExprString(e))
+}
+
+func TestRESTClientOptionsWithPort(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com:8443")
+
+	g := &generator{
+		opts:    &options{pkgName: "foo"},
+		imports: map[pbinfo.ImportSpec]bool{},
+	}
+	if err := g.restClientOptions(serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	for _, want := range []string{
+		`internaloption.WithDefaultEndpoint("https://foo.googleapis.com:8443")`,
+		`internaloption.WithDefaultMTLSEndpoint("https://foo.mtls.googleapis.com:8443")`,
+		`internaloption.WithDefaultAudience("https://foo.googleapis.com/")`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTClientOptionsWithPort: missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTClientOptionsMTLS(t *testing.T) {
+	for _, tst := range []struct {
+		host, endpoint, mtlsEndpoint string
+	}{
+		{host: "foo.googleapis.com", endpoint: "https://foo.googleapis.com", mtlsEndpoint: "https://foo.mtls.googleapis.com"},
+		{host: "foo.sandbox.googleapis.com", endpoint: "https://foo.sandbox.googleapis.com", mtlsEndpoint: "https://foo.mtls.sandbox.googleapis.com"},
+	} {
+		serv := &descriptor.ServiceDescriptorProto{
+			Name:    proto.String("FooService"),
+			Options: &descriptor.ServiceOptions{},
+		}
+		proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, tst.host)
+
+		g := &generator{
+			opts:    &options{pkgName: "foo"},
+			imports: map[pbinfo.ImportSpec]bool{},
+		}
+		if err := g.restClientOptions(serv, "Foo"); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+
+		// The transport can only switch to mTLS if it is given both endpoints.
+		for _, want := range []string{
+			fmt.Sprintf("internaloption.WithDefaultEndpoint(%q)", tst.endpoint),
+			fmt.Sprintf("internaloption.WithDefaultMTLSEndpoint(%q)", tst.mtlsEndpoint),
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestRESTClientOptionsMTLS(%s): missing %q, got:\n%s", tst.host, want, got)
+			}
+		}
+	}
+}
+
+func TestRESTClientOptionsRegion(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:    &options{pkgName: "foo"},
+		imports: map[pbinfo.ImportSpec]bool{},
+	}
+	if err := g.restClientOptions(serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "WithFooRESTRegion") {
+		t.Errorf("TestRESTClientOptionsRegion: want no option without rest-regional-endpoint, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.regionalEndpoint = true
+	if err := g.restClientOptions(serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	tmpl := "https://%s-foo.googleapis.com"
+	for _, want := range []string{
+		"func WithFooRESTRegion(region string) option.ClientOption {",
+		fmt.Sprintf("return option.WithEndpoint(fmt.Sprintf(%q, region))", tmpl),
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTClientOptionsRegion: missing %q, got:\n%s", want, got)
+		}
+	}
+	if got, want := fmt.Sprintf(tmpl, "us-central1"), "https://us-central1-foo.googleapis.com"; got != want {
+		t.Errorf("TestRESTClientOptionsRegion: regional endpoint = %q, want %q", got, want)
+	}
+}
+
+func TestRESTClientOptionsNoDefaultHost(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name: proto.String("FooService"),
+	}
+
+	g := &generator{
+		opts:    &options{pkgName: "foo"},
+		imports: map[pbinfo.ImportSpec]bool{},
+	}
+	if err := g.restClientOptions(serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The constructor calls this function unconditionally, so it must exist.
+	if want := "func defaultFooRESTClientOptions() []option.ClientOption {"; !strings.Contains(got, want) {
+		t.Errorf("TestRESTClientOptionsNoDefaultHost: missing %q, got:\n%s", want, got)
+	}
+	if strings.Contains(got, "WithDefaultEndpoint") {
+		t.Errorf("TestRESTClientOptionsNoDefaultHost: unexpected default endpoint, got:\n%s", got)
+	}
+}
+
+func TestRESTClientOptionPrecedence(t *testing.T) {
+	serv := &descriptor.ServiceDescriptorProto{
+		Name:    proto.String("FooService"),
+		Options: &descriptor.ServiceOptions{},
+	}
+	proto.SetExtension(serv.GetOptions(), annotations.E_DefaultHost, "foo.googleapis.com")
+
+	g := &generator{
+		opts:             &options{pkgName: "foo"},
+		imports:          map[pbinfo.ImportSpec]bool{},
+		comments:         map[protoiface.MessageV1]string{},
+		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{},
+	}
+	if err := g.restClientOptions(serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// Default scopes must not be set with option.WithScopes, which would
+	// compete with the scopes of the user rather than yield to them.
+	if want := "internaloption.WithDefaultScopes(DefaultAuthScopes()...),"; !strings.Contains(got, want) {
+		t.Errorf("TestRESTClientOptionPrecedence: missing %q, got:\n%s", want, got)
+	}
+	for _, unwanted := range []string{"option.WithScopes", "option.WithEndpoint", "option.WithCredentials"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("TestRESTClientOptionPrecedence: defaults contain %q, got:\n%s", unwanted, got)
+		}
+	}
+	g.reset()
+
+	// User options follow the defaults, so they win wherever both apply.
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	if want := "clientOpts := append(defaultFooRESTClientOptions(), opts...)"; !strings.Contains(g.pt.String(), want) {
+		t.Errorf("TestRESTClientOptionPrecedence: missing %q, got:\n%s", want, g.pt.String())
+	}
+}
+
+func TestRESTTraceSpan(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := pbinfo.ImportSpec{Path: "go.opencensus.io/trace"}
+
+	g.restTraceSpan(mthd)
+	if got := g.pt.String(); got != "" || g.imports[spec] {
+		t.Errorf("TestRESTTraceSpan: want no span without rest-tracing, got:\n%s", got)
+	}
+
+	g.opts.restTracing = true
+	g.restTraceSpan(mthd)
+	want := `ctx, span := trace.StartSpan(ctx, "identify.IdentifyMolluscService/Identify")`
+	if got := g.pt.String(); !strings.Contains(got, want) || !strings.Contains(got, "defer span.End()") {
+		t.Errorf("TestRESTTraceSpan: want span %q, got:\n%s", want, got)
+	}
+	if !g.imports[spec] {
+		t.Errorf("TestRESTTraceSpan: missing import %v", spec)
+	}
+}
+
+func TestRESTServerStreamTraceSpan(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	mthd.ServerStreaming = proto.Bool(true)
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.opts.restTracing = true
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	// The span must outlive the call, which only creates the stream.
+	if strings.Contains(got, "defer span.End()") {
+		t.Errorf("TestRESTServerStreamTraceSpan: span ended before the stream is read, got:\n%s", got)
+	}
+	for _, want := range []string{
+		"span: span,",
+		"endSpan = false",
+		"defer c.close()",
+		"c.span.End()",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTServerStreamTraceSpan: missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTURLLeadingSlash(t *testing.T) {
+	for _, url := range []string{"/v1/kingdom/{kingdom}", "v1/kingdom/{kingdom}"} {
+		var g generator
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		mthd, err := setupMethod(&g, url, "", []string{"kingdom"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := g.generateURLString(mthd, "nil, "); err != nil {
+			t.Fatal(err)
+		}
+		// The endpoint never ends in a slash, so exactly one joins the path.
+		want := `baseUrl.Path += fmt.Sprintf("/v1/kingdom/%v", req.GetKingdom())`
+		if got := g.pt.String(); !strings.Contains(got, want) {
+			t.Errorf("TestRESTURLLeadingSlash(%q): want %q, got:\n%s", url, want, got)
+		}
+	}
+}
+
+func TestRESTCustomVerb(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Custom{
+			Custom: &annotations.CustomHttpPattern{Kind: "HEAD", Path: "/v1/kingdom/{kingdom}"},
+		},
+	})
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		`baseUrl.Path += fmt.Sprintf("/v1/kingdom/%v", req.GetKingdom())`,
+		`http.NewRequestWithContext(ctx, "HEAD", baseUrl.String(), nil)`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTCustomVerb: missing %q, got:\n%s", want, got)
+		}
+	}
+	if params := g.pathParams(mthd); params["kingdom"] == nil {
+		t.Errorf("TestRESTCustomVerb: want kingdom path param, got %v", params)
+	}
+}
+
+func TestRESTAdditionalBindings(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	mthd, err := setupMethod(&g, "", "", []string{"project", "folder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+		f.Type = typep(descriptor.FieldDescriptorProto_TYPE_STRING)
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/{project=projects/*}/molluscs"},
+		AdditionalBindings: []*annotations.HttpRule{
+			{Pattern: &annotations.HttpRule_Get{Get: "/v1/{folder=folders/*}/molluscs"}},
+			// Another verb needs another request, so it is never selected.
+			{Pattern: &annotations.HttpRule_Post{Post: "/v1/molluscs"}},
+		},
+	})
+
+	if err := g.generateURLString(mthd, "nil, "); err != nil {
+		t.Fatal(err)
+	}
+	// When only the folder of the second binding is populated, the primary
+	// binding's case fails and the second one is selected.
+	got := g.pt.String()
+	last := 0
+	for _, want := range []string{
+		"switch {",
+		`case req.GetProject() != "":`,
+		`baseUrl.Path += fmt.Sprintf("/v1/%v/molluscs", req.GetProject())`,
+		`case req.GetFolder() != "":`,
+		`baseUrl.Path += fmt.Sprintf("/v1/%v/molluscs", req.GetFolder())`,
+		"default:",
+		`baseUrl.Path += fmt.Sprintf("/v1/%v/molluscs", req.GetProject())`,
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTAdditionalBindings: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+	if strings.Contains(got, `"/v1/molluscs"`) {
+		t.Errorf("TestRESTAdditionalBindings: want POST binding skipped, got:\n%s", got)
+	}
+}
+
+func TestRESTAdditionalBindingsQuery(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	mthd, err := setupMethod(&g, "", "", []string{"project", "folder"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.opts.validateRequired = true
+	for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+		f.Type = typep(descriptor.FieldDescriptorProto_TYPE_STRING)
+		f.Options = &descriptor.FieldOptions{}
+		proto.SetExtension(f.Options, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_REQUIRED})
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/{project=projects/*}/molluscs"},
+		AdditionalBindings: []*annotations.HttpRule{
+			{Pattern: &annotations.HttpRule_Get{Get: "/v1/{folder=folders/*}/molluscs"}},
+		},
+	})
+
+	g.restRequiredChecks(mthd, "nil, ")
+	if err := g.generateURLString(mthd, "nil, "); err != nil {
+		t.Fatal(err)
+	}
+	g.generateQueryString(mthd, "nil, ")
+	got := g.pt.String()
+	// The field bound by the path of the selected binding is not sent in the
+	// query string, and the one that is sent is checked for that binding.
+	last := 0
+	for _, want := range []string{
+		`case req.GetProject() != "":`,
+		`"required field folder is not set"`,
+		`params.Add("folder", fmt.Sprintf("%v", req.GetFolder()))`,
+		`case req.GetFolder() != "":`,
+		`"required field project is not set"`,
+		`params.Add("project", fmt.Sprintf("%v", req.GetProject()))`,
+		"default:",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTAdditionalBindingsQuery: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+	if strings.HasPrefix(got, "if ") || strings.Count(got, "baseUrl.RawQuery = params.Encode()") != 3 {
+		t.Errorf("TestRESTAdditionalBindingsQuery: want params built only per binding, got:\n%s", got)
+	}
+}
+
+func TestRESTRequestObjectOneofPathParam(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}/phylum/{phylum}", "*", []string{"kingdom", "phylum"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A member of a real oneof has no field of its own on the Go struct.
+	g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField()[1].OneofIndex = proto.Int32(0)
+
+	if _, err := g.restRequestObject(mthd, getHTTPInfo(mthd)); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	if !strings.Contains(got, "body.Kingdom = 0") || strings.Contains(got, "body.Phylum") {
+		t.Errorf("TestRESTRequestObjectOneofPathParam: want only kingdom cleared, got:\n%s", got)
+	}
+}
+
+func TestRESTClientOmitMetadata(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = proto.String(emptyType)
+	g.opts.omitMetadata = true
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	spec := pbinfo.ImportSpec{Path: "google.golang.org/grpc/metadata"}
+
+	if err := g.genRESTMethod("Foo", g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto), mthd); err != nil {
+		t.Fatal(err)
+	}
+	want := `buildHeaders(ctx, c.xGoogMetadata, http.Header{"Content-Type": []string{"application/json"}})`
+	if got := g.pt.String(); !strings.Contains(got, want) || strings.Contains(got, "metadata.Pairs") {
+		t.Errorf("TestRESTClientOmitMetadata: want headers %q, got:\n%s", want, got)
+	}
+	if g.imports[spec] {
+		t.Errorf("TestRESTClientOmitMetadata: unexpected import %v", spec)
+	}
+
+	// The option has no effect when a gRPC client shares the package.
+	g.opts.transports = []transport{grpc, rest}
+	if g.restHTTPHeaders() {
+		t.Errorf("TestRESTClientOmitMetadata: want gRPC metadata with transport=grpc+rest")
+	}
+}
+
+func TestRESTResourceChecks(t *testing.T) {
+	var g generator
+	g.imports = map[pbinfo.ImportSpec]bool{}
+
+	book := &descriptor.DescriptorProto{
+		Name:    proto.String("Book"),
+		Options: &descriptor.MessageOptions{},
+	}
+	proto.SetExtension(book.GetOptions(), annotations.E_Resource, &annotations.ResourceDescriptor{
+		Type:    "library.googleapis.com/Book",
+		Pattern: []string{"shelves/{shelf}/books/{book}", "books/{book}"},
+	})
+	name := &descriptor.FieldDescriptorProto{
+		Name:    proto.String("name"),
+		Number:  proto.Int32(1),
+		Type:    typep(descriptor.FieldDescriptorProto_TYPE_STRING),
+		Options: &descriptor.FieldOptions{},
+	}
+	proto.SetExtension(name.GetOptions(), annotations.E_ResourceReference, &annotations.ResourceReference{
+		Type: "library.googleapis.com/Book",
+	})
+	req := &descriptor.DescriptorProto{
+		Name:  proto.String("GetBookRequest"),
+		Field: []*descriptor.FieldDescriptorProto{name},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("GetBook"),
+		InputType:  proto.String(".library.GetBookRequest"),
+		OutputType: proto.String(".library.Book"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/{name=shelves/*/books/*}",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("LibraryService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("library"),
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{book, req},
+			},
+		},
+	})
+	spec := pbinfo.ImportSpec{Path: "regexp"}
+
+	g.restResourceChecks(mthd, "nil, ")
+	if got := g.pt.String(); got != "" || g.imports[spec] {
+		t.Errorf("TestRESTResourceChecks: want no checks without rest-validate-resource-names, got:\n%s", got)
+	}
+
+	g.opts.validateResources = true
+	g.restResourceChecks(mthd, "nil, ")
+	g.restResourceChecks(mthd, "")
+	got := g.pt.String()
+	for _, want := range []string{
+		`if !bookNameRegexp.MatchString(req.GetName()) {`,
+		`return nil, fmt.Errorf("invalid resource name %q for field name, want shelves/{shelf}/books/{book} or books/{book}", req.GetName())`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTResourceChecks: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "MustCompile") {
+		t.Errorf("TestRESTResourceChecks: want no regexp compiled per call, got:\n%s", got)
+	}
+
+	// Each regexp is declared once per package, however many methods use it.
+	g.reset()
+	g.restResourceRegexps()
+	got = g.pt.String()
+	want := `bookNameRegexp = regexp.MustCompile("^(?:shelves/[^/]+/books/[^/]+|books/[^/]+)$")`
+	if n := strings.Count(got, want); n != 1 {
+		t.Errorf("TestRESTResourceChecks: want %q declared once, got:\n%s", want, got)
+	}
+	if !g.imports[spec] {
+		t.Errorf("TestRESTResourceChecks: missing import %v", spec)
+	}
+	g.reset()
+	g.restResourceRegexps()
+	if got := g.pt.String(); got != "" {
+		t.Errorf("TestRESTResourceChecks: want regexps generated once per package, got:\n%s", got)
+	}
+}
+
+func TestRESTPagingTotalSize(t *testing.T) {
+	var g generator
+
+	foo := &descriptor.DescriptorProto{Name: proto.String("Foo")}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("page_size"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	res := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosResponse"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("foos"),
+				Number:   proto.Int32(1),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".foo.Foo"),
+				Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			},
+			{Name: proto.String("next_page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: proto.String("total_size"), Number: proto.Int32(3), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ListFoos"),
+		InputType:  proto.String(".foo.ListFoosRequest"),
+		OutputType: proto.String(".foo.ListFoosResponse"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foos",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{foo, req, res},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if want := "it.TotalSize = int64(resp.GetTotalSize())"; !strings.Contains(g.pt.String(), want) {
+		t.Errorf("TestRESTPagingTotalSize: missing %q, got:\n%s", want, g.pt.String())
+	}
+
+	iter := g.aux.iters["FooIterator"]
+	if iter == nil || !iter.totalSize {
+		t.Fatalf("TestRESTPagingTotalSize: want FooIterator with totalSize, got %+v", iter)
+	}
+	g.reset()
+	g.pagingIter(iter)
+	if want := "TotalSize int64"; !strings.Contains(g.pt.String(), want) {
+		t.Errorf("TestRESTPagingTotalSize: iterator missing %q, got:\n%s", want, g.pt.String())
+	}
+}
+
+func TestRESTPagingDeadline(t *testing.T) {
+	var g generator
+
+	foo := &descriptor.DescriptorProto{Name: proto.String("Foo")}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("page_size"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	res := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosResponse"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("foos"),
+				Number:   proto.Int32(1),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".foo.Foo"),
+				Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			},
+			{Name: proto.String("next_page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ListFoos"),
+		InputType:  proto.String(".foo.ListFoosRequest"),
+		OutputType: proto.String(".foo.ListFoosResponse"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foos",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{foo, req, res},
+			},
+		},
+	})
+
+	// Without a configured timeout, only the caller's context bounds the pages.
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	if want := `http.NewRequestWithContext(ctx, "GET"`; !strings.Contains(got, want) {
+		t.Errorf("TestRESTPagingDeadline: missing %q, got:\n%s", want, got)
+	}
+	if strings.Contains(got, "cctx, cancel := context.WithTimeout") {
+		t.Errorf("TestRESTPagingDeadline: unexpected timeout without config, got:\n%s", got)
+	}
+
+	data, err := protojson.Marshal(&conf.ServiceConfig{
+		MethodConfig: []*conf.MethodConfig{
+			{
+				Name:    []*conf.MethodConfig_Name{{Service: "foo.FooService"}},
+				Timeout: &duration.Duration{Seconds: 10},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.grpcConf, err = conf.New(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	g.reset()
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got = g.pt.String()
+	fetch := strings.Index(got, "it.InternalFetch = func(")
+	if fetch < 0 {
+		t.Fatalf("TestRESTPagingDeadline: missing InternalFetch, got:\n%s", got)
+	}
+	// Each page derives its own sub-context from a copy of the captured ctx.
+	body := got[fetch:]
+	for _, want := range []string{
+		"ctx := ctx",
+		"if _, ok := ctx.Deadline(); !ok && !c.disableDeadlines {",
+		"cctx, cancel := context.WithTimeout(ctx, 10000 * time.Millisecond)",
+		"defer cancel()",
+		"ctx = cctx",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("TestRESTPagingDeadline: InternalFetch missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got[:fetch], "WithTimeout") {
+		t.Errorf("TestRESTPagingDeadline: timeout should be per page, got:\n%s", got)
+	}
+	if !g.imports[pbinfo.ImportSpec{Path: "time"}] {
+		t.Errorf("TestRESTPagingDeadline: missing time import")
+	}
+}
+
+func TestRESTCallOptionsPrecedence(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The defaults come first, so that the options of the call override them.
+	prepend := strings.Index(got, "opts = append((*c.CallOptions).Identify[0:len((*c.CallOptions).Identify):len((*c.CallOptions).Identify)], opts...)")
+	invoke := strings.Index(got, "gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
+	if prepend < 0 || invoke < 0 || prepend > invoke || !strings.Contains(got[invoke:], "}, opts...)") {
+		t.Errorf("TestRESTCallOptionsPrecedence: want the default call options prepended to opts before gax.Invoke, got:\n%s", got)
+	}
+	g.reset()
+
+	g.restClientInit(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got = g.pt.String()
+	for _, want := range []string{
+		"CallOptions **FooCallOptions",
+		"client := FooClient{CallOptions: &FooCallOptions{}}",
+		"CallOptions: &client.CallOptions,",
+		"return &client, nil",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTCallOptionsPrecedence: generated client missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestRESTEmitUnpopulated(t *testing.T) {
+	var g generator
+	g.opts = &options{transports: []transport{rest}}
+
+	if got, want := g.restMarshalOptions("AllowPartial: true"), "protojson.MarshalOptions{AllowPartial: true}"; got != want {
+		t.Errorf("TestRESTEmitUnpopulated: restMarshalOptions() = %q, want %q", got, want)
+	}
+	g.opts.emitUnpopulated = true
+	if got, want := g.restMarshalOptions("AllowPartial: true"), "protojson.MarshalOptions{AllowPartial: true, EmitUnpopulated: true}"; got != want {
+		t.Errorf("TestRESTEmitUnpopulated: restMarshalOptions() = %q, want %q", got, want)
+	}
+}
+
+func TestRESTSharedMarshalOptions(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body:    "*",
+		Pattern: &annotations.HttpRule_Post{Post: "/v1/kingdom:identify"},
+	})
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	for _, tst := range []struct {
+		output string
+		want   []string
+	}{
+		{output: ".identify.IdentifyRequest", want: []string{"marshalOpts.Marshal(req)", "unmarshalOpts.Unmarshal(buf, resp)"}},
+		{output: emptyType, want: []string{"marshalOpts.Marshal(req)"}},
+	} {
+		g.reset()
+		mthd.OutputType = proto.String(tst.output)
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+		for _, want := range tst.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestRESTSharedMarshalOptions(%s): missing %q, got:\n%s", tst.output, want, got)
+			}
+		}
+		// The options are configured once, in the doc file.
+		if strings.Contains(got, "protojson.") {
+			t.Errorf("TestRESTSharedMarshalOptions(%s): want no inline protojson options, got:\n%s", tst.output, got)
+		}
+		if g.imports[pbinfo.ImportSpec{Path: "google.golang.org/protobuf/encoding/protojson"}] {
+			t.Errorf("TestRESTSharedMarshalOptions(%s): unexpected protojson import", tst.output)
+		}
+	}
+}
+
+func TestRESTProtoNames(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "mass_kg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tst := range []struct {
+		on               bool
+		wantKey, wantOpt string
+	}{
+		{wantKey: `params.Add("massKg", fmt.Sprintf("%v", req.GetMassKg()))`, wantOpt: "protojson.MarshalOptions{AllowPartial: true}"},
+		{on: true, wantKey: `params.Add("mass_kg", fmt.Sprintf("%v", req.GetMassKg()))`, wantOpt: "protojson.MarshalOptions{AllowPartial: true, UseProtoNames: true}"},
+	} {
+		g.reset()
+		g.opts.protoNames = tst.on
+		g.generateQueryString(mthd, "nil, ")
+		if got := g.pt.String(); !strings.Contains(got, tst.wantKey) {
+			t.Errorf("TestRESTProtoNames(%v): want query key %q, got:\n%s", tst.on, tst.wantKey, got)
+		}
+		// Query keys and body field names are consistent.
+		if got := g.restMarshalOptions("AllowPartial: true"); got != tst.wantOpt {
+			t.Errorf("TestRESTProtoNames(%v): restMarshalOptions() = %q, want %q", tst.on, got, tst.wantOpt)
+		}
+	}
+}
+
+func TestRESTPartialResponse(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "mass_kg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "callFields") {
+		t.Errorf("TestRESTPartialResponse: want no fields param without rest-partial-response, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.partialResponse = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	// The fields param is added to the other query params when provided.
+	last := 0
+	for _, want := range []string{
+		"baseUrl.RawQuery = params.Encode()",
+		`if fields := callFields(opts); fields != "" {`,
+		"q := baseUrl.Query()",
+		`q.Set("fields", fields)`,
+		"baseUrl.RawQuery = q.Encode()",
+		"httpReq, err := http.NewRequestWithContext(ctx",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTPartialResponse: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+}
+
+func TestRESTCallEndpoint(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "callEndpoint") {
+		t.Errorf("TestRESTCallEndpoint: want the client endpoint without rest-call-endpoint, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.callEndpoint = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	// The URL is built on the endpoint of the call, if any, and the path of
+	// the method is appended to it as usual.
+	last := 0
+	for _, want := range []string{
+		"baseUrl, err := url.Parse(callEndpoint(opts, c.endpoint))",
+		`baseUrl.Path += fmt.Sprintf("/v1/kingdom/%v", req.GetKingdom())`,
+		"httpReq, err := http.NewRequestWithContext(ctx",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTCallEndpoint: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+}
+
+func TestRESTETag(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	req := g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto)
+	etag := &descriptor.FieldDescriptorProto{
+		Name:   proto.String("etag"),
+		Number: proto.Int32(2),
+		Type:   typep(descriptor.FieldDescriptorProto_TYPE_STRING),
+	}
+	req.Field = append(req.Field, etag)
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+	ifMatch := `headers.Set("If-Match", quoteETag(etag))`
+
+	// A GET is not made conditional, but its response gets the ETag.
+	g.opts.etagHeaders = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	if strings.Contains(got, ifMatch) {
+		t.Errorf("TestRESTETag: want no If-Match for a GET, got:\n%s", got)
+	}
+	if !strings.Contains(got, "resp.Etag = unquoteETag(etag)") {
+		t.Errorf("TestRESTETag: want the ETag read for a GET, got:\n%s", got)
+	}
+	g.reset()
+
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/kingdom/{kingdom}",
+		},
+	})
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got = g.pt.String()
+	last := 0
+	for _, want := range []string{
+		"headers := buildHeaders(",
+		`if etag := req.GetEtag(); etag != "" {`,
+		ifMatch,
+		"var etag string",
+		"httpReq.Header = headers",
+		"googleapi.CheckResponse(httpRsp)",
+		`etag = httpRsp.Header.Get("ETag")`,
+		"if httpRsp.StatusCode == http.StatusNoContent {",
+		"}, opts...)",
+		`if resp.GetEtag() == "" {`,
+		"resp.Etag = unquoteETag(etag)",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTETag: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+	g.reset()
+
+	// A proto3 optional etag is a *string.
+	etag.Proto3Optional = proto.Bool(true)
+	etag.OneofIndex = proto.Int32(0)
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got = g.pt.String()
+	for _, want := range []string{ifMatch, "resp.Etag = proto.String(unquoteETag(etag))"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTETag: want %q for a proto3 optional etag, got:\n%s", want, got)
+		}
+	}
+	g.reset()
+
+	// A member of a real oneof cannot be set directly.
+	etag.Proto3Optional = nil
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "ETag") {
+		t.Errorf("TestRESTETag: want no etag handling in a oneof, got:\n%s", got)
+	}
+	etag.OneofIndex = nil
+	g.reset()
+
+	g.opts.etagHeaders = false
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "If-Match") || strings.Contains(got, "ETag") {
+		t.Errorf("TestRESTETag: want no etag handling without rest-etag, got:\n%s", got)
+	}
+}
+
+func TestRESTRequestID(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	req := g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto)
+	requestID := &descriptor.FieldDescriptorProto{
+		Name:    proto.String("request_id"),
+		Number:  proto.Int32(2),
+		Type:    typep(descriptor.FieldDescriptorProto_TYPE_STRING),
+		Options: &descriptor.FieldOptions{},
+	}
+	req.Field = append(req.Field, requestID)
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+	set := "req.RequestId = uuid.New().String()"
+
+	// A field merely named request_id is not an idempotency token.
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/kingdom/{kingdom}",
+		},
+	})
+	g.opts.requestID = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "uuid") {
+		t.Errorf("TestRESTRequestID: want no token without google.api.field_info, got:\n%s", got)
+	}
+	g.reset()
+	requestID.GetOptions().ProtoReflect().SetUnknown(uuid4FieldInfo())
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/kingdom/{kingdom}",
+		},
+	})
+
+	// A GET has no side effect to deduplicate.
+	g.opts.requestID = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, set) {
+		t.Errorf("TestRESTRequestID: want no request_id for a GET, got:\n%s", got)
+	}
+	g.reset()
+
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/kingdom/{kingdom}",
+		},
+	})
+	for _, output := range []string{".identify.IdentifyRequest", emptyType} {
+		mthd.OutputType = proto.String(output)
+		g.reset()
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+		// The token is set once, before the retried closure, so every attempt
+		// reuses it.
+		if n := strings.Count(got, set); n != 1 {
+			t.Errorf("TestRESTRequestID(%s): want the request_id set once, got %d times:\n%s", output, n, got)
+		}
+		last := 0
+		for _, want := range []string{
+			`if req.GetRequestId() == "" {`,
+			"req = proto.Clone(req).(*identifypb.IdentifyRequest)",
+			set,
+			"jsonReq, err := marshalOpts.Marshal(",
+			"gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {",
+		} {
+			i := strings.Index(got[last:], want)
+			if i < 0 {
+				t.Fatalf("TestRESTRequestID(%s): missing %q in order, got:\n%s", output, want, got)
+			}
+			last += i + len(want)
+		}
+		if !g.imports[pbinfo.ImportSpec{Path: "github.com/google/uuid"}] {
+			t.Errorf("TestRESTRequestID(%s): missing uuid import", output)
+		}
+	}
+	g.reset()
+
+	// A proto3 optional token is a *string.
+	requestID.Proto3Optional = proto.Bool(true)
+	requestID.OneofIndex = proto.Int32(0)
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); !strings.Contains(got, "req.RequestId = proto.String(uuid.New().String())") {
+		t.Errorf("TestRESTRequestID: want a proto3 optional token set with proto.String, got:\n%s", got)
+	}
+	g.reset()
+
+	// A member of a real oneof cannot be set directly.
+	requestID.Proto3Optional = nil
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "uuid") {
+		t.Errorf("TestRESTRequestID: want no token in a oneof, got:\n%s", got)
+	}
+	requestID.OneofIndex = nil
+	g.reset()
+
+	g.opts.requestID = false
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "uuid") {
+		t.Errorf("TestRESTRequestID: want no request_id without rest-request-id, got:\n%s", got)
+	}
+}
+
+// uuid4FieldInfo returns the wire encoding of a google.api.field_info
+// extension with a format of UUID4.
+func uuid4FieldInfo() []byte {
+	info := protowire.AppendTag(nil, fieldInfoFormat, protowire.VarintType)
+	info = protowire.AppendVarint(info, fieldInfoFormatUUID4)
+	b := protowire.AppendTag(nil, fieldInfoExtension, protowire.BytesType)
+	return protowire.AppendBytes(b, info)
+}
+
+func TestRESTAttemptTimeout(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	for _, output := range []string{".identify.IdentifyRequest", emptyType} {
+		mthd.OutputType = proto.String(output)
+
+		g.reset()
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+		// The attempt is bounded inside the retried closure, so that each
+		// attempt gets its own deadline, and X-Server-Timeout is set from it.
+		last := 0
+		for _, want := range []string{
+			"gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {",
+			"if d := attemptTimeout(opts); d > 0 {",
+			"ctx, cancel = context.WithTimeout(ctx, d)",
+			"defer cancel()",
+			"http.NewRequestWithContext(ctx, ",
+			"httpReq.Header = headers",
+			"setServerTimeout(ctx, httpReq.Header)",
+			"httpRsp, err := c.httpClient.Do(httpReq)",
+		} {
+			i := strings.Index(got[last:], want)
+			if i < 0 {
+				t.Fatalf("TestRESTAttemptTimeout(%s): missing %q in order, got:\n%s", output, want, got)
+			}
+			last += i + len(want)
+		}
+	}
+}
+
+func TestRESTMetrics(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	for _, output := range []string{".identify.IdentifyRequest", emptyType} {
+		mthd.OutputType = proto.String(output)
+
+		g.reset()
+		g.opts.restMetrics = false
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		if got := g.pt.String(); strings.Contains(got, "recordRESTCall") || g.imports[pbinfo.ImportSpec{Path: "time"}] {
+			t.Errorf("TestRESTMetrics(%s): want no metrics without rest-metrics, got:\n%s", output, got)
+		}
+
+		g.reset()
+		g.opts.restMetrics = true
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatal(err)
+		}
+		got := g.pt.String()
+		last := 0
+		for _, want := range []string{
+			"start := time.Now()",
+			"httpRsp, err := c.httpClient.Do(httpReq)",
+			`recordRESTCall(ctx, "identify.IdentifyMolluscService/Identify", start, httpRsp, err)`,
+			"return maybeTransient(err)",
+		} {
+			i := strings.Index(got[last:], want)
+			if i < 0 {
+				t.Fatalf("TestRESTMetrics(%s): missing %q in order, got:\n%s", output, want, got)
+			}
+			last += i + len(want)
+		}
+		if !g.imports[pbinfo.ImportSpec{Path: "time"}] {
+			t.Errorf("TestRESTMetrics(%s): missing time import", output)
+		}
+	}
+}
+
+func TestRESTDebugLogging(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "logRequest") {
+		t.Errorf("TestRESTDebugLogging: want no logging without rest-debug-logging, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.debugLogging = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	// The request is logged once its headers, including any credentials
+	// that logRequest redacts, are set.
+	got := g.pt.String()
+	hdr := strings.Index(got, "httpReq.Header = headers")
+	log := strings.Index(got, "logRequest(httpReq)")
+	if hdr < 0 || log < hdr {
+		t.Errorf("TestRESTDebugLogging: want logRequest after the headers are set, got:\n%s", got)
+	}
+}
+
+func TestGenRESTMethodsInvalidBody(t *testing.T) {
+	var g generator
+	// setupMethod binds the body to a GET, which can't be transcoded.
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "*", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	valid := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("Classify"),
+		InputType:  mthd.InputType,
+		OutputType: mthd.OutputType,
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(valid.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body:    "*",
+		Pattern: &annotations.HttpRule_Post{Post: "/v1/kingdom/{kingdom}:classify"},
+	})
+	serv.Method = append(serv.Method, valid)
+	g.descInfo.ParentElement[valid] = serv
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethods(serv, "Foo"); err != nil {
+		t.Fatalf("TestGenRESTMethodsInvalidBody: want generation to continue, got %v", err)
+	}
+	got := g.pt.String()
+
+	// The diagnostic names the method, the offending rule and a fix.
+	for _, want := range []string{
+		"warning: skipping REST implementation: invalid use of body parameter for a get method identify.IdentifyMolluscService.Identify",
+		`google.api.http rule {get: "/v1/kingdom/{kingdom}", body: "*"} binds a body to GET`,
+		"use POST, PUT or PATCH, or remove the body",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("TestGenRESTMethodsInvalidBody: want logged %q, got %q", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "Classify") {
+		t.Errorf("TestGenRESTMethodsInvalidBody: want no diagnostic for a valid method, got %q", logs.String())
+	}
+	for _, want := range []string{
+		"func (c *fooRESTClient) Identify(ctx context.Context, req *identifypb.IdentifyRequest, opts ...gax.CallOption) (*identifypb.IdentifyRequest, error) {",
+		`return nil, errors.New("invalid use of body parameter for a get method identify.IdentifyMolluscService.Identify; it is not supported by REST clients")`,
+		// The other methods are generated as usual.
+		"func (c *fooRESTClient) Classify(ctx context.Context, req *identifypb.IdentifyRequest, opts ...gax.CallOption) (*identifypb.IdentifyRequest, error) {",
+		`http.NewRequestWithContext(ctx, "POST"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestGenRESTMethodsInvalidBody: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `"GET"`) {
+		t.Errorf("TestGenRESTMethodsInvalidBody: want no request for the invalid method, got:\n%s", got)
+	}
+}
+
+func TestRESTWithResponse(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "WithResponse") {
+		t.Errorf("TestRESTWithResponse: want no variant without rest-with-response, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.withResponse = true
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		// The original method is unchanged.
+		"func (c *fooRESTClient) Identify(ctx context.Context, req *identifypb.IdentifyRequest, opts ...gax.CallOption) (*identifypb.IdentifyRequest, error) {",
+		"func (c *fooRESTClient) IdentifyWithResponse(ctx context.Context, req *identifypb.IdentifyRequest, opts ...gax.CallOption) (*identifypb.IdentifyRequest, *http.Response, error) {",
+		"httpResp = httpRsp",
+		"return nil, httpResp, e",
+		"return resp, httpResp, nil",
+		// Its rate-limit headers are read with the generated RateLimitOf.
+		"so that RateLimitOf can read its rate-limit headers.",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTWithResponse: missing %q, got:\n%s", want, got)
+		}
+	}
+	g.reset()
+
+	if err := g.genClientWrapperMethod(mthd, serv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	want := "func (c *FooClient) IdentifyWithResponse(ctx context.Context, req *identifypb.IdentifyRequest, opts ...gax.CallOption) (*identifypb.IdentifyRequest, *http.Response, error) {"
+	if got := g.pt.String(); !strings.Contains(got, want) || !strings.Contains(got, "return rc.IdentifyWithResponse(ctx, req, opts...)") {
+		t.Errorf("TestRESTWithResponse: want client wrapper %q, got:\n%s", want, got)
+	}
+	// A gRPC internal client has no such variant, which is reported as an
+	// error rather than a panic.
+	if got := g.pt.String(); !strings.Contains(got, "rc, ok := c.internalClient.(interface {") || !strings.Contains(got, `return nil, nil, errors.New("IdentifyWithResponse is only supported by REST clients")`) {
+		t.Errorf("TestRESTWithResponse: want a checked lookup of the variant, got:\n%s", got)
+	}
+}
+
+func TestRESTRequiredPathParams(t *testing.T) {
+	var g generator
+
+	requiredOpts := &descriptor.FieldOptions{}
+	proto.SetExtension(requiredOpts, annotations.E_FieldBehavior, []annotations.FieldBehavior{annotations.FieldBehavior_REQUIRED})
+	squid := &descriptor.DescriptorProto{
+		Name: proto.String("Squid"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("name"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING), Options: requiredOpts},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("UpdateSquidRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("squid"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".foo.Squid")},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("UpdateSquid"),
+		InputType:  proto.String(".foo.UpdateSquidRequest"),
+		OutputType: proto.String(".foo.Squid"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "squid",
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/{squid.name=squids/*}",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest,rest-validate-required"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{squid, req},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// An empty squid.name would leave an empty URL segment, so it is
+	// rejected before the URL is built, even if squid itself is unset.
+	check := strings.Index(got, `if req.GetSquid().GetName() == "" {`)
+	build := strings.Index(got, "baseUrl, err := url.Parse(c.endpoint)")
+	if check < 0 || build < 0 || check > build {
+		t.Errorf("TestRESTRequiredPathParams: want the path param checked before the URL is built, got:\n%s", got)
+	}
+	want := `return nil, errors.New("required field squid.name is not set; it is part of the request URL path")`
+	if !strings.Contains(got, want) {
+		t.Errorf("TestRESTRequiredPathParams: missing %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTStreamMedia(t *testing.T) {
+	var g generator
+
+	httpBody := &descriptor.DescriptorProto{
+		Name: proto.String("HttpBody"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("content_type"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: proto.String("data"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_BYTES)},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("DownloadRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("name"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("Download"),
+		InputType:  proto.String(".foo.DownloadRequest"),
+		OutputType: proto.String(".google.api.HttpBody"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/{name=files/*}:download",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("google.api"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("google.golang.org/genproto/googleapis/api/httpbody;httpbody")},
+				MessageType: []*descriptor.DescriptorProto{httpBody},
+			},
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{req},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "DownloadMedia") {
+		t.Errorf("TestRESTStreamMedia: want no variant without rest-stream-media, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.streamMedia = true
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	variant := strings.Index(got, "func (c *fooRESTClient) DownloadMedia(")
+	if variant < 0 {
+		t.Fatalf("TestRESTStreamMedia: missing DownloadMedia, got:\n%s", got)
+	}
+	plain, media := got[:variant], got[variant:]
+
+	// The original method still buffers the body.
+	for _, want := range []string{
+		"func (c *fooRESTClient) Download(ctx context.Context, req *foopb.DownloadRequest, opts ...gax.CallOption) (*httpbodypb.HttpBody, error) {",
+		"resp.Data = buf",
+	} {
+		if !strings.Contains(plain, want) {
+			t.Errorf("TestRESTStreamMedia: Download missing %q, got:\n%s", want, plain)
+		}
+	}
+	for _, want := range []string{
+		"func (c *fooRESTClient) DownloadMedia(ctx context.Context, req *foopb.DownloadRequest, opts ...gax.CallOption) (io.ReadCloser, error) {",
+		"var resp io.ReadCloser",
+		"httpRsp.Body.Close()",
+		"resp = httpRsp.Body",
+		"return resp, nil",
+	} {
+		if !strings.Contains(media, want) {
+			t.Errorf("TestRESTStreamMedia: DownloadMedia missing %q, got:\n%s", want, media)
+		}
+	}
+	// The streamed body must outlive the call, so it is neither read nor
+	// closed on success.
+	for _, unwanted := range []string{"ioutil.ReadAll", "defer httpRsp.Body.Close()"} {
+		if strings.Contains(media, unwanted) {
+			t.Errorf("TestRESTStreamMedia: DownloadMedia should not contain %q, got:\n%s", unwanted, media)
+		}
+	}
+	if !g.imports[pbinfo.ImportSpec{Path: "io"}] {
+		t.Errorf("TestRESTStreamMedia: missing io import")
+	}
+	g.reset()
+
+	if err := g.genClientWrapperMethod(mthd, srv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	want := "func (c *FooClient) DownloadMedia(ctx context.Context, req *foopb.DownloadRequest, opts ...gax.CallOption) (io.ReadCloser, error) {"
+	if got := g.pt.String(); !strings.Contains(got, want) || !strings.Contains(got, "return rc.DownloadMedia(ctx, req, opts...)") {
+		t.Errorf("TestRESTStreamMedia: want client wrapper %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTMediaUpload(t *testing.T) {
+	var g generator
+
+	file := &descriptor.DescriptorProto{
+		Name: proto.String("File"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("name"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("CreateFileRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("file"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE), TypeName: proto.String(".foo.File")},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateFile"),
+		InputType:  proto.String(".foo.CreateFileRequest"),
+		OutputType: proto.String(".foo.File"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "file",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/upload/v1/files",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{file, req},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "CreateFileUpload") {
+		t.Errorf("TestRESTMediaUpload: want no variant without rest-media-upload, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.mediaUpload = true
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	variant := strings.Index(got, "func (c *fooRESTClient) CreateFileUpload(")
+	if variant < 0 {
+		t.Fatalf("TestRESTMediaUpload: missing CreateFileUpload, got:\n%s", got)
+	}
+	upload := got[variant:]
+	for _, want := range []string{
+		"func (c *fooRESTClient) CreateFileUpload(ctx context.Context, req *foopb.CreateFileRequest, media io.Reader, mediaOpts []googleapi.MediaOption, opts ...gax.CallOption) (*foopb.File, error) {",
+		`q.Set("uploadType", "resumable")`,
+		"mo := googleapi.ProcessMediaOptions(mediaOpts)",
+		`headers.Set("X-Upload-Content-Type", mo.ContentType)`,
+		`session = httpRsp.Header.Get("Location")`,
+		"buf, err := uploadMedia(ctx, c.httpClient, session, buildHeaders(ctx, c.xGoogMetadata), media, mo.ChunkSize)",
+		"if err := unmarshalOpts.Unmarshal(buf, resp); err != nil {",
+	} {
+		if !strings.Contains(upload, want) {
+			t.Errorf("TestRESTMediaUpload: CreateFileUpload missing %q, got:\n%s", want, upload)
+		}
+	}
+	// The media is read once, so it must be sent outside of the retried call.
+	if invoke := strings.Index(upload, "}, opts...)"); invoke < 0 || invoke > strings.Index(upload, "uploadMedia(") {
+		t.Errorf("TestRESTMediaUpload: want media uploaded after gax.Invoke, got:\n%s", upload)
+	}
+	for _, imp := range []string{"io", "google.golang.org/api/googleapi"} {
+		if !g.imports[pbinfo.ImportSpec{Path: imp}] {
+			t.Errorf("TestRESTMediaUpload: missing %s import", imp)
+		}
+	}
+	g.reset()
+
+	// Methods outside of /upload/ have no variant.
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "file",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/files",
+		},
+	})
+	if g.hasUpload(mthd) {
+		t.Errorf("TestRESTMediaUpload: want no variant for /v1/files")
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "file",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/upload/v1/files",
+		},
+	})
+
+	if err := g.genClientWrapperMethod(mthd, srv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	want := "func (c *FooClient) CreateFileUpload(ctx context.Context, req *foopb.CreateFileRequest, media io.Reader, mediaOpts []googleapi.MediaOption, opts ...gax.CallOption) (*foopb.File, error) {"
+	if got := g.pt.String(); !strings.Contains(got, want) || !strings.Contains(got, "return rc.CreateFileUpload(ctx, req, media, mediaOpts, opts...)") {
+		t.Errorf("TestRESTMediaUpload: want client wrapper %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTLROTypes(t *testing.T) {
+	var g generator
+
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateFoo"),
+		InputType:  proto.String(".my.pkg.CreateFooRequest"),
+		OutputType: proto.String(".google.longrunning.Operation"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foos",
+		},
+	})
+	opInfo := &longrunning.OperationInfo{
+		ResponseType: "Foo",
+		MetadataType: "my.pkg.CreateFooMetadata",
+	}
+	proto.SetExtension(mthd.GetOptions(), longrunning.E_OperationInfo, opInfo)
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package: proto.String("my.pkg"),
+				Options: &descriptor.FileOptions{GoPackage: proto.String("path/to/pkgpb;pkgpb")},
+				Service: []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{
+					{Name: proto.String("CreateFooRequest")},
+					{Name: proto.String("Foo")},
+					{Name: proto.String("CreateFooMetadata")},
+				},
+			},
+			{
+				Package:     proto.String("google.longrunning"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("google.golang.org/genproto/googleapis/longrunning;longrunning")},
+				MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Operation")}},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if !g.aux.lros[mthd] {
+		t.Fatalf("TestRESTLROTypes: want operation type queued for %s", mthd.GetName())
+	}
+	g.reset()
+	// Validating the annotation imports nothing.
+	if _, _, specs, err := g.lroResultSpecs(srv, mthd); err != nil || len(specs) != 2 || len(g.imports) != 0 {
+		t.Errorf("TestRESTLROTypes: lroResultSpecs() = %v, %v with imports %v, want 2 specs and no imports", specs, err, g.imports)
+	}
+	if err := g.lroType("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		"func (op *CreateFooOperation) Wait(ctx context.Context, opts ...gax.CallOption) (*pkgpb.Foo, error) {",
+		"func (op *CreateFooOperation) Poll(ctx context.Context, opts ...gax.CallOption) (*pkgpb.Foo, error) {",
+		"func (op *CreateFooOperation) Metadata() (*pkgpb.CreateFooMetadata, error) {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTLROTypes: missing %q, got:\n%s", want, got)
+		}
+	}
+
+	// A broken annotation is reported by the REST method itself.
+	opInfo.ResponseType = ""
+	proto.SetExtension(mthd.GetOptions(), longrunning.E_OperationInfo, opInfo)
+	if err := g.genRESTMethod("Foo", srv, mthd); err == nil {
+		t.Errorf("TestRESTLROTypes: want error for operation_info without response_type")
+	}
+}
+
+func TestRESTLROPollPath(t *testing.T) {
+	var g generator
+
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateFoo"),
+		InputType:  proto.String(".my.pkg.CreateFooRequest"),
+		OutputType: proto.String(".google.longrunning.Operation"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foos",
+		},
+	})
+	proto.SetExtension(mthd.GetOptions(), longrunning.E_OperationInfo, &longrunning.OperationInfo{
+		ResponseType: "Foo",
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package: proto.String("my.pkg"),
+				Options: &descriptor.FileOptions{GoPackage: proto.String("path/to/pkgpb;pkgpb")},
+				Service: []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{
+					{Name: proto.String("CreateFooRequest")},
+					{Name: proto.String("Foo")},
+				},
+			},
+			{
+				Package:     proto.String("google.longrunning"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("google.golang.org/genproto/googleapis/longrunning;longrunning")},
+				MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Operation")}},
+			},
+		},
+	})
+
+	if got, want := g.getOperationPathOverride(), "/v1/%s"; got != want {
+		t.Errorf("TestRESTLROPollPath: without a mixin got %q, want %q", got, want)
+	}
+
+	getOp := &descriptor.MethodDescriptorProto{
+		Name:    proto.String("GetOperation"),
+		Options: &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(getOp.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v2/{name=projects/*/locations/*/operations/*}",
+		},
+	})
+	g.mixins = mixins{"google.longrunning.Operations": {getOp}}
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.lroType("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The operation returned by the call, and one resumed by name, are both
+	// polled at the GetOperation binding of the mixin, filled in with the
+	// operation name.
+	for _, want := range []string{
+		"func (c *fooRESTClient) CreateFoo(ctx context.Context, req *pkgpb.CreateFooRequest, opts ...gax.CallOption) (*CreateFooOperation, error) {",
+		`override := fmt.Sprintf("/v2/%s", resp.GetName())`,
+		"lro: longrunning.InternalNewOperation(*c.LROClient, resp),",
+		`override := fmt.Sprintf("/v2/%s", name)`,
+		"lro: longrunning.InternalNewOperation(*c.LROClient, &longrunningpb.Operation{Name: name}),",
+		"pollPath: override,",
+		"opts = append([]gax.CallOption{gax.WithPath(op.pollPath)}, opts...)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTLROPollPath: missing %q, got:\n%s", want, got)
+		}
+	}
+
+	// Wait polls at the same path, leaving the exponential backoff between
+	// polls to WaitWithInterval.
+	wait := "func (op *CreateFooOperation) Wait(ctx context.Context, opts ...gax.CallOption) (*pkgpb.Foo, error) {\n\topts = append([]gax.CallOption{gax.WithPath(op.pollPath)}, opts...)\n\tvar resp pkgpb.Foo\n\tif err := op.lro.WaitWithInterval(ctx, &resp, time.Minute, opts...); err != nil {"
+	if !strings.Contains(got, wait) {
+		t.Errorf("TestRESTLROPollPath: want Wait delegating to WaitWithInterval, got:\n%s", got)
+	}
+	// REST callers may configure the backoff between polls instead.
+	if !strings.Contains(got, "func (op *CreateFooOperation) WaitWithBackoff(") {
+		t.Errorf("TestRESTLROPollPath: missing WaitWithBackoff, got:\n%s", got)
+	}
+}
+
+func TestRESTLROWaitBackoff(t *testing.T) {
+	for _, tst := range []struct {
+		empty bool
+		want  []string
+	}{
+		{
+			want: []string{
+				"func (op *CreateFooOperation) WaitWithBackoff(ctx context.Context, bo gax.Backoff, opts ...gax.CallOption) (*pkgpb.Foo, error) {",
+				"resp, err := op.Poll(ctx, opts...)",
+				"return resp, nil",
+				"return nil, err",
+			},
+		},
+		{
+			empty: true,
+			want: []string{
+				"func (op *CreateFooOperation) WaitWithBackoff(ctx context.Context, bo gax.Backoff, opts ...gax.CallOption) error {",
+				"if err := op.Poll(ctx, opts...); err != nil {",
+				"return nil",
+			},
+		},
+	} {
+		g := generator{imports: map[pbinfo.ImportSpec]bool{}}
+		g.lroWaitWithBackoff("CreateFooOperation", "pkgpb.Foo", tst.empty)
+		got := g.pt.String()
+
+		// Unset fields of the backoff fall back to pausing from a second,
+		// growing 1.3 times per poll up to a minute, with jitter.
+		want := append(tst.want,
+			"bo.Initial = time.Second",
+			"bo.Max = time.Minute",
+			"bo.Multiplier = 1.30",
+			"if err := gax.Sleep(ctx, bo.Pause()); err != nil {",
+		)
+		for _, w := range want {
+			if !strings.Contains(got, w) {
+				t.Errorf("TestRESTLROWaitBackoff(empty=%v): missing %q, got:\n%s", tst.empty, w, got)
+			}
+		}
+	}
+}
+
+func TestRESTCrossPackageOutput(t *testing.T) {
+	var g generator
+
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("GetFoo"),
+		InputType:  proto.String(".my.pkg.GetFooRequest"),
+		OutputType: proto.String(".common.types.Resource"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foo",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Name:        proto.String("common/types/resource.proto"),
+				Package:     proto.String("common.types"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/commonpb;commonpb")},
+				MessageType: []*descriptor.DescriptorProto{{Name: proto.String("Resource")}},
+			},
+			{
+				Name:        proto.String("my/pkg/foo.proto"),
+				Package:     proto.String("my.pkg"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/pkgpb;pkgpb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{{Name: proto.String("GetFooRequest")}},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The output type is referenced, and imported, with the alias of its
+	// own package rather than that of the service.
+	for _, want := range []string{
+		"func (c *fooRESTClient) GetFoo(ctx context.Context, req *pkgpb.GetFooRequest, opts ...gax.CallOption) (*commonpb.Resource, error) {",
+		"resp := &commonpb.Resource{}",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTCrossPackageOutput: missing %q, got:\n%s", want, got)
+		}
+	}
+	for _, imp := range []pbinfo.ImportSpec{
+		{Name: "commonpb", Path: "path/to/commonpb"},
+		{Name: "pkgpb", Path: "path/to/pkgpb"},
+	} {
+		if !g.imports[imp] {
+			t.Errorf("TestRESTCrossPackageOutput: missing import %v, got %v", imp, g.imports)
+		}
+	}
+}
+
+func TestRESTUpdateMaskQuery(t *testing.T) {
+	var g generator
+
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("UpdateFoo"),
+		InputType:  proto.String(".my.pkg.UpdateFooRequest"),
+		OutputType: proto.String(".my.pkg.Foo"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "foo",
+		Pattern: &annotations.HttpRule_Patch{
+			Patch: "/v1/foo",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package: proto.String("my.pkg"),
+				Options: &descriptor.FileOptions{GoPackage: proto.String("path/to/pkgpb;pkgpb")},
+				Service: []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Foo"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{Name: proto.String("display_name"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+						},
+					},
+					{
+						Name: proto.String("UpdateFooRequest"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{
+								Name:     proto.String("foo"),
+								Number:   proto.Int32(1),
+								Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+								TypeName: proto.String(".my.pkg.Foo"),
+							},
+							{
+								Name:     proto.String("update_mask"),
+								Number:   proto.Int32(2),
+								Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+								TypeName: proto.String(fieldMaskType),
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	// The mask is a single query param, and the resource alone is the body.
+	if got := g.queryParams(mthd); len(got) != 1 || got["update_mask"] == nil {
+		t.Errorf("TestRESTUpdateMaskQuery: got query params %v, want only update_mask", got)
+	}
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		"body := req.GetFoo()",
+		"field, err := protojson.Marshal(req.GetUpdateMask())",
+		`params.Add("updateMask", string(field[1:len(field)-1]))`,
+		`http.NewRequestWithContext(ctx, "PATCH", baseUrl.String(), bytes.NewReader(jsonReq))`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTUpdateMaskQuery: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "displayName") || strings.Contains(got, "updateMask.paths") {
+		t.Errorf("TestRESTUpdateMaskQuery: want neither body fields nor mask paths as query params, got:\n%s", got)
+	}
+
+	// Replay the generated code: the server expects the JSON encoding of the
+	// mask, whose paths are lowerCamelCase.
+	field, err := protojson.Marshal(&fieldmaskpb.FieldMask{Paths: []string{"display_name", "labels"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := url.Values{}
+	params.Add("updateMask", string(field[1:len(field)-1]))
+	if got, want := params.Encode(), "updateMask=displayName%2Clabels"; got != want {
+		t.Errorf("TestRESTUpdateMaskQuery: got query %q, want %q", got, want)
+	}
+}
+
+func TestRESTOneofBody(t *testing.T) {
+	var g generator
+
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("CreateFoo"),
+		InputType:  proto.String(".my.pkg.CreateFooRequest"),
+		OutputType: proto.String(".my.pkg.Foo"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "foo",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/foos",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package: proto.String("my.pkg"),
+				Options: &descriptor.FileOptions{GoPackage: proto.String("path/to/pkgpb;pkgpb")},
+				Service: []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{
+					{
+						Name: proto.String("Foo"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{Name: proto.String("size"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+						},
+					},
+					{
+						Name: proto.String("CreateFooRequest"),
+						Field: []*descriptor.FieldDescriptorProto{
+							{
+								Name:       proto.String("foo"),
+								Number:     proto.Int32(1),
+								Type:       typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+								TypeName:   proto.String(".my.pkg.Foo"),
+								OneofIndex: proto.Int32(0),
+							},
+							{
+								Name:       proto.String("foo_id"),
+								Number:     proto.Int32(2),
+								Type:       typep(descriptor.FieldDescriptorProto_TYPE_STRING),
+								OneofIndex: proto.Int32(0),
+							},
+						},
+						OneofDecl: []*descriptor.OneofDescriptorProto{{Name: proto.String("source")}},
+					},
+				},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+
+	// The body is the member alone, read with the getter of the oneof
+	// member, and the other member of the oneof is a query param.
+	for _, want := range []string{
+		"body := req.GetFoo()",
+		"jsonReq, err := marshalOpts.Marshal(body)",
+		`params.Add("fooId", fmt.Sprintf("%v", req.GetFooId()))`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTOneofBody: missing %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, `params.Add("foo.size"`) {
+		t.Errorf("TestRESTOneofBody: want no body field as a query param, got:\n%s", got)
+	}
+}
+
+func TestRESTDisableIterators(t *testing.T) {
+	var g generator
+
+	foo := &descriptor.DescriptorProto{Name: proto.String("Foo")}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("page_size"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	res := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosResponse"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("foos"),
+				Number:   proto.Int32(1),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".foo.Foo"),
+				Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			},
+			{Name: proto.String("next_page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ListFoos"),
+		InputType:  proto.String(".foo.ListFoosRequest"),
+		OutputType: proto.String(".foo.ListFoosResponse"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/foos",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{foo, req, res},
+			},
+		},
+	})
+
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "ListFoosPage") {
+		t.Errorf("TestRESTDisableIterators: want no Page variant without rest-disable-iterators, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.disableIterators = true
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		// The iterator method is still generated.
+		"func (c *fooRESTClient) ListFoos(ctx context.Context, req *foopb.ListFoosRequest, opts ...gax.CallOption) *FooIterator {",
+		"func (c *fooRESTClient) ListFoosPage(ctx context.Context, req *foopb.ListFoosRequest, opts ...gax.CallOption) (*foopb.ListFoosResponse, string, error) {",
+		`return nil, "", e`,
+		"return resp, resp.GetNextPageToken(), nil",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTDisableIterators: missing %q, got:\n%s", want, got)
+		}
+	}
+	g.reset()
+
+	if err := g.genClientWrapperMethod(mthd, srv, "Foo"); err != nil {
+		t.Fatal(err)
+	}
+	want := "func (c *FooClient) ListFoosPage(ctx context.Context, req *foopb.ListFoosRequest, opts ...gax.CallOption) (*foopb.ListFoosResponse, string, error) {"
+	if got := g.pt.String(); !strings.Contains(got, want) || !strings.Contains(got, "return rc.ListFoosPage(ctx, req, opts...)") {
+		t.Errorf("TestRESTDisableIterators: want client wrapper %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTNoContent(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	noContent := strings.Index(got, "if httpRsp.StatusCode == http.StatusNoContent {")
+	read := strings.Index(got, "buf, err := ioutil.ReadAll(httpRsp.Body)")
+	if noContent < 0 || read < noContent {
+		t.Errorf("TestRESTNoContent: want 204 handled before reading the body, got:\n%s", got)
+	}
+	if want := "if len(buf) == 0 {"; !strings.Contains(got, want) {
+		t.Errorf("TestRESTNoContent: missing %q, got:\n%s", want, got)
+	}
+}
+
+func TestRESTDefaultTimeout(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "cctx, cancel := context.WithTimeout") {
+		t.Errorf("TestRESTDefaultTimeout: want no timeout without rest-default-timeout, got:\n%s", got)
+	}
+	g.reset()
+
+	g.opts.defaultTimeout = 30 * time.Second
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	// The timeout only applies when the context has no deadline of its own,
+	// and default deadlines are not disabled, as for gRPC methods.
+	for _, want := range []string{
+		"if _, ok := ctx.Deadline(); !ok && !c.disableDeadlines {",
+		"cctx, cancel := context.WithTimeout(ctx, 30000 * time.Millisecond)",
+		"ctx = cctx",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTDefaultTimeout: missing %q, got:\n%s", want, got)
+		}
+	}
+	if !strings.Contains(got[strings.Index(got, "ctx = cctx"):], "gax.Invoke(ctx,") {
+		t.Errorf("TestRESTDefaultTimeout: want the call to use the bounded context, got:\n%s", got)
+	}
+	if spec := (pbinfo.ImportSpec{Path: "time"}); !g.imports[spec] {
+		t.Errorf("TestRESTDefaultTimeout: missing import %v", spec)
+	}
 
-	foosField := &descriptor.FieldDescriptorProto{
-		Name:     proto.String("foos"),
-		Type:     descriptor.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
-		TypeName: proto.String(foofqn),
-		Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+	// The timeout of the service config takes precedence, as it does for
+	// gRPC methods.
+	data, err := protojson.Marshal(&conf.ServiceConfig{
+		MethodConfig: []*conf.MethodConfig{
+			{
+				Name:    []*conf.MethodConfig_Name{{Service: "identify.IdentifyMolluscService"}},
+				Timeout: &duration.Duration{Seconds: 10},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	nextPageTokenField := &descriptor.FieldDescriptorProto{
-		Name: proto.String("next_page_token"),
-		Type: descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
+	if g.grpcConf, err = conf.New(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
 	}
-	pagedFooRes := &descriptor.DescriptorProto{
-		Name:  proto.String("PagedFooResponse"),
-		Field: []*descriptor.FieldDescriptorProto{foosField, nextPageTokenField},
+	g.reset()
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
 	}
-	pagedFooResFQN := fmt.Sprintf(".%s.PagedFooResponse", pkg)
+	if got, want := g.pt.String(), "cctx, cancel := context.WithTimeout(ctx, 10000 * time.Millisecond)"; !strings.Contains(got, want) {
+		t.Errorf("TestRESTDefaultTimeout: missing %q, got:\n%s", want, got)
+	}
+}
 
-	nameOpts := &descriptor.FieldOptions{}
-	proto.SetExtension(nameOpts, extendedops.E_OperationField, extendedops.OperationResponseMapping_NAME)
-	nameField := &descriptor.FieldDescriptorProto{
-		Name:    proto.String("name"),
-		Type:    descriptor.FieldDescriptorProto_TYPE_STRING.Enum(),
-		Options: nameOpts,
+func TestRESTRetryIdempotent(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
 	}
-	op := &descriptor.DescriptorProto{
-		Name:  proto.String("Operation"),
-		Field: []*descriptor.FieldDescriptorProto{nameField},
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+	create := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("Create"),
+		InputType:  mthd.InputType,
+		OutputType: mthd.InputType,
+		Options:    &descriptor.MethodOptions{},
 	}
-	opfqn := fmt.Sprintf(".%s.Operation", pkg)
-
-	opRPCOpt := &descriptor.MethodOptions{}
-	proto.SetExtension(opRPCOpt, annotations.E_Http, &annotations.HttpRule{
+	proto.SetExtension(create.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "*",
 		Pattern: &annotations.HttpRule_Post{
-			Post: "/v1/foo",
+			Post: "/v1/kingdom",
 		},
 	})
-	proto.SetExtension(opRPCOpt, extendedops.E_OperationService, "FooOperationService")
+	serv.Method = append(serv.Method, create)
+	g.imports = map[pbinfo.ImportSpec]bool{}
 
-	opRPC := &descriptor.MethodDescriptorProto{
-		Name:       proto.String("CustomOp"),
-		InputType:  proto.String(foofqn),
-		OutputType: proto.String(opfqn),
-		Options:    opRPCOpt,
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	if got := g.pt.String(); strings.Contains(got, "RESTCallOptions") || strings.Contains(got, "onHTTPCodesOrTransient") {
+		t.Errorf("TestRESTRetryIdempotent: want no default retries without rest-retry-idempotent, got:\n%s", got)
 	}
+	g.reset()
 
-	emptyRPCOpt := &descriptor.MethodOptions{}
-	proto.SetExtension(emptyRPCOpt, annotations.E_Http, &annotations.HttpRule{
-		Pattern: &annotations.HttpRule_Delete{
-			Delete: "/v1/foo",
-		},
-	})
+	g.opts.retryIdempotent = true
+	g.restClientUtilities(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got := g.pt.String()
+	if want := "client := FooClient{CallOptions: defaultFooRESTCallOptions()}"; !strings.Contains(got, want) {
+		t.Errorf("TestRESTRetryIdempotent: missing %q, got:\n%s", want, got)
+	}
+	start := strings.Index(got, "func defaultFooRESTCallOptions() *FooCallOptions {")
+	if start < 0 {
+		t.Fatalf("TestRESTRetryIdempotent: missing defaultFooRESTCallOptions, got:\n%s", got)
+	}
+	opts := got[start:]
+	getAt, postAt := strings.Index(opts, "Identify: []gax.CallOption{"), strings.Index(opts, "Create: []gax.CallOption{")
+	if getAt < 0 || postAt < getAt {
+		t.Fatalf("TestRESTRetryIdempotent: want Identify then Create options, got:\n%s", opts)
+	}
+	// The GET method is retried, the POST one is not.
+	if get := opts[getAt:postAt]; !strings.Contains(get, "onHTTPCodesOrTransient(gax.Backoff{") || !strings.Contains(get, "http.StatusServiceUnavailable,") || !strings.Contains(get, "http.StatusTooManyRequests,") {
+		t.Errorf("TestRESTRetryIdempotent: want a retryer for GET, got:\n%s", get)
+	}
+	if post := opts[postAt:]; strings.Contains(post, "gax.WithRetry") {
+		t.Errorf("TestRESTRetryIdempotent: want no retryer for POST, got:\n%s", post)
+	}
+}
 
-	emptyRPC := &descriptor.MethodDescriptorProto{
-		Name:       proto.String("EmptyRPC"),
-		InputType:  proto.String(foofqn),
-		OutputType: proto.String(emptyType),
-		Options:    emptyRPCOpt,
+func TestRESTInterceptors(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
 	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
 
-	unaryRPCOpt := &descriptor.MethodOptions{}
-	proto.SetExtension(unaryRPCOpt, annotations.E_Http, &annotations.HttpRule{
-		Pattern: &annotations.HttpRule_Post{
-			Post: "/v1/foo",
-		},
-		Body: "*",
-	})
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	// The request is intercepted once complete, and the response before its
+	// status is checked.
+	last := 0
+	for _, want := range []string{
+		"httpReq.Header = headers",
+		"if err := c.interceptRequest(httpReq); err != nil {",
+		"httpRsp, err := c.httpClient.Do(httpReq)",
+		"return maybeTransient(err)",
+		"if err := c.interceptResponse(httpRsp); err != nil {",
+		"httpRsp.Body.Close()",
+		"googleapi.CheckResponse(httpRsp)",
+	} {
+		i := strings.Index(got[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTInterceptors: missing %q in order, got:\n%s", want, got)
+		}
+		last += i + len(want)
+	}
+	g.reset()
 
-	unaryRPC := &descriptor.MethodDescriptorProto{
-		Name:       proto.String("UnaryRPC"),
-		InputType:  proto.String(foofqn),
-		OutputType: proto.String(foofqn),
-		Options:    unaryRPCOpt,
+	g.restClientInit(serv, "Foo", pbinfo.ImportSpec{}, false)
+	got = g.pt.String()
+	for _, want := range []string{
+		"requestInterceptor: &client.RequestInterceptor,",
+		"responseInterceptor: &client.ResponseInterceptor,",
+		// A nil interceptor is a no-op.
+		"if c.requestInterceptor == nil || *c.requestInterceptor == nil {",
+		"if c.responseInterceptor == nil || *c.responseInterceptor == nil {",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTInterceptors: missing %q, got:\n%s", want, got)
+		}
 	}
+}
 
-	pagingRPCOpt := &descriptor.MethodOptions{}
-	proto.SetExtension(pagingRPCOpt, annotations.E_Http, &annotations.HttpRule{
+func TestRESTPrefetchPages(t *testing.T) {
+	var g generator
+
+	foo := &descriptor.DescriptorProto{Name: proto.String("Foo")}
+	req := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosRequest"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{Name: proto.String("page_size"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+			{Name: proto.String("page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+		},
+	}
+	res := &descriptor.DescriptorProto{
+		Name: proto.String("ListFoosResponse"),
+		Field: []*descriptor.FieldDescriptorProto{
+			{
+				Name:     proto.String("foos"),
+				Number:   proto.Int32(1),
+				Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+				TypeName: proto.String(".foo.Foo"),
+				Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+			},
+			{Name: proto.String("next_page_token"), Number: proto.Int32(2), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+			{Name: proto.String("total_size"), Number: proto.Int32(3), Type: typep(descriptor.FieldDescriptorProto_TYPE_INT32)},
+		},
+	}
+	mthd := &descriptor.MethodDescriptorProto{
+		Name:       proto.String("ListFoos"),
+		InputType:  proto.String(".foo.ListFoosRequest"),
+		OutputType: proto.String(".foo.ListFoosResponse"),
+		Options:    &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
 		Pattern: &annotations.HttpRule_Get{
-			Get: "/v1/foo",
+			Get: "/v1/foos",
+		},
+	})
+	srv := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{mthd},
+	}
+	g.init(&plugin.CodeGeneratorRequest{
+		Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+		ProtoFile: []*descriptor.FileDescriptorProto{
+			{
+				Package:     proto.String("foo"),
+				Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/foopb;foopb")},
+				Service:     []*descriptor.ServiceDescriptorProto{srv},
+				MessageType: []*descriptor.DescriptorProto{foo, req, res},
+			},
 		},
 	})
 
-	pagingRPC := &descriptor.MethodDescriptorProto{
-		Name:       proto.String("PagingRPC"),
-		InputType:  proto.String(pagedFooReqFQN),
-		OutputType: proto.String(pagedFooResFQN),
-		Options:    pagingRPCOpt,
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	if got := g.pt.String(); strings.Contains(got, "prefetched") {
+		t.Errorf("TestRESTPrefetchPages: want synchronous fetches without rest-prefetch-pages, got:\n%s", got)
 	}
+	g.reset()
 
-	s := &descriptor.ServiceDescriptorProto{
-		Name: proto.String("FooService"),
+	g.opts.prefetchPages = true
+	if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+		t.Fatal(err)
 	}
-	opS := &descriptor.ServiceDescriptorProto{
-		Name: proto.String("FooOperationService"),
+	got := g.pt.String()
+	for _, want := range []string{
+		"var lastResp *foopb.ListFoosResponse",
+		"lastResp = resp",
+		"return fetchedPage{items, nextPageToken, lastResp, err}",
+		// The buffer holds at most one page.
+		"prefetched = make(chan fetchedPage, 1)",
+		"if prefetched != nil && pageSize == prefetchedSize && pageToken == prefetchedToken {",
+		"page = <-prefetched",
+		"ch <- fetchPage(pageSize, pageToken)",
+		"it.Response = resp",
+		"it.TotalSize = int64(resp.GetTotalSize())",
+		"it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, bufLen, it.takeBuf)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTPrefetchPages: missing %q, got:\n%s", want, got)
+		}
+	}
+	// The next page is only requested once the caller reaches the last item
+	// of the current one, not as soon as the current one arrives.
+	start := strings.Index(got, "go func(ch chan<- fetchedPage")
+	if buf := strings.Index(got, "bufLen := func() int {"); start < 0 || buf < 0 || start < buf || !strings.Contains(got[buf:start], `if n == 1 && prefetched == nil && nextToken != "" {`) {
+		t.Errorf("TestRESTPrefetchPages: want the prefetch started from bufLen at the last item, got:\n%s", got)
+	}
+	// The iterator is only updated when a page is consumed, never from the
+	// background fetch.
+	fetch := got[strings.Index(got, "it.InternalFetch = func("):strings.Index(got, "type fetchedPage struct {")]
+	if strings.Contains(fetch, "it.Response") || strings.Contains(fetch, "it.TotalSize") {
+		t.Errorf("TestRESTPrefetchPages: want InternalFetch to leave the iterator alone, got:\n%s", fetch)
 	}
+}
 
-	f := &descriptor.FileDescriptorProto{
-		Package: proto.String(pkg),
-		Options: &descriptor.FileOptions{
-			GoPackage: proto.String("google.golang.org/genproto/cloud/foo/v1;foo"),
+func TestRESTEmptyRequest(t *testing.T) {
+	for _, tst := range []struct {
+		name string
+		body string
+	}{
+		{name: "get"},
+		{name: "body_all", body: "*"},
+	} {
+		var g generator
+		mthd, err := setupMethod(&g, "/v1/kingdoms", tst.body, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tst.body != "" {
+			proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+				Body:    tst.body,
+				Pattern: &annotations.HttpRule_Post{Post: "/v1/kingdoms"},
+			})
+		}
+		mthd.OutputType = mthd.InputType
+		serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+		g.imports = map[pbinfo.ImportSpec]bool{}
+		if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+			t.Fatalf("TestRESTEmptyRequest(%s): %v", tst.name, err)
+		}
+		got := g.pt.String()
+		for _, want := range []string{
+			`baseUrl.Path += fmt.Sprintf("/v1/kingdoms")`,
+			"return resp, nil",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestRESTEmptyRequest(%s): missing %q, got:\n%s", tst.name, want, got)
+			}
+		}
+		if strings.Contains(got, "params := url.Values{}") {
+			t.Errorf("TestRESTEmptyRequest(%s): want no query params, got:\n%s", tst.name, got)
+		}
+	}
+
+	// A path field cannot be bound to a request without fields.
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdoms/{kingdom}", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err == nil {
+		t.Errorf("TestRESTEmptyRequest: want error for missing path field, got:\n%s", g.pt.String())
+	}
+}
+
+func TestRESTBytesBody(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+		Body: "photo",
+		Pattern: &annotations.HttpRule_Post{
+			Post: "/v1/kingdom/{kingdom}:identify",
 		},
-		Service: []*descriptor.ServiceDescriptorProto{s, opS},
+	})
+	req := g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto)
+	req.Field = append(req.Field, &descriptor.FieldDescriptorProto{
+		Name:   proto.String("photo"),
+		Number: proto.Int32(2),
+		Type:   typep(descriptor.FieldDescriptorProto_TYPE_BYTES),
+	})
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	for _, want := range []string{
+		"body := req.GetPhoto()",
+		"jsonReq, err := json.Marshal(body)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("TestRESTBytesBody: missing %q, got:\n%s", want, got)
+		}
+	}
+	if !g.imports[pbinfo.ImportSpec{Path: "encoding/json"}] {
+		t.Errorf("TestRESTBytesBody: want encoding/json imported")
 	}
 
-	g := &generator{
-		aux: &auxTypes{
-			customOp: &customOp{
-				message: op,
-			},
-			iters: map[string]*iterType{},
+	// The body is the JSON value of the field, the base64 string protojson
+	// encodes bytes as.
+	photo := []byte("\x00squid\xff")
+	b, err := json.Marshal(photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := protojson.Marshal(wrapperspb.Bytes(photo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(pb) {
+		t.Errorf("TestRESTBytesBody: encoding/json gives %s, protojson %s", b, pb)
+	}
+}
+
+func TestRESTMapBody(t *testing.T) {
+	for _, tst := range []struct {
+		name      string
+		valueType descriptor.FieldDescriptorProto_Type
+		valueName string
+		want      string
+	}{
+		{
+			name:      "message",
+			valueType: descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+			valueName: ".identify.Squid",
+			want:      "b, err := marshalOpts.Marshal(v)",
 		},
-		opts: &options{},
-		customOpServices: map[*descriptor.ServiceDescriptorProto]*descriptor.ServiceDescriptorProto{
-			s: opS,
+		{
+			// protojson encodes 64-bit integers as strings.
+			name:      "int64",
+			valueType: descriptor.FieldDescriptorProto_TYPE_INT64,
+			want:      "b, err := json.Marshal(fmt.Sprint(v))",
 		},
-		descInfo: pbinfo.Info{
-			ParentFile: map[protoiface.MessageV1]*descriptor.FileDescriptorProto{
-				op:          f,
-				opS:         f,
-				opRPC:       f,
-				foo:         f,
-				s:           f,
-				pagedFooReq: f,
-				pagedFooRes: f,
+	} {
+		var g generator
+
+		squid := &descriptor.DescriptorProto{Name: proto.String("Squid")}
+		entry := &descriptor.DescriptorProto{
+			Name: proto.String("SquidsEntry"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{Name: proto.String("key"), Number: proto.Int32(1), Type: typep(descriptor.FieldDescriptorProto_TYPE_STRING)},
+				{Name: proto.String("value"), Number: proto.Int32(2), Type: typep(tst.valueType), TypeName: proto.String(tst.valueName)},
 			},
-			ParentElement: map[pbinfo.ProtoType]pbinfo.ProtoType{
-				opRPC:      s,
-				emptyRPC:   s,
-				unaryRPC:   s,
-				pagingRPC:  s,
-				nameField:  op,
-				sizeField:  foo,
-				otherField: foo,
+			Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+		}
+		req := &descriptor.DescriptorProto{
+			Name: proto.String("UpdateSquidsRequest"),
+			Field: []*descriptor.FieldDescriptorProto{
+				{
+					Name:     proto.String("squids"),
+					Number:   proto.Int32(1),
+					Type:     typep(descriptor.FieldDescriptorProto_TYPE_MESSAGE),
+					TypeName: proto.String(".identify.UpdateSquidsRequest.SquidsEntry"),
+					Label:    descriptor.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+				},
 			},
-			Type: map[string]pbinfo.ProtoType{
-				opfqn:          op,
-				foofqn:         foo,
-				emptyType:      protodesc.ToDescriptorProto((&emptypb.Empty{}).ProtoReflect().Descriptor()),
-				pagedFooReqFQN: pagedFooReq,
-				pagedFooResFQN: pagedFooRes,
+			NestedType: []*descriptor.DescriptorProto{entry},
+		}
+		mthd := &descriptor.MethodDescriptorProto{
+			Name:       proto.String("UpdateSquids"),
+			InputType:  proto.String(".identify.UpdateSquidsRequest"),
+			OutputType: proto.String(".identify.Squid"),
+			Options:    &descriptor.MethodOptions{},
+		}
+		proto.SetExtension(mthd.GetOptions(), annotations.E_Http, &annotations.HttpRule{
+			Body: "squids",
+			Pattern: &annotations.HttpRule_Put{
+				Put: "/v1/squids",
 			},
-		},
+		})
+		srv := &descriptor.ServiceDescriptorProto{
+			Name:   proto.String("SquidService"),
+			Method: []*descriptor.MethodDescriptorProto{mthd},
+		}
+		g.init(&plugin.CodeGeneratorRequest{
+			Parameter: proto.String("go-gapic-package=path;mypackage,transport=rest"),
+			ProtoFile: []*descriptor.FileDescriptorProto{
+				{
+					Package:     proto.String("identify"),
+					Options:     &descriptor.FileOptions{GoPackage: proto.String("path/to/identifypb;identifypb")},
+					Service:     []*descriptor.ServiceDescriptorProto{srv},
+					MessageType: []*descriptor.DescriptorProto{squid, req},
+				},
+			},
+		})
+
+		if err := g.genRESTMethod("Foo", srv, mthd); err != nil {
+			t.Fatalf("TestRESTMapBody(%s): %v", tst.name, err)
+		}
+		got := g.pt.String()
+		// The map is sent as a JSON object, keyed like protojson map keys.
+		for _, want := range []string{
+			"body := req.GetSquids()",
+			"elems := make(map[string]json.RawMessage, len(body))",
+			"for k, v := range body {",
+			tst.want,
+			"elems[fmt.Sprint(k)] = b",
+			"jsonReq, err := json.Marshal(elems)",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestRESTMapBody(%s): missing %q, got:\n%s", tst.name, want, got)
+			}
+		}
 	}
+}
+
+func TestRESTWithResponseStatus(t *testing.T) {
+	var g generator
+	mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mthd.OutputType = mthd.InputType
+	serv := g.descInfo.ParentElement[mthd].(*descriptor.ServiceDescriptorProto)
+	g.opts.withResponse = true
+
+	g.imports = map[pbinfo.ImportSpec]bool{}
+	if err := g.genRESTMethod("Foo", serv, mthd); err != nil {
+		t.Fatal(err)
+	}
+	got := g.pt.String()
+	variant := got[strings.Index(got, "// IdentifyWithResponse is like"):]
+	// Every 2xx response reaches the caller, whose StatusCode is left as is.
+	last := 0
+	for _, want := range []string{
+		"Its StatusCode tells apart the",
+		"httpResp = httpRsp",
+		"googleapi.CheckResponse(httpRsp)",
+		"return resp, httpResp, nil",
+	} {
+		i := strings.Index(variant[last:], want)
+		if i < 0 {
+			t.Fatalf("TestRESTWithResponseStatus: missing %q in order, got:\n%s", want, variant)
+		}
+		last += i + len(want)
+	}
+	if strings.Contains(variant, "StatusCode != http.StatusOK") {
+		t.Errorf("TestRESTWithResponseStatus: want every 2xx accepted, got:\n%s", variant)
+	}
+}
 
+func TestRESTNumericEnums(t *testing.T) {
 	for _, tst := range []struct {
-		name    string
-		method  *descriptor.MethodDescriptorProto
-		options *options
-		imports map[pbinfo.ImportSpec]bool
+		numericEnums bool
+		want         []string
 	}{
 		{
-			name:    "custom_op",
-			method:  opRPC,
-			options: &options{diregapic: true},
-			imports: map[pbinfo.ImportSpec]bool{
-				{Path: "google.golang.org/protobuf/encoding/protojson"}:          true,
-				{Path: "google.golang.org/api/googleapi"}:                        true,
-				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
-			},
-		},
-		{
-			name:    "empty_rpc",
-			method:  emptyRPC,
-			options: &options{},
-			imports: map[pbinfo.ImportSpec]bool{
-				{Path: "google.golang.org/api/googleapi"}:                        true,
-				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
-			},
-		},
-		{
-			name:    "unary_rpc",
-			method:  unaryRPC,
-			options: &options{},
-			imports: map[pbinfo.ImportSpec]bool{
-				{Path: "bytes"}: true,
-				{Path: "google.golang.org/protobuf/encoding/protojson"}:          true,
-				{Path: "google.golang.org/api/googleapi"}:                        true,
-				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			want: []string{
+				`baseUrl.Path += fmt.Sprintf("/v1/kingdom/%v", req.GetKingdom())`,
+				`params.Add("phylum", fmt.Sprintf("%v", req.GetPhylum()))`,
 			},
 		},
 		{
-			name:    "paging_rpc",
-			method:  pagingRPC,
-			options: &options{},
-			imports: map[pbinfo.ImportSpec]bool{
-				{Path: "math"}: true,
-				{Path: "google.golang.org/protobuf/encoding/protojson"}:          true,
-				{Path: "google.golang.org/api/googleapi"}:                        true,
-				{Path: "google.golang.org/api/iterator"}:                         true,
-				{Path: "google.golang.org/protobuf/proto"}:                       true,
-				{Name: "foopb", Path: "google.golang.org/genproto/cloud/foo/v1"}: true,
+			numericEnums: true,
+			want: []string{
+				`baseUrl.Path += fmt.Sprintf("/v1/kingdom/%v", int32(req.GetKingdom()))`,
+				`params.Add("phylum", fmt.Sprintf("%v", int32(req.GetPhylum())))`,
 			},
 		},
 	} {
-		s.Method = []*descriptor.MethodDescriptorProto{tst.method}
-		g.opts = tst.options
-		g.imports = make(map[pbinfo.ImportSpec]bool)
-
-		if err := g.genRESTMethod("Foo", s, tst.method); err != nil {
+		var g generator
+		mthd, err := setupMethod(&g, "/v1/kingdom/{kingdom}", "", []string{"kingdom", "phylum"})
+		if err != nil {
 			t.Fatal(err)
 		}
-
-		if diff := cmp.Diff(g.imports, tst.imports); diff != "" {
-			t.Errorf("TestGenRESTMethod(%s): imports got(-),want(+):\n%s", tst.name, diff)
+		for _, f := range g.descInfo.Type[mthd.GetInputType()].(*descriptor.DescriptorProto).GetField() {
+			f.Type = typep(descriptor.FieldDescriptorProto_TYPE_ENUM)
+			f.TypeName = proto.String(".identify.Taxon")
 		}
+		g.opts.numericEnums = tst.numericEnums
 
-		txtdiff.Diff(t, fmt.Sprintf("%s_%s", t.Name(), tst.name), g.pt.String(), filepath.Join("testdata", fmt.Sprintf("rest_%s.want", tst.method.GetName())))
-		g.reset()
+		if err := g.generateURLString(mthd, "nil, "); err != nil {
+			t.Fatal(err)
+		}
+		g.generateQueryString(mthd, "nil, ")
+		got := g.pt.String()
+		// Path and query params encode enums the same way.
+		for _, want := range tst.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("TestRESTNumericEnums(%v): missing %q, got:\n%s", tst.numericEnums, want, got)
+			}
+		}
+		if got := g.restMarshalOptions("AllowPartial: true"); strings.Contains(got, "UseEnumNumbers: true") != tst.numericEnums {
+			t.Errorf("TestRESTNumericEnums(%v): got marshal options %s", tst.numericEnums, got)
+		}
 	}
 }
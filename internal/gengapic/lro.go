@@ -78,70 +78,25 @@ func (g *generator) lroCall(servName string, m *descriptor.MethodDescriptorProto
 }
 
 func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) error {
-	mFQN := fmt.Sprintf("%s.%s.%s", g.descInfo.ParentFile[serv].GetPackage(), serv.GetName(), m.GetName())
 	lroType := lroTypeName(m.GetName())
 	p := g.printf
 
-	eLRO := proto.GetExtension(m.Options, longrunning.E_OperationInfo)
-	opInfo := eLRO.(*longrunning.OperationInfo)
-	fullName := opInfo.GetResponseType()
-	if fullName == "" {
-		return fmt.Errorf("rpc %q has google.longrunning.operation_info but is missing option google.longrunning.operation_info.response_type", mFQN)
-	}
-
-	var respType string
-	{
-		// eLRO.ResponseType is either fully-qualified or top-level in the same package as the method.
-		//
-		// TODO(ndietz) this won't work with nested message types in the same package;
-		// migrating to protoreflect will help remove from semantic meaning in the names.
-		if strings.IndexByte(fullName, '.') < 0 {
-			fullName = g.descInfo.ParentFile[serv].GetPackage() + "." + fullName
-		}
-
-		// When we build a map[name]Type in pbinfo, we prefix names with '.' to signify that they are fully qualified.
-		// The string in ResponseType does not have the prefix, so we add it.
-		fullName = "." + fullName
-
-		typ := g.descInfo.Type[fullName]
-		name, respSpec, err := g.descInfo.NameSpec(typ)
-		if err != nil {
-			return fmt.Errorf("unable to resolve google.longrunning.operation_info.response_type value %q in rpc %q", opInfo.GetResponseType(), mFQN)
-		}
-
-		if fullName != emptyType {
-			g.imports[respSpec] = true
-
-			respType = fmt.Sprintf("%s.%s", respSpec.Name, name)
-		}
-	}
-
-	hasMeta := opInfo.GetMetadataType() != ""
-	var metaType string
-	if hasMeta {
-		fullName := opInfo.GetMetadataType()
-		// TODO(ndietz) this won't work with nested message types in the same package;
-		// migrating to protoreflect will help remove from semantic meaning in the names.
-		if strings.IndexByte(fullName, '.') < 0 {
-			fullName = g.descInfo.ParentFile[serv].GetPackage() + "." + fullName
-		}
-		fullName = "." + fullName
-
-		typ := g.descInfo.Type[fullName]
-		name, meta, err := g.descInfo.NameSpec(typ)
-		if err != nil {
-			return fmt.Errorf("unable to resolve google.longrunning.operation_info.metadata_type value %q in rpc %q", opInfo.GetMetadataType(), mFQN)
-		}
-		g.imports[meta] = true
-
-		metaType = fmt.Sprintf("%s.%s", meta.Name, name)
+	opInfo := proto.GetExtension(m.Options, longrunning.E_OperationInfo).(*longrunning.OperationInfo)
+	respType, metaType, err := g.lroResultTypes(serv, m)
+	if err != nil {
+		return err
 	}
+	hasMeta := metaType != ""
+	hasREST := containsTransport(g.opts.transports, rest)
 
 	// Type definition
 	{
 		p("// %s manages a long-running operation from %s.", lroType, m.GetName())
 		p("type %s struct {", lroType)
 		p("  lro *longrunning.Operation")
+		if hasREST {
+			p("  pollPath string")
+		}
 		p("}")
 		p("")
 	}
@@ -163,8 +118,13 @@ func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorP
 				g.imports[pbinfo.ImportSpec{Name: "longrunningpb", Path: "google.golang.org/genproto/googleapis/longrunning"}] = true
 			case rest:
 				p("func (c *%s) %[2]s(name string) *%[2]s {", lowREST, lroType)
-				// TODO(dovs): return a non-empty and useful object
-				p("  return &%s{}", lroType)
+				p("  override := fmt.Sprintf(%q, name)", g.getOperationPathOverride())
+				p("  return &%s{", lroType)
+				p("    lro: longrunning.InternalNewOperation(*c.LROClient, &longrunningpb.Operation{Name: name}),")
+				p("    pollPath: override,")
+				p("  }")
+				g.imports[pbinfo.ImportSpec{Name: "longrunningpb", Path: "google.golang.org/genproto/googleapis/longrunning"}] = true
+				g.imports[pbinfo.ImportSpec{Path: "fmt"}] = true
 			}
 			p("}")
 
@@ -173,15 +133,19 @@ func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorP
 	}
 
 	// Wait
+	// WaitWithInterval already backs off exponentially between polls, up to
+	// the given maximum, whichever the transport.
 	{
 		p("// Wait blocks until the long-running operation is completed, returning the response and any errors encountered.")
 		p("//")
 		p("// See documentation of Poll for error-handling information.")
 		if opInfo.GetResponseType() == emptyValue {
 			p("func (op *%s) Wait(ctx context.Context, opts ...gax.CallOption) error {", lroType)
+			g.lroPollPath(hasREST)
 			p("  return op.lro.WaitWithInterval(ctx, nil, %s, opts...)", defaultPollMaxDelay)
 		} else {
 			p("func (op *%s) Wait(ctx context.Context, opts ...gax.CallOption) (*%s, error) {", lroType, respType)
+			g.lroPollPath(hasREST)
 			p("  var resp %s", respType)
 			p("  if err := op.lro.WaitWithInterval(ctx, &resp, %s, opts...); err != nil {", defaultPollMaxDelay)
 			p("    return nil, err")
@@ -194,6 +158,11 @@ func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorP
 		g.imports[pbinfo.ImportSpec{Path: "time"}] = true
 	}
 
+	// WaitWithBackoff
+	if hasREST {
+		g.lroWaitWithBackoff(lroType, respType, opInfo.GetResponseType() == emptyValue)
+	}
+
 	// Poll
 	{
 		p("// Poll fetches the latest state of the long-running operation.")
@@ -209,9 +178,11 @@ func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorP
 		p("// If Poll succeeds and the operation has not completed, the returned response and error are both nil.")
 		if opInfo.GetResponseType() == emptyValue {
 			p("func (op *%s) Poll(ctx context.Context, opts ...gax.CallOption) error {", lroType)
+			g.lroPollPath(hasREST)
 			p("  return op.lro.Poll(ctx, nil, opts...)")
 		} else {
 			p("func (op *%s) Poll(ctx context.Context, opts ...gax.CallOption) (*%s, error) {", lroType, respType)
+			g.lroPollPath(hasREST)
 			p("  var resp %s", respType)
 			p("  if err := op.lro.Poll(ctx, &resp, opts...); err != nil {")
 			p("    return nil, err")
@@ -264,6 +235,155 @@ func (g *generator) lroType(servName string, serv *descriptor.ServiceDescriptorP
 	return nil
 }
 
+// lroWaitWithBackoff generates WaitWithBackoff, which lets REST callers
+// configure the pause between GetOperation calls with a gax.Backoff. Wait
+// is left as is, so that gRPC clients keep the polling of WaitWithInterval.
+func (g *generator) lroWaitWithBackoff(lroType, respType string, empty bool) {
+	p := g.printf
+
+	ret, errRet := fmt.Sprintf("(*%s, error)", respType), "nil, err"
+	if empty {
+		ret, errRet = "error", "err"
+	}
+
+	p("// WaitWithBackoff is like Wait, but pauses between polls as given by bo, whose")
+	p("// pauses grow exponentially with jitter. Unset fields of bo default to an Initial")
+	p("// pause of %s, a Multiplier of %s and a Max pause of %s.", defaultPollInitialDelay, defaultPollMultiplier, defaultPollMaxDelay)
+	p("//")
+	p("// See documentation of Poll for error-handling information.")
+	p("func (op *%s) WaitWithBackoff(ctx context.Context, bo gax.Backoff, opts ...gax.CallOption) %s {", lroType, ret)
+	p("  if bo.Initial == 0 {")
+	p("    bo.Initial = %s", defaultPollInitialDelay)
+	p("  }")
+	p("  if bo.Max == 0 {")
+	p("    bo.Max = %s", defaultPollMaxDelay)
+	p("  }")
+	p("  if bo.Multiplier == 0 {")
+	p("    bo.Multiplier = %s", defaultPollMultiplier)
+	p("  }")
+	p("  for {")
+	if empty {
+		p("    if err := op.Poll(ctx, opts...); err != nil {")
+		p("      return err")
+		p("    }")
+		p("    if op.Done() {")
+		p("      return nil")
+		p("    }")
+	} else {
+		p("    resp, err := op.Poll(ctx, opts...)")
+		p("    if err != nil {")
+		p("      return nil, err")
+		p("    }")
+		p("    if op.Done() {")
+		p("      return resp, nil")
+		p("    }")
+	}
+	p("    if err := gax.Sleep(ctx, bo.Pause()); err != nil {")
+	p("      return %s", errRet)
+	p("    }")
+	p("  }")
+	p("}")
+	p("")
+
+	g.imports[pbinfo.ImportSpec{Path: "time"}] = true
+}
+
+// lroPollPath prepends the poll path of the operation to the CallOptions of
+// a REST poll. The gRPC transport ignores it.
+func (g *generator) lroPollPath(hasREST bool) {
+	if hasREST {
+		g.printf("  opts = append([]gax.CallOption{gax.WithPath(op.pollPath)}, opts...)")
+	}
+}
+
+// getOperationPathOverride returns the format of the path of the
+// GetOperation method of the Operations mixin, which takes the name of the
+// operation, e.g. "/v1/%s" for "/v1/{name=projects/*/operations/*}". The
+// default binding of the mixin is used if the Service config has no rule for
+// it.
+func (g *generator) getOperationPathOverride() string {
+	tmpl := "/v1/{name=operations/**}"
+	for _, m := range g.mixins["google.longrunning.Operations"] {
+		if info := getHTTPInfo(m); m.GetName() == "GetOperation" && info != nil {
+			tmpl = info.url
+		}
+	}
+	// The name of the operation carries the segments matched by the
+	// variable, so it replaces the whole of it.
+	return urlParamRegexp.ReplaceAllString(strings.ReplaceAll(tmpl, "%", "%%"), "%s")
+}
+
+// lroResultTypes resolves the response and metadata types named by the
+// google.longrunning.operation_info of m to Go type names, and imports them.
+// respType is empty if the response is google.protobuf.Empty, and metaType
+// if there is no metadata type.
+func (g *generator) lroResultTypes(serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) (respType, metaType string, err error) {
+	respType, metaType, specs, err := g.lroResultSpecs(serv, m)
+	for _, spec := range specs {
+		g.imports[spec] = true
+	}
+	return respType, metaType, err
+}
+
+// lroResultSpecs is lroResultTypes without the imports, which it returns in
+// specs instead, so that the annotation can be validated on its own.
+func (g *generator) lroResultSpecs(serv *descriptor.ServiceDescriptorProto, m *descriptor.MethodDescriptorProto) (respType, metaType string, specs []pbinfo.ImportSpec, err error) {
+	mFQN := fmt.Sprintf("%s.%s.%s", g.descInfo.ParentFile[serv].GetPackage(), serv.GetName(), m.GetName())
+
+	eLRO := proto.GetExtension(m.Options, longrunning.E_OperationInfo)
+	opInfo := eLRO.(*longrunning.OperationInfo)
+	fullName := opInfo.GetResponseType()
+	if fullName == "" {
+		return "", "", nil, fmt.Errorf("rpc %q has google.longrunning.operation_info but is missing option google.longrunning.operation_info.response_type", mFQN)
+	}
+
+	{
+		// eLRO.ResponseType is either fully-qualified or top-level in the same package as the method.
+		//
+		// TODO(ndietz) this won't work with nested message types in the same package;
+		// migrating to protoreflect will help remove from semantic meaning in the names.
+		if strings.IndexByte(fullName, '.') < 0 {
+			fullName = g.descInfo.ParentFile[serv].GetPackage() + "." + fullName
+		}
+
+		// When we build a map[name]Type in pbinfo, we prefix names with '.' to signify that they are fully qualified.
+		// The string in ResponseType does not have the prefix, so we add it.
+		fullName = "." + fullName
+
+		typ := g.descInfo.Type[fullName]
+		name, respSpec, err := g.descInfo.NameSpec(typ)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("unable to resolve google.longrunning.operation_info.response_type value %q in rpc %q", opInfo.GetResponseType(), mFQN)
+		}
+
+		if fullName != emptyType {
+			specs = append(specs, respSpec)
+
+			respType = fmt.Sprintf("%s.%s", respSpec.Name, name)
+		}
+	}
+
+	if opInfo.GetMetadataType() != "" {
+		fullName := opInfo.GetMetadataType()
+		// TODO(ndietz) this won't work with nested message types in the same package;
+		// migrating to protoreflect will help remove from semantic meaning in the names.
+		if strings.IndexByte(fullName, '.') < 0 {
+			fullName = g.descInfo.ParentFile[serv].GetPackage() + "." + fullName
+		}
+		fullName = "." + fullName
+
+		typ := g.descInfo.Type[fullName]
+		name, meta, err := g.descInfo.NameSpec(typ)
+		if err != nil {
+			return "", "", nil, fmt.Errorf("unable to resolve google.longrunning.operation_info.metadata_type value %q in rpc %q", opInfo.GetMetadataType(), mFQN)
+		}
+		specs = append(specs, meta)
+
+		metaType = fmt.Sprintf("%s.%s", meta.Name, name)
+	}
+	return respType, metaType, specs, nil
+}
+
 func lroTypeName(methodName string) string {
 	return methodName + "Operation"
 }
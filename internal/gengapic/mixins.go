@@ -61,11 +61,12 @@ func (g *generator) collectMixins() {
 // that should be generated for a client. In order for a method to be included
 // for generation, it must have a google.api.http defined in the Service config
 // http.rules section. The MethodDescriptorProto.options are overwritten with
-// that same google.api.http binding. Furthermore, a basic leading comment is
-// defined for the method to be generated.
+// that same google.api.http binding. Any further rules for the same selector
+// become additional bindings, and a rule without a pattern of its own falls
+// back to the binding declared by the mixin API. Furthermore, a basic leading
+// comment is defined for the method to be generated.
 func (g *generator) collectMixinMethods(api string) []*descriptor.MethodDescriptorProto {
 	methods := map[string]*descriptor.MethodDescriptorProto{}
-	methodsToGenerate := []*descriptor.MethodDescriptorProto{}
 
 	// Note: Triple nested loops are nasty, but this is tightly bound and really
 	// the only way to traverse proto descriptors that are backed by slices.
@@ -83,15 +84,7 @@ func (g *generator) collectMixinMethods(api string) []*descriptor.MethodDescript
 	}
 
 	// Overwrite the google.api.http annotations with bindings from the Service config.
-	for _, rule := range g.serviceConfig.GetHttp().GetRules() {
-		m, match := methods[rule.GetSelector()]
-		if !match {
-			continue
-		}
-
-		proto.SetExtension(m.Options, annotations.E_Http, rule)
-		methodsToGenerate = append(methodsToGenerate, m)
-	}
+	methodsToGenerate := applyHTTPRules(methods, g.serviceConfig.GetHttp().GetRules())
 
 	// Include any documentation from the Service config.
 	for _, rule := range g.serviceConfig.GetDocumentation().GetRules() {
@@ -217,6 +210,75 @@ func (g *generator) checkIAMPolicyOverrides(servs []*descriptor.ServiceDescripto
 	}
 }
 
+// applyHTTPRuleOverrides replaces the google.api.http annotation of any of the
+// given services' methods that is targeted by a Service config http rule, so
+// that the Service config takes precedence over the proto-level annotation.
+// Multiple rules for the same selector are merged as additional bindings.
+func (g *generator) applyHTTPRuleOverrides(servs []*descriptor.ServiceDescriptorProto) {
+	rules := g.serviceConfig.GetHttp().GetRules()
+	if len(rules) == 0 {
+		return
+	}
+
+	methods := map[string]*descriptor.MethodDescriptorProto{}
+	for _, s := range servs {
+		pkg := g.descInfo.ParentFile[s].GetPackage()
+		for _, m := range s.GetMethod() {
+			methods[fmt.Sprintf("%s.%s.%s", pkg, s.GetName(), m.GetName())] = m
+		}
+	}
+
+	applyHTTPRules(methods, rules)
+}
+
+// applyHTTPRules sets the google.api.http annotation of each of the methods,
+// keyed by fully-qualified name, that is targeted by one of rules, and returns
+// them in the order of their first rule. Any further rules for the same
+// selector become additional bindings, and a rule without a pattern of its own
+// falls back to the binding declared by the method, without which it is
+// skipped, as REST cannot support the method.
+func applyHTTPRules(methods map[string]*descriptor.MethodDescriptorProto, rules []*annotations.HttpRule) []*descriptor.MethodDescriptorProto {
+	bindings := map[string]*annotations.HttpRule{}
+	var applied []*descriptor.MethodDescriptorProto
+	var selectors []string
+	for _, rule := range rules {
+		m, match := methods[rule.GetSelector()]
+		if !match {
+			continue
+		}
+
+		if b, ok := bindings[rule.GetSelector()]; ok {
+			b.AdditionalBindings = append(b.AdditionalBindings, rule)
+			continue
+		}
+
+		// Clone so that additional bindings are not appended to the
+		// Service config or to the method's own annotation.
+		b := proto.Clone(rule).(*annotations.HttpRule)
+		if b.GetPattern() == nil {
+			def, _ := proto.GetExtension(m.GetOptions(), annotations.E_Http).(*annotations.HttpRule)
+			if def.GetPattern() == nil {
+				continue
+			}
+			additional := b.GetAdditionalBindings()
+			b = proto.Clone(def).(*annotations.HttpRule)
+			b.Selector = rule.GetSelector()
+			b.AdditionalBindings = append(b.AdditionalBindings, additional...)
+		}
+
+		bindings[rule.GetSelector()] = b
+		selectors = append(selectors, rule.GetSelector())
+		applied = append(applied, m)
+	}
+	for i, m := range applied {
+		if m.Options == nil {
+			m.Options = &descriptor.MethodOptions{}
+		}
+		proto.SetExtension(m.Options, annotations.E_Http, bindings[selectors[i]])
+	}
+	return applied
+}
+
 // includeMixinInputFile determines if the given proto file name matches
 // a known mixin file and indicates if it should be included in the
 // protos-to-be-generated file set based on if the package is using it for
@@ -19,6 +19,7 @@ import (
 
 	"github.com/golang/protobuf/protoc-gen-go/descriptor"
 	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/gapic-generator-go/internal/pbinfo"
 	"google.golang.org/genproto/googleapis/api/annotations"
 	"google.golang.org/genproto/googleapis/api/serviceconfig"
 	"google.golang.org/protobuf/proto"
@@ -108,6 +109,83 @@ func TestCollectMixins(t *testing.T) {
 			t.Errorf("TestCollectMixins(%q) got(-),want(+):\n%s", want.api, diff)
 		}
 	}
+
+	// Mixin methods are only collected when the Service config provides an
+	// HTTP rule for them, so they must always be transcodable for REST.
+	for _, m := range g.getMixinMethods() {
+		if info := getHTTPInfo(m); info == nil || info.url == "" {
+			t.Errorf("TestCollectMixins(%q) collected mixin method without HTTP info", m.GetName())
+		}
+	}
+}
+
+func TestCollectMixinsAdditionalBindings(t *testing.T) {
+	getOperation := &annotations.HttpRule{
+		Selector: "google.longrunning.Operations.GetOperation",
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/{name=projects/*/operations/*}",
+		},
+	}
+	getOperationAlt := &annotations.HttpRule{
+		Selector: "google.longrunning.Operations.GetOperation",
+		Pattern: &annotations.HttpRule_Get{
+			Get: "/v1/{name=folders/*/operations/*}",
+		},
+	}
+	// A rule without a pattern keeps the binding declared by the mixin API.
+	getLocation := &annotations.HttpRule{
+		Selector: "google.cloud.location.Locations.GetLocation",
+		AdditionalBindings: []*annotations.HttpRule{
+			{Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=folders/*/locations/*}"}},
+		},
+	}
+	var getLocationMethod *descriptor.MethodDescriptorProto
+	for _, m := range locationMethods() {
+		if m.GetName() == "GetLocation" {
+			getLocationMethod = m
+		}
+	}
+	defaultLocation := proto.Clone(proto.GetExtension(getLocationMethod.GetOptions(), annotations.E_Http).(*annotations.HttpRule)).(*annotations.HttpRule)
+
+	g := generator{
+		comments: make(map[protoiface.MessageV1]string),
+		mixins:   make(mixins),
+		serviceConfig: &serviceconfig.Service{
+			Apis: []*apipb.Api{
+				{Name: "google.longrunning.Operations"},
+				{Name: "google.cloud.location.Locations"},
+			},
+			Http: &annotations.Http{
+				Rules: []*annotations.HttpRule{getOperation, getOperationAlt, getLocation},
+			},
+		},
+	}
+
+	g.collectMixins()
+
+	ops := g.mixins["google.longrunning.Operations"]
+	if len(ops) != 1 {
+		t.Fatalf("TestCollectMixinsAdditionalBindings got %d operations method(s), want 1", len(ops))
+	}
+	want := proto.Clone(getOperation).(*annotations.HttpRule)
+	want.AdditionalBindings = []*annotations.HttpRule{getOperationAlt}
+	if got := proto.GetExtension(ops[0].GetOptions(), annotations.E_Http); !cmp.Equal(got, want, cmp.Comparer(proto.Equal)) {
+		t.Errorf("TestCollectMixinsAdditionalBindings(GetOperation) got %v, want %v", got, want)
+	}
+	if len(getOperation.GetAdditionalBindings()) != 0 {
+		t.Errorf("TestCollectMixinsAdditionalBindings modified the Service config rule: %v", getOperation)
+	}
+
+	locs := g.mixins["google.cloud.location.Locations"]
+	if len(locs) != 1 {
+		t.Fatalf("TestCollectMixinsAdditionalBindings got %d location method(s), want 1", len(locs))
+	}
+	want = defaultLocation
+	want.Selector = getLocation.GetSelector()
+	want.AdditionalBindings = append(want.AdditionalBindings, getLocation.GetAdditionalBindings()...)
+	if got := proto.GetExtension(locs[0].GetOptions(), annotations.E_Http); !cmp.Equal(got, want, cmp.Comparer(proto.Equal)) {
+		t.Errorf("TestCollectMixinsAdditionalBindings(GetLocation) got %v, want %v", got, want)
+	}
 }
 
 func TestGetMixinFiles(t *testing.T) {
@@ -196,6 +274,135 @@ func TestCheckIAMPolicyOverrides(t *testing.T) {
 	}
 }
 
+func TestApplyHTTPRuleOverrides(t *testing.T) {
+	protoRule := &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=foos/*}"},
+	}
+	getFoo := &descriptor.MethodDescriptorProto{
+		Name:    proto.String("GetFoo"),
+		Options: &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(getFoo.Options, annotations.E_Http, protoRule)
+	getBar := &descriptor.MethodDescriptorProto{Name: proto.String("GetBar")}
+	listBars := &descriptor.MethodDescriptorProto{Name: proto.String("ListBars")}
+
+	foo := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("FooService"),
+		Method: []*descriptor.MethodDescriptorProto{getFoo},
+	}
+	bar := &descriptor.ServiceDescriptorProto{
+		Name:   proto.String("BarService"),
+		Method: []*descriptor.MethodDescriptorProto{getBar, listBars},
+	}
+	file := &descriptor.FileDescriptorProto{Package: proto.String("foo.v1")}
+
+	g := &generator{
+		descInfo: pbinfo.Info{
+			ParentFile: map[protoiface.MessageV1]*descriptor.FileDescriptorProto{
+				foo: file,
+				bar: file,
+			},
+		},
+		serviceConfig: &serviceconfig.Service{
+			Http: &annotations.Http{
+				Rules: []*annotations.HttpRule{
+					{
+						Selector: "foo.v1.FooService.GetFoo",
+						Pattern:  &annotations.HttpRule_Post{Post: "/v2/{name=foos/*}:get"},
+						Body:     "*",
+					},
+					{
+						Selector: "foo.v1.BarService.GetBar",
+						Pattern:  &annotations.HttpRule_Get{Get: "/v2/{name=bars/*}"},
+					},
+					{
+						Selector: "foo.v1.BarService.GetBar",
+						Pattern:  &annotations.HttpRule_Get{Get: "/v2/{name=projects/*/bars/*}"},
+					},
+					{
+						Selector: "foo.v1.OtherService.GetBaz",
+						Pattern:  &annotations.HttpRule_Get{Get: "/v2/{name=bazs/*}"},
+					},
+				},
+			},
+		},
+	}
+	g.applyHTTPRuleOverrides([]*descriptor.ServiceDescriptorProto{foo, bar})
+
+	for _, tst := range []struct {
+		m          *descriptor.MethodDescriptorProto
+		verb, url  string
+		body       string
+		additional int
+	}{
+		{m: getFoo, verb: "post", url: "/v2/{name=foos/*}:get", body: "*"},
+		{m: getBar, verb: "get", url: "/v2/{name=bars/*}", additional: 1},
+	} {
+		info := getHTTPInfo(tst.m)
+		if info == nil {
+			t.Errorf("TestApplyHTTPRuleOverrides(%s): missing http rule", tst.m.GetName())
+			continue
+		}
+		if info.verb != tst.verb || info.url != tst.url || info.body != tst.body {
+			t.Errorf("TestApplyHTTPRuleOverrides(%s) = %s %s %q, want %s %s %q", tst.m.GetName(), info.verb, info.url, info.body, tst.verb, tst.url, tst.body)
+		}
+		rule := proto.GetExtension(tst.m.GetOptions(), annotations.E_Http).(*annotations.HttpRule)
+		if got := len(rule.GetAdditionalBindings()); got != tst.additional {
+			t.Errorf("TestApplyHTTPRuleOverrides(%s) got %d additional bindings, want %d", tst.m.GetName(), got, tst.additional)
+		}
+	}
+
+	if listBars.GetOptions() != nil {
+		t.Errorf("TestApplyHTTPRuleOverrides(ListBars) got unexpected options %v", listBars.GetOptions())
+	}
+	if got := protoRule.GetGet(); got != "/v1/{name=foos/*}" {
+		t.Errorf("TestApplyHTTPRuleOverrides modified the original annotation: %s", got)
+	}
+}
+
+func TestApplyHTTPRules(t *testing.T) {
+	declared := &descriptor.MethodDescriptorProto{
+		Name:    proto.String("GetFoo"),
+		Options: &descriptor.MethodOptions{},
+	}
+	proto.SetExtension(declared.Options, annotations.E_Http, &annotations.HttpRule{
+		Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=foos/*}"},
+	})
+	undeclared := &descriptor.MethodDescriptorProto{Name: proto.String("GetBar")}
+	listed := &descriptor.MethodDescriptorProto{Name: proto.String("ListFoos")}
+	methods := map[string]*descriptor.MethodDescriptorProto{
+		"foo.v1.FooService.GetFoo":   declared,
+		"foo.v1.FooService.GetBar":   undeclared,
+		"foo.v1.FooService.ListFoos": listed,
+	}
+
+	// Both the mixins and the overrides of the services' own methods are
+	// merged here, so a rule without a pattern behaves the same for either.
+	got := applyHTTPRules(methods, []*annotations.HttpRule{
+		{
+			Selector: "foo.v1.FooService.ListFoos",
+			Pattern:  &annotations.HttpRule_Get{Get: "/v1/foos"},
+		},
+		{
+			Selector:           "foo.v1.FooService.GetFoo",
+			AdditionalBindings: []*annotations.HttpRule{{Pattern: &annotations.HttpRule_Get{Get: "/v1/{name=projects/*/foos/*}"}}},
+		},
+		{Selector: "foo.v1.FooService.GetBar"},
+	})
+	if want := []*descriptor.MethodDescriptorProto{listed, declared}; !cmp.Equal(got, want, cmp.Comparer(proto.Equal)) {
+		t.Errorf("TestApplyHTTPRules got methods %v, want %v", got, want)
+	}
+	if info := getHTTPInfo(declared); info == nil || info.url != "/v1/{name=foos/*}" || len(info.bindings) != 1 {
+		t.Errorf("TestApplyHTTPRules(GetFoo) got %+v, want the declared binding with one additional binding", info)
+	}
+	if info := getHTTPInfo(listed); info == nil || info.url != "/v1/foos" {
+		t.Errorf("TestApplyHTTPRules(ListFoos) got %+v, want /v1/foos", info)
+	}
+	if undeclared.GetOptions() != nil {
+		t.Errorf("TestApplyHTTPRules(GetBar) got unexpected options %v", undeclared.GetOptions())
+	}
+}
+
 func TestHasLocationMixin(t *testing.T) {
 	g := generator{
 		mixins: mixins{
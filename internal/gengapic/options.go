@@ -19,6 +19,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/googleapis/gapic-generator-go/internal/errors"
 )
@@ -43,6 +44,35 @@ type options struct {
 	transports        []transport
 	metadata          bool
 	diregapic         bool
+	restBuildTag      string
+	omitConnection    bool
+	flattenedMethods  bool
+	validateRequired  bool
+	restTracing       bool
+	autoUpdateMask    bool
+	omitMetadata      bool
+	validateResources bool
+	withResponse      bool
+	separateREST      bool
+	debugLogging      bool
+	redactHeaders     []string
+	streamMedia       bool
+	emitUnpopulated   bool
+	mediaUpload       bool
+	protoNames        bool
+	partialResponse   bool
+	regionalEndpoint  bool
+	restMetrics       bool
+	disableIterators  bool
+	defaultTimeout    time.Duration
+	retryIdempotent   bool
+	prefetchPages     bool
+	numericEnums      bool
+	headerPrefix      string
+	callEndpoint      bool
+	etagHeaders       bool
+	requestID         bool
+	pageTokenField    string
 }
 
 // parseOptions takes a string and parses it into a struct defining
@@ -56,6 +86,35 @@ type options struct {
 // * release-level (one of 'alpha', 'beta', or empty)
 // * transport ('+' separated list of transport backends to generate)
 // * metadata (enable GAPIC metadata generation)
+// * rest-build-tag (build constraint for a separate REST client file)
+// * omit-grpc-connection (drop the deprecated Connection method from clients)
+// * flattened-methods (add wrappers taking the fields of each google.api.method_signature)
+// * rest-validate-required (check REQUIRED REST params are set before sending)
+// * rest-tracing (start a trace span named after the RPC in each REST method)
+// * rest-auto-update-mask (derive an unset PATCH update mask from the body)
+// * rest-omit-grpc-metadata (build REST headers without gRPC metadata, REST-only)
+// * rest-validate-resource-names (check REST resource names match their patterns)
+// * rest-with-response (add FooWithResponse variants returning the *http.Response, and RateLimitOf)
+// * rest-separate-file (generate each REST client in its own file)
+// * rest-debug-logging (log REST requests, with sensitive headers redacted)
+// * rest-redact-headers (';' separated list of extra headers to redact in logs)
+// * rest-stream-media (add FooMedia variants streaming google.api.HttpBody responses)
+// * rest-emit-unpopulated (include zero values in REST request bodies)
+// * rest-media-upload (generate resumable upload variants of /upload/ methods)
+// * rest-proto-names (use proto field names in REST bodies and query params)
+// * rest-partial-response (generate the WithFields call option for REST methods)
+// * rest-regional-endpoint (generate an option targeting a regional REST endpoint)
+// * rest-metrics (record OpenTelemetry metrics of REST requests)
+// * rest-disable-iterators (generate Page variants of paginated REST methods)
+// * rest-default-timeout (duration bounding REST calls without a context deadline)
+// * rest-retry-idempotent (retry GET, PUT and DELETE REST methods on 5xx and 429)
+// * rest-prefetch-pages (fetch the next page of REST iterators in the background)
+// * rest-numeric-enums (send enums by number in REST URLs and bodies)
+// * rest-header-prefix (prefix replacing x-goog- in the REST api-client header)
+// * rest-call-endpoint (generate the WithCallEndpoint call option for REST methods)
+// * rest-etag (send a request etag as If-Match and read ETag into the response)
+// * rest-request-id (populate an unset UUID4 idempotency token once per call, for every retry to reuse)
+// * rest-page-token-field (name of the page token field of paginated requests, if not page_token, REST-only)
 // The only required option is 'go-gapic-package'.
 //
 // Valid parameter example:
@@ -85,6 +144,78 @@ func parseOptions(parameter *string) (*options, error) {
 		case "diregapic":
 			opts.diregapic = true
 			continue
+		case "omit-grpc-connection":
+			opts.omitConnection = true
+			continue
+		case "flattened-methods":
+			opts.flattenedMethods = true
+			continue
+		case "rest-validate-required":
+			opts.validateRequired = true
+			continue
+		case "rest-tracing":
+			opts.restTracing = true
+			continue
+		case "rest-auto-update-mask":
+			opts.autoUpdateMask = true
+			continue
+		case "rest-omit-grpc-metadata":
+			opts.omitMetadata = true
+			continue
+		case "rest-validate-resource-names":
+			opts.validateResources = true
+			continue
+		case "rest-with-response":
+			opts.withResponse = true
+			continue
+		case "rest-separate-file":
+			opts.separateREST = true
+			continue
+		case "rest-debug-logging":
+			opts.debugLogging = true
+			continue
+		case "rest-stream-media":
+			opts.streamMedia = true
+			continue
+		case "rest-emit-unpopulated":
+			opts.emitUnpopulated = true
+			continue
+		case "rest-media-upload":
+			opts.mediaUpload = true
+			continue
+		case "rest-proto-names":
+			opts.protoNames = true
+			continue
+		case "rest-partial-response":
+			opts.partialResponse = true
+			continue
+		case "rest-regional-endpoint":
+			opts.regionalEndpoint = true
+			continue
+		case "rest-metrics":
+			opts.restMetrics = true
+			continue
+		case "rest-disable-iterators":
+			opts.disableIterators = true
+			continue
+		case "rest-retry-idempotent":
+			opts.retryIdempotent = true
+			continue
+		case "rest-prefetch-pages":
+			opts.prefetchPages = true
+			continue
+		case "rest-numeric-enums":
+			opts.numericEnums = true
+			continue
+		case "rest-call-endpoint":
+			opts.callEndpoint = true
+			continue
+		case "rest-etag":
+			opts.etagHeaders = true
+			continue
+		case "rest-request-id":
+			opts.requestID = true
+			continue
 		}
 
 		e := strings.IndexByte(s, '=')
@@ -120,6 +251,26 @@ func parseOptions(parameter *string) (*options, error) {
 			opts.modulePrefix = val
 		case "release-level":
 			opts.relLvl = strings.ToLower(val)
+		case "rest-build-tag":
+			opts.restBuildTag = val
+		case "rest-redact-headers":
+			opts.redactHeaders = strings.Split(val, ";")
+		case "rest-default-timeout":
+			d, err := time.ParseDuration(val)
+			if err != nil || d <= 0 {
+				return nil, errors.E(nil, "invalid rest-default-timeout, must be a positive duration: %s", val)
+			}
+			opts.defaultTimeout = d
+		case "rest-page-token-field":
+			opts.pageTokenField = val
+		case "rest-header-prefix":
+			// The prefix becomes part of a header name, so it must be a token.
+			if strings.IndexFunc(val, func(r rune) bool {
+				return !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			}) >= 0 {
+				return nil, errors.E(nil, "invalid rest-header-prefix, must only have letters, digits and dashes: %s", val)
+			}
+			opts.headerPrefix = val
 		case "transport":
 			// Prevent duplicates
 			transports := map[transport]bool{}
@@ -17,6 +17,7 @@ package gengapic
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestParseOptions(t *testing.T) {
@@ -75,6 +76,295 @@ func TestParseOptions(t *testing.T) {
 			},
 			expectErr: false,
 		},
+		{
+			param: "transport=rest+grpc,rest-build-tag=rest,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{grpc, rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				restBuildTag: "rest",
+			},
+		},
+		{
+			param: "transport=rest,omit-grpc-connection,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:     []transport{rest},
+				pkgPath:        "path",
+				pkgName:        "pkg",
+				outDir:         "path",
+				omitConnection: true,
+			},
+		},
+		{
+			param: "transport=rest,flattened-methods,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:       []transport{rest},
+				pkgPath:          "path",
+				pkgName:          "pkg",
+				outDir:           "path",
+				flattenedMethods: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-validate-required,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:       []transport{rest},
+				pkgPath:          "path",
+				pkgName:          "pkg",
+				outDir:           "path",
+				validateRequired: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-tracing,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:  []transport{rest},
+				pkgPath:     "path",
+				pkgName:     "pkg",
+				outDir:      "path",
+				restTracing: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-auto-update-mask,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:     []transport{rest},
+				pkgPath:        "path",
+				pkgName:        "pkg",
+				outDir:         "path",
+				autoUpdateMask: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-omit-grpc-metadata,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				omitMetadata: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-validate-resource-names,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:        []transport{rest},
+				pkgPath:           "path",
+				pkgName:           "pkg",
+				outDir:            "path",
+				validateResources: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-with-response,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				withResponse: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-separate-file,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				separateREST: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-debug-logging,rest-redact-headers=x-foo-token;X-Bar,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:    []transport{rest},
+				pkgPath:       "path",
+				pkgName:       "pkg",
+				outDir:        "path",
+				debugLogging:  true,
+				redactHeaders: []string{"x-foo-token", "X-Bar"},
+			},
+		},
+		{
+			param: "transport=rest,rest-stream-media,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:  []transport{rest},
+				pkgPath:     "path",
+				pkgName:     "pkg",
+				outDir:      "path",
+				streamMedia: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-emit-unpopulated,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:      []transport{rest},
+				pkgPath:         "path",
+				pkgName:         "pkg",
+				outDir:          "path",
+				emitUnpopulated: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-media-upload,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:  []transport{rest},
+				pkgPath:     "path",
+				pkgName:     "pkg",
+				outDir:      "path",
+				mediaUpload: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-proto-names,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports: []transport{rest},
+				pkgPath:    "path",
+				pkgName:    "pkg",
+				outDir:     "path",
+				protoNames: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-partial-response,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:      []transport{rest},
+				pkgPath:         "path",
+				pkgName:         "pkg",
+				outDir:          "path",
+				partialResponse: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-regional-endpoint,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:       []transport{rest},
+				pkgPath:          "path",
+				pkgName:          "pkg",
+				outDir:           "path",
+				regionalEndpoint: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-metrics,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:  []transport{rest},
+				pkgPath:     "path",
+				pkgName:     "pkg",
+				outDir:      "path",
+				restMetrics: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-disable-iterators,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:       []transport{rest},
+				pkgPath:          "path",
+				pkgName:          "pkg",
+				outDir:           "path",
+				disableIterators: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-default-timeout=30s,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:     []transport{rest},
+				pkgPath:        "path",
+				pkgName:        "pkg",
+				outDir:         "path",
+				defaultTimeout: 30 * time.Second,
+			},
+		},
+		{
+			param: "transport=rest,rest-retry-idempotent,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:      []transport{rest},
+				pkgPath:         "path",
+				pkgName:         "pkg",
+				outDir:          "path",
+				retryIdempotent: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-prefetch-pages,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:    []transport{rest},
+				pkgPath:       "path",
+				pkgName:       "pkg",
+				outDir:        "path",
+				prefetchPages: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-numeric-enums,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				numericEnums: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-header-prefix=x-acme-,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				headerPrefix: "x-acme-",
+			},
+		},
+		{
+			param: "transport=rest,rest-call-endpoint,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:   []transport{rest},
+				pkgPath:      "path",
+				pkgName:      "pkg",
+				outDir:       "path",
+				callEndpoint: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-etag,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:  []transport{rest},
+				pkgPath:     "path",
+				pkgName:     "pkg",
+				outDir:      "path",
+				etagHeaders: true,
+			},
+		},
+		{
+			param: "transport=rest,rest-request-id,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports: []transport{rest},
+				pkgPath:    "path",
+				pkgName:    "pkg",
+				outDir:     "path",
+				requestID:  true,
+			},
+		},
+		{
+			param: "transport=rest,rest-page-token-field=cursor,go-gapic-package=path;pkg",
+			expectedOpts: &options{
+				transports:     []transport{rest},
+				pkgPath:        "path",
+				pkgName:        "pkg",
+				outDir:         "path",
+				pageTokenField: "cursor",
+			},
+		},
+		{
+			param:     "rest-default-timeout=soon,go-gapic-package=path;pkg",
+			expectErr: true,
+		},
+		{
+			param:     "rest-header-prefix=x acme,go-gapic-package=path;pkg",
+			expectErr: true,
+		},
 		{
 			param:     "transport=tcp,go-gapic-package=path;pkg",
 			expectErr: true,
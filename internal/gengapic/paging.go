@@ -31,6 +31,10 @@ type iterType struct {
 	// Otherwise, len(elemImports)==0.
 	elemImports []pbinfo.ImportSpec
 	generated   bool
+
+	// totalSize is set if a response paged by this iterator reports the
+	// total number of results in a total_size field.
+	totalSize bool
 }
 
 // iterTypeOf deduces iterType from a field to be iterated over.
@@ -61,6 +65,7 @@ func (g *generator) iterTypeOf(elemField *descriptor.FieldDescriptorProto) (*ite
 		// and override these defaults.
 		pt.elemTypeName = fmt.Sprintf("*%s.%s", imp.Name, typeName)
 		pt.iterTypeName = typeName + "Iterator"
+		pt.elemImports = []pbinfo.ImportSpec{imp}
 
 		if eMsg.GetOptions().GetMapEntry() {
 			var valueField *descriptor.FieldDescriptorProto
@@ -78,13 +83,16 @@ func (g *generator) iterTypeOf(elemField *descriptor.FieldDescriptorProto) (*ite
 			// but check in case it's a primitive.
 			if valueField.GetType() == descriptor.FieldDescriptorProto_TYPE_MESSAGE {
 				vType := g.descInfo.Type[valueField.GetTypeName()]
-				n, imp, err := g.descInfo.NameSpec(vType)
+				n, vImp, err := g.descInfo.NameSpec(vType)
 				if err != nil {
 					return nil, err
 				}
 
-				pt.mapValueTypeName = fmt.Sprintf("*%s.%s", imp.Name, n)
+				pt.mapValueTypeName = fmt.Sprintf("*%s.%s", vImp.Name, n)
 				pt.elemTypeName = fmt.Sprintf("%sPair", n)
+				// The map value type may be defined in a different package
+				// than the response message that holds the map.
+				pt.elemImports = append(pt.elemImports, vImp)
 			} else {
 				pt.mapValueTypeName = pbinfo.GoTypeForPrim[valueField.GetType()]
 				pt.elemTypeName = fmt.Sprintf("%sPair", upperFirst(pt.mapValueTypeName))
@@ -92,8 +100,6 @@ func (g *generator) iterTypeOf(elemField *descriptor.FieldDescriptorProto) (*ite
 			pt.iterTypeName = pt.elemTypeName + "Iterator"
 		}
 
-		pt.elemImports = []pbinfo.ImportSpec{imp}
-
 	case t == descriptor.FieldDescriptorProto_TYPE_ENUM:
 		log.Panic("iterating enum not supported yet")
 
@@ -157,7 +163,7 @@ func (g *generator) getPagingFields(m *descriptor.MethodDescriptorProto) (repeat
 		return nil, nil, errors.E(nil, "cannot find message type %q, malformed descriptor", m.GetOutputType())
 	}
 
-	hasPageToken := false
+	hasPageToken := g.pageTokenField(inMsg) != nil
 	for _, f := range inMsg.GetField() {
 		isInt32 := f.GetType() == descriptor.FieldDescriptorProto_TYPE_INT32 || f.GetType() == descriptor.FieldDescriptorProto_TYPE_UINT32
 		if (f.GetName() == "page_size" || f.GetName() == "max_results") && isInt32 {
@@ -165,10 +171,7 @@ func (g *generator) getPagingFields(m *descriptor.MethodDescriptorProto) (repeat
 				return nil, nil, errors.E(nil, "found both page_size and max_results fields in message %q", m.GetInputType())
 			}
 			pageSizeField = f
-			continue
 		}
-
-		hasPageToken = hasPageToken || (f.GetName() == "page_token" && f.GetType() == descriptor.FieldDescriptorProto_TYPE_STRING)
 	}
 
 	if !hasPageToken || pageSizeField == nil {
@@ -224,7 +227,59 @@ func (g *generator) maybeSortMapPage(elemField *descriptor.FieldDescriptorProto,
 	return elems
 }
 
-func (g *generator) makeFetchAndIterUpdate(pageSizeFieldName string) {
+// pageTokenField returns the page_token field of the paginated request
+// message msg, or nil if msg has none. When generating a REST client, the
+// field named by the rest-page-token-field option, if set, is used instead.
+func (g *generator) pageTokenField(msg *descriptor.DescriptorProto) *descriptor.FieldDescriptorProto {
+	name := "page_token"
+	if g.opts.pageTokenField != "" && containsTransport(g.opts.transports, rest) {
+		name = g.opts.pageTokenField
+	}
+	for _, f := range msg.GetField() {
+		if f.GetName() == name && f.GetType() == descriptor.FieldDescriptorProto_TYPE_STRING && f.GetLabel() != fieldLabelRepeated {
+			return f
+		}
+	}
+	return nil
+}
+
+// totalSizeField returns the integer total_size field of msg, or nil if
+// there is none.
+func totalSizeField(msg *descriptor.DescriptorProto) *descriptor.FieldDescriptorProto {
+	for _, f := range msg.GetField() {
+		if f.GetName() != "total_size" || f.GetLabel() == fieldLabelRepeated {
+			continue
+		}
+		switch f.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_INT32, descriptor.FieldDescriptorProto_TYPE_INT64,
+			descriptor.FieldDescriptorProto_TYPE_UINT32, descriptor.FieldDescriptorProto_TYPE_UINT64,
+			descriptor.FieldDescriptorProto_TYPE_SINT32, descriptor.FieldDescriptorProto_TYPE_SINT64,
+			descriptor.FieldDescriptorProto_TYPE_FIXED32, descriptor.FieldDescriptorProto_TYPE_FIXED64,
+			descriptor.FieldDescriptorProto_TYPE_SFIXED32, descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+			return f
+		}
+	}
+	return nil
+}
+
+// pagingTotalSize emits the update of the iterator's TotalSize from the
+// total_size reported in resp, if outType has one. Iterators generated
+// earlier, for another service in the package, lack the field and are left
+// as is.
+func (g *generator) pagingTotalSize(outType *descriptor.DescriptorProto, pt *iterType) {
+	f := totalSizeField(outType)
+	if f == nil {
+		return
+	}
+	if !pt.generated {
+		pt.totalSize = true
+	}
+	if pt.totalSize {
+		g.printf("  it.TotalSize = int64(resp.Get%s())", snakeToCamel(f.GetName()))
+	}
+}
+
+func (g *generator) makeFetchAndIterUpdate(pageSizeFieldName, pageTokenFieldName string) {
 	p := g.printf
 
 	p("fetch := func(pageSize int, pageToken string) (string, error) {")
@@ -238,17 +293,17 @@ func (g *generator) makeFetchAndIterUpdate(pageSizeFieldName string) {
 	p("")
 	p("it.pageInfo, it.nextFunc = iterator.NewPageInfo(fetch, it.bufLen, it.takeBuf)")
 	p("it.pageInfo.MaxSize = int(req.Get%s())", pageSizeFieldName)
-	p("it.pageInfo.Token = req.GetPageToken()")
+	p("it.pageInfo.Token = req.Get%s()", pageTokenFieldName)
 	p("")
 	p("return it")
 }
 
-func (g *generator) internalFetchSetup(outType *descriptor.DescriptorProto, outSpec pbinfo.ImportSpec, tok, pageSizeFieldName, max, ps string) {
+func (g *generator) internalFetchSetup(outType *descriptor.DescriptorProto, outSpec pbinfo.ImportSpec, tok, pageTokenFieldName, pageSizeFieldName, max, ps string) {
 	p := g.printf
 
 	p("  resp := &%s.%s{}", outSpec.Name, outType.GetName())
 	p(`  if pageToken != "" {`)
-	p("    req.PageToken = %s", tok)
+	p("    req.%s = %s", pageTokenFieldName, tok)
 	p("  }")
 	p("  if pageSize > math.MaxInt32 {")
 	p("    req.%s = %s", pageSizeFieldName, max)
@@ -276,15 +331,17 @@ func (g *generator) pagingCall(servName string, m *descriptor.MethodDescriptorPr
 
 	max := "math.MaxInt32"
 	ps := "int32(pageSize)"
-	if isOptional(inType, "page_size") {
+	if isOptional(inType, pageSize.GetName()) {
 		max = fmt.Sprintf("proto.Int32(%s)", max)
 		ps = fmt.Sprintf("proto.Int32(%s)", ps)
 	}
 
+	pageToken := g.pageTokenField(inType)
 	tok := "pageToken"
-	if isOptional(inType, "page_token") {
+	if pageToken.GetProto3Optional() {
 		tok = fmt.Sprintf("proto.String(%s)", tok)
 	}
+	pageTokenFieldName := snakeToCamel(pageToken.GetName())
 
 	p := g.printf
 	p("func (c *%s) %s(ctx context.Context, req *%s.%s, opts ...gax.CallOption) *%s {",
@@ -299,7 +356,7 @@ func (g *generator) pagingCall(servName string, m *descriptor.MethodDescriptorPr
 	p("it := &%s{}", pt.iterTypeName)
 	p("req = proto.Clone(req).(*%s.%s)", inSpec.Name, inType.GetName())
 	p("it.InternalFetch = func(pageSize int, pageToken string) ([]%s, string, error) {", pt.elemTypeName)
-	g.internalFetchSetup(outType, outSpec, tok, pageSizeFieldName, max, ps)
+	g.internalFetchSetup(outType, outSpec, tok, pageTokenFieldName, pageSizeFieldName, max, ps)
 	p("  err := gax.Invoke(ctx, func(ctx context.Context, settings gax.CallSettings) error {")
 	p("    var err error")
 	p("    resp, err = %s", g.grpcStubCall(m))
@@ -310,10 +367,11 @@ func (g *generator) pagingCall(servName string, m *descriptor.MethodDescriptorPr
 	p("  }")
 	p("")
 	p("  it.Response = resp")
+	g.pagingTotalSize(outType, pt)
 	elems := g.maybeSortMapPage(elemField, pt)
 	p("  return %s, resp.GetNextPageToken(), nil", elems)
 	p("}")
-	g.makeFetchAndIterUpdate(pageSizeFieldName)
+	g.makeFetchAndIterUpdate(pageSizeFieldName, pageTokenFieldName)
 	p("}")
 	p("")
 
@@ -348,6 +406,13 @@ func (g *generator) pagingIter(pt *iterType) {
 	p("  // Calling Next() or InternalFetch() updates this value.")
 	p("  Response interface{}")
 	p("")
+	if pt.totalSize {
+		p("  // TotalSize is the total number of results reported by the server with")
+		p("  // the current page. Unlike PageInfo().Remaining(), which only counts")
+		p("  // buffered results, it covers all pages.")
+		p("  TotalSize int64")
+		p("")
+	}
 	p("  // InternalFetch is for use by the Google Cloud Libraries only.")
 	p("  // It is not part of the stable interface of this package.")
 	p("  //")
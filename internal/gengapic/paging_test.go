@@ -127,7 +127,7 @@ func TestPagingField(t *testing.T) {
 	// The predicate is simple:
 	// * No streaming in the method
 	// * Request has a int32 page_size field XOR a int32 max_results field
-	// * Request has a string page_token field
+	// * Request has a string page token field, see TestPageTokenField
 	// * Response has a string next_page_token field
 	// * Response has one and only one repeated or map<string, *> field
 
@@ -441,3 +441,45 @@ func TestPagingField(t *testing.T) {
 		}
 	}
 }
+
+func TestPageTokenField(t *testing.T) {
+	field := func(name string, typ descriptor.FieldDescriptorProto_Type) *descriptor.FieldDescriptorProto {
+		return &descriptor.FieldDescriptorProto{
+			Name: proto.String(name),
+			Type: typep(typ),
+		}
+	}
+	str := descriptor.FieldDescriptorProto_TYPE_STRING
+	pageToken := field("page_token", str)
+	nextPageToken := field("next_page_token", str)
+	cursor := field("cursor", str)
+	maxResults := field("max_results", descriptor.FieldDescriptorProto_TYPE_INT32)
+	repeatedCursor := field("cursor", str)
+	repeatedCursor.Label = labelp(descriptor.FieldDescriptorProto_LABEL_REPEATED)
+
+	for _, tst := range []struct {
+		name      string
+		config    string
+		transport transport
+		fields    []*descriptor.FieldDescriptorProto
+		want      *descriptor.FieldDescriptorProto
+	}{
+		{name: "page_token", fields: []*descriptor.FieldDescriptorProto{maxResults, pageToken}, want: pageToken},
+		{name: "next_page_token", fields: []*descriptor.FieldDescriptorProto{maxResults, nextPageToken}},
+		{name: "configured", config: "cursor", transport: rest, fields: []*descriptor.FieldDescriptorProto{pageToken, cursor}, want: cursor},
+		{name: "configured missing", config: "cursor", transport: rest, fields: []*descriptor.FieldDescriptorProto{maxResults, pageToken}},
+		{name: "configured repeated", config: "cursor", transport: rest, fields: []*descriptor.FieldDescriptorProto{repeatedCursor}},
+		{name: "configured grpc", config: "cursor", transport: grpc, fields: []*descriptor.FieldDescriptorProto{pageToken, cursor}, want: pageToken},
+		{name: "not configured", fields: []*descriptor.FieldDescriptorProto{maxResults, cursor}},
+		{name: "not a string", fields: []*descriptor.FieldDescriptorProto{field("page_token", descriptor.FieldDescriptorProto_TYPE_INT32)}},
+	} {
+		g := generator{opts: &options{pageTokenField: tst.config, transports: []transport{tst.transport}}}
+		msg := &descriptor.DescriptorProto{
+			Name:  proto.String("ListFoosRequest"),
+			Field: tst.fields,
+		}
+		if got := g.pageTokenField(msg); got != tst.want {
+			t.Errorf("TestPageTokenField(%s): got %v, want %v", tst.name, got, tst.want)
+		}
+	}
+}